@@ -0,0 +1,421 @@
+package main
+
+// Agent and collector modes let several machines be reported on together.
+// An agent scans locally and ships the result to a collector, which keeps
+// the latest result for each machine and serves the combined report.
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// the address the collector listens on by default
+const defaultCollectorAddr = ":8484"
+
+// MachineResult is the result of scanning a single machine.
+type MachineResult struct {
+	MachineID string    `json:"machine_id"`
+	User      string    `json:"user,omitempty"` // the user whose files were scanned, if not everyone's
+	Scanned   time.Time `json:"scanned"`        // when the scan started, so files modified during it count for -since
+	Result    *Result   `json:"result"`
+}
+
+// the interval between mDNS queries for agents by default
+const defaultDiscoverInterval = time.Minute
+
+// how long to wait for agents to respond to an mDNS query
+const discoverTimeout = 3 * time.Second
+
+// the largest result the collector accepts from an agent, as sent and once
+// decompressed, so a small gzip bomb can't use up its memory
+const (
+	maxResultBodyBytes = 32 * OneMb
+	maxResultBytes     = 256 * OneMb
+)
+
+// scans this machine and sends the result to a collector, or serves it for a
+// collector to discover
+func defineAgent(flags *flag.FlagSet) func() error {
+	collector := flags.String("collector", "", "URL of the collector, eg http://192.168.1.10:8484")
+	serve := flags.String("serve", "", "address to serve the result on for collectors that discover agents, eg :8485")
+	machineID := flags.String("machine-id", "", "identifies this machine to the collector, an anonymous id kept for this machine if not set")
+	sec := addSecurityFlags(flags)
+	return func() error {
+		if *collector == "" && *serve == "" {
+			return errors.New("agent requires -collector or -serve")
+		}
+		if *machineID == "" {
+			var err error
+			*machineID, err = localMachineID()
+			if err != nil {
+				return err
+			}
+		}
+		root := flags.Arg(0)
+		if root == "" {
+			var err error
+			root, err = homeDir()
+			if err != nil {
+				return err
+			}
+		}
+		meter := newProgressMeter()
+		var server *agentServer
+		served := make(chan error, 1)
+		if *serve != "" {
+			// serve from the start, so progress can be followed during the scan
+			server = &agentServer{meter: meter}
+			go func() {
+				served <- server.serve(sec, *serve, *machineID)
+			}()
+		}
+		fmt.Println("Gathering stats for", root)
+		start := time.Now()
+		m := MachineResult{
+			MachineID: *machineID,
+			Scanned:   start,
+			Result:    scan(context.Background(), root, scanOptions{progress: meter, links: newLinkSet()}),
+		}
+		if *collector != "" {
+			fmt.Println("Sending result to", *collector)
+			client, err := sec.client()
+			if err != nil {
+				return err
+			}
+			if err := sendResult(client, sec, *collector, m); err != nil {
+				return err
+			}
+		}
+		if server != nil {
+			server.setResult(m)
+			return <-served
+		}
+		return nil
+	}
+}
+
+// serves an agent's result once its scan is done, and the progress of the
+// scan until then
+type agentServer struct {
+	meter  *progressMeter
+	mu     sync.Mutex // guards result
+	result *MachineResult
+}
+
+func (s *agentServer) setResult(m MachineResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.result = &m
+}
+
+func (s *agentServer) getResult() *MachineResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.result
+}
+
+// serves the machine result at /result and the scan's progress as a
+// WebSocket at /progress, and advertises them over mDNS
+func (s *agentServer) serve(sec *security, addr string, machineID string) error {
+	listener, useTLS, err := sec.listen(addr)
+	if err != nil {
+		return err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	go func() {
+		if err := mdnsAdvertise(machineID, port, useTLS); err != nil {
+			fmt.Println("mDNS advertising stopped:", err)
+		}
+	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/result", func(w http.ResponseWriter, r *http.Request) {
+		m := s.getResult()
+		if m == nil {
+			http.Error(w, "scan in progress", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		defer zw.Close()
+		json.NewEncoder(zw).Encode(m)
+	})
+	mux.HandleFunc("/progress", s.serveProgress)
+	fmt.Println("Serving result on", listener.Addr())
+	return http.Serve(listener, sec.requireToken(mux))
+}
+
+// streams the progress of the scan over a WebSocket as a json message each
+// interval, ending with a message that has done set once the result is ready
+func (s *agentServer) serveProgress(w http.ResponseWriter, r *http.Request) {
+	conn, rw, err := upgradeWebSocket(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer conn.Close()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		snapshot := s.meter.snapshot()
+		snapshot.Done = s.getResult() != nil
+		message, _ := json.Marshal(snapshot)
+		if err := writeWebSocketText(rw, message); err != nil {
+			return
+		}
+		if snapshot.Done {
+			writeWebSocketClose(rw)
+			return
+		}
+		<-ticker.C
+	}
+}
+
+// fetches the machine result served by an agent
+func fetchResult(client *http.Client, sec *security, agent mdnsAgent) (MachineResult, error) {
+	var m MachineResult
+	// the advertisement can come from any host on the network, so it only
+	// explains a failure and doesn't choose the scheme
+	scheme := "http://"
+	if sec.clientTLS() {
+		scheme = "https://"
+	} else if agent.tls {
+		return m, errors.New("the agent serves over TLS, trust it with -tls-ca or -tls-fingerprint")
+	}
+	req, err := http.NewRequest("GET", scheme+agent.addr+"/result", nil)
+	if err != nil {
+		return m, err
+	}
+	if err := sec.authorize(req); err != nil {
+		return m, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return m, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return m, fmt.Errorf("agent responded with %v", resp.Status)
+	}
+	// the agent can be any host on the network, and the transport unzips a
+	// gzipped response, so a small response can expand without limit. Read
+	// one byte past the limit to tell a result of exactly the limit from a
+	// larger one.
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResultBytes+1))
+	if err != nil {
+		return m, err
+	}
+	if int64(len(data)) > maxResultBytes {
+		return m, fmt.Errorf("agent result is larger than %v MB", maxResultBytes/OneMb)
+	}
+	err = json.Unmarshal(data, &m)
+	return m, err
+}
+
+// posts a gzipped machine result to a collector
+func sendResult(client *http.Client, sec *security, collector string, m MachineResult) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if err := json.NewEncoder(zw).Encode(m); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", collector+"/results", &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	if err := sec.authorize(req); err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// collector keeps the latest result for each machine
+type collector struct {
+	mu       sync.Mutex
+	machines map[string]MachineResult
+}
+
+// receives results from agents and serves the combined report
+func defineCollector(flags *flag.FlagSet) func() error {
+	addr := flags.String("listen", defaultCollectorAddr, "address to listen on")
+	discover := flags.Bool("discover", false, "discover agents on the local network using mDNS")
+	interval := flags.Duration("discover-interval", defaultDiscoverInterval, "time between searches for agents")
+	profiles := flags.String("profiles", "", "json file of named scan profiles for the collector to scan itself")
+	sec := addSecurityFlags(flags)
+	return func() error {
+		c := &collector{machines: map[string]MachineResult{}}
+		listener, _, err := sec.listen(*addr)
+		if err != nil {
+			return err
+		}
+		if *discover {
+			client, err := sec.client()
+			if err != nil {
+				return err
+			}
+			go c.discover(client, sec, *interval)
+		}
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", c.serveReport)
+		mux.HandleFunc("/results", c.serveResults)
+		mux.HandleFunc("/totals", c.serveTotals)
+		mux.HandleFunc("/chart/", c.serveChart)
+		if *profiles != "" {
+			runners, err := loadProfiles(*profiles)
+			if err != nil {
+				return err
+			}
+			if err := startProfiles(runners); err != nil {
+				return err
+			}
+			serveProfiles(mux, runners)
+		}
+		fmt.Println("Collector listening on", listener.Addr())
+		return http.Serve(listener, sec.requireToken(mux))
+	}
+}
+
+// regularly searches for agents and fetches their results
+func (c *collector) discover(client *http.Client, sec *security, interval time.Duration) {
+	for {
+		agents, err := mdnsDiscover(discoverTimeout)
+		if err != nil {
+			fmt.Println("mDNS discovery failed:", err)
+		}
+		for _, agent := range agents {
+			m, err := fetchResult(client, sec, agent)
+			if err != nil {
+				fmt.Println("Fetching result from", agent.instance, "at", agent.addr, "failed:", err)
+				continue
+			}
+			c.add(m)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// adds a machine result, replacing any older result from the same machine
+func (c *collector) add(m MachineResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	existing, exists := c.machines[m.MachineID]
+	if exists && !m.Scanned.After(existing.Scanned) {
+		return
+	}
+	if exists {
+		fmt.Println("New scan from machine", m.MachineID)
+	} else {
+		fmt.Println("New machine", m.MachineID)
+	}
+	c.machines[m.MachineID] = m
+}
+
+// returns the machine results sorted by machine id
+func (c *collector) results() []MachineResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results := []MachineResult{}
+	for _, m := range c.machines {
+		results = append(results, m)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].MachineID < results[j].MachineID
+	})
+	return results
+}
+
+// serves the combined report for all machines as text
+func (c *collector) serveReport(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	results := c.results()
+	combined := NewResult()
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "Machines:", len(results))
+	for _, m := range results {
+		fmt.Fprintf(w, "%v  %v files  %v chunks  scanned %v\n",
+			m.MachineID, m.Result.Files, m.Result.TotalChunks, m.Scanned.Format(time.RFC3339))
+		combined.Merge(m.Result)
+	}
+	fmt.Fprintln(w)
+	combined.Report(w)
+}
+
+// returns the status for an error reading a posted result, which is 413 if
+// the body was larger than allowed
+func resultErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// receives results from agents, and serves all machine results as json
+func (c *collector) serveResults(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.results())
+	case "POST":
+		var body io.Reader = http.MaxBytesReader(w, r.Body, maxResultBodyBytes)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				http.Error(w, err.Error(), resultErrorStatus(err))
+				return
+			}
+			defer zr.Close()
+			body = zr
+		}
+		// read one byte past the limit to tell a result of exactly the
+		// limit from a larger one
+		data, err := io.ReadAll(io.LimitReader(body, maxResultBytes+1))
+		if err != nil {
+			http.Error(w, err.Error(), resultErrorStatus(err))
+			return
+		}
+		if int64(len(data)) > maxResultBytes {
+			http.Error(w, "result is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		var m MachineResult
+		if err := json.Unmarshal(data, &m); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if m.MachineID == "" || m.Result == nil {
+			http.Error(w, "missing machine_id or result", http.StatusBadRequest)
+			return
+		}
+		c.add(m)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
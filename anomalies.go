@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+)
+
+const (
+	// files smaller than this are tiny
+	tinyFileSize = 4 * OneKb
+	// a directory with more tiny files than this is flagged
+	tinyFilesPerDir = 100000
+	// a file with more than this percent of all chunks is flagged
+	fileChunkPercent = 10
+)
+
+// anomalyFinder looks for things in a scan that are worth a closer look,
+// since they may be distorting the result or be unintended.
+type anomalyFinder struct {
+	tiny        map[string]int64 // tiny files in each directory
+	sparse      []file
+	largest     string // the file with the most chunks
+	largestSize int64
+	mostChunks  int64
+	// counts tiny files in a low memory scan, so tiny only holds the
+	// directories with too many, if set
+	tinySketch *countMinSketch
+}
+
+func newAnomalyFinder() *anomalyFinder {
+	return &anomalyFinder{tiny: map[string]int64{}}
+}
+
+// returns a finder that counts tiny files in a fixed amount of memory, the
+// counts being estimates that can be too high
+func newLowMemoryAnomalyFinder() *anomalyFinder {
+	return &anomalyFinder{tiny: map[string]int64{}, tinySketch: newCountMinSketch(lowMemoryCounters)}
+}
+
+// adds a file that was counted with the given number of chunks
+func (a *anomalyFinder) add(f file, chunks int64) {
+	size := f.info.Size()
+	if size < tinyFileSize {
+		dir := path.Dir(f.path)
+		if a.tinySketch == nil {
+			a.tiny[dir] = a.tiny[dir] + 1
+		} else if count := a.tinySketch.add(dir); count > tinyFilesPerDir {
+			a.tiny[dir] = count
+		}
+	}
+	if chunks > a.mostChunks {
+		a.largest = f.path
+		a.largestSize = size
+		a.mostChunks = chunks
+	}
+	if isSparse(f) {
+		a.sparse = append(a.sparse, f)
+	}
+}
+
+// adds the files found by another finder, such as one for another worker of
+// the same scan. Each directory's files are all found by one worker, so
+// the tiny files counted for a directory are never split between finders.
+func (a *anomalyFinder) merge(other *anomalyFinder) {
+	for dir, count := range other.tiny {
+		a.tiny[dir] = a.tiny[dir] + count
+	}
+	if other.mostChunks > a.mostChunks {
+		a.largest = other.largest
+		a.largestSize = other.largestSize
+		a.mostChunks = other.mostChunks
+	}
+	a.sparse = append(a.sparse, other.sparse...)
+}
+
+// returns the anomalies found, given the total chunks in the scan
+func (a *anomalyFinder) anomalies(totalChunks int64) []Warning {
+	anomalies := []Warning{}
+	dirs := []string{}
+	for dir, count := range a.tiny {
+		if count > tinyFilesPerDir {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		anomalies = append(anomalies, Warning{
+			Code:    "many_tiny_files",
+			Subject: dir,
+			Message: fmt.Sprintf("%v files smaller than %v KB, consider bundling them", a.tiny[dir], tinyFileSize/OneKb),
+		})
+	}
+	if share := percent(a.mostChunks, totalChunks); share > fileChunkPercent {
+		anomalies = append(anomalies, Warning{
+			Code:    "large_chunk_share",
+			Subject: a.largest,
+			Message: fmt.Sprintf("%.1f%% of all chunks from one %.2f GB file", share, float64(a.largestSize)/float64(OneGb)),
+		})
+	}
+	for _, f := range a.sparse {
+		allocated, _ := allocatedBytes(f.info)
+		anomalies = append(anomalies, Warning{
+			Code:    "sparse_file",
+			Subject: f.path,
+			Message: fmt.Sprintf("%.2f GB but only %.2f GB on disk, may be a disk image or unfinished download",
+				float64(f.info.Size())/float64(OneGb), float64(allocated)/float64(OneGb)),
+		})
+	}
+	return anomalies
+}
+
+// prints the anomalies, if there are any
+func reportAnomalies(w io.Writer, anomalies []Warning) {
+	if len(anomalies) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\n"+tr("Things to look at"))
+	fmt.Fprintln(w, tr("Code  Subject  Message"))
+	for _, a := range anomalies {
+		fmt.Fprintf(w, "%v  %v  %v\n", a.Code, a.Subject, a.Message)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+// The collector's totals API serves a slice of the machine results, so a web
+// page can show large results a page at a time instead of fetching them all
+// from /results.
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// how many items a page holds when no limit is given, and at most
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// a total in a page of the totals API
+type apiItem struct {
+	MachineID string `json:"machine_id"`
+	Name      string `json:"name"`
+	Chunks    int64  `json:"chunks"`
+	Bytes     int64  `json:"bytes,omitempty"`
+}
+
+// a page of the totals API
+type apiPage struct {
+	Total  int       `json:"total"` // items matching the filters, on every page
+	Offset int       `json:"offset"`
+	Items  []apiItem `json:"items"`
+}
+
+// the totals of a result that can be queried, by the name of the view
+var apiViews = map[string]func(r *Result) map[string]DirTotal{
+	"dirs":       func(r *Result) map[string]DirTotal { return r.Dirs },
+	"projects":   func(r *Result) map[string]DirTotal { return r.Projects },
+	"extensions": func(r *Result) map[string]DirTotal { return r.Extensions },
+	"classes":    func(r *Result) map[string]DirTotal { return r.Classes },
+}
+
+// serves a page of the totals of the machine results. The query parameters
+// are view (dirs, projects, extensions, classes or histogram), machine to
+// filter to one machine, prefix to filter to names starting with it, such as
+// a directory and everything below it, min_kb and max_kb to filter histogram
+// buckets, and offset and limit for the page. Items are sorted by chunks,
+// largest first.
+func (c *collector) serveTotals(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	number := func(name string, fallback int) (int, error) {
+		value := q.Get(name)
+		if value == "" {
+			return fallback, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%v must be a number of at least 0", name)
+		}
+		return n, nil
+	}
+	offset, err := number("offset", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := number("limit", defaultPageSize)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	minKb, err := number("min_kb", 0)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// histograms for larger chunks have larger buckets, so by default none
+	// are left out
+	maxKb, err := number("max_kb", math.MaxInt32)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	view := q.Get("view")
+	totals, exists := apiViews[view]
+	if !exists && view != "histogram" {
+		http.Error(w, "view must be histogram, dirs, projects, extensions or classes", http.StatusBadRequest)
+		return
+	}
+	items := []apiItem{}
+	for _, m := range c.results() {
+		if machine := q.Get("machine"); machine != "" && m.MachineID != machine {
+			continue
+		}
+		if view == "histogram" {
+			for key, count := range m.Result.Histogram {
+				if key >= int64(minKb) && key <= int64(maxKb) {
+					items = append(items, apiItem{m.MachineID, strconv.FormatInt(key, 10), count, 0})
+				}
+			}
+			continue
+		}
+		for name, total := range totals(m.Result) {
+			if strings.HasPrefix(name, q.Get("prefix")) {
+				items = append(items, apiItem{m.MachineID, name, total.Chunks, total.Bytes})
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Chunks != items[j].Chunks {
+			return items[i].Chunks > items[j].Chunks
+		}
+		if items[i].MachineID != items[j].MachineID {
+			return items[i].MachineID < items[j].MachineID
+		}
+		return items[i].Name < items[j].Name
+	})
+	page := apiPage{Total: len(items), Offset: offset, Items: []apiItem{}}
+	if offset < len(items) {
+		end := offset + limit
+		if end > len(items) {
+			end = len(items)
+		}
+		page.Items = items[offset:end]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
@@ -0,0 +1,185 @@
+package main
+
+// Archives can be counted as if they were extracted, since uploading the
+// files inside an archive gives a different distribution to uploading the
+// archive. Nested archives are looked inside up to a depth. Archive headers
+// can claim any sizes, so the number of entries and bytes counted for each
+// archive are limited, and nested archives are only read up to a size.
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+)
+
+const (
+	// the most files counted inside one archive, including nested archives
+	maxArchiveEntries = 1000000
+	// the most bytes counted inside one archive, including nested archives
+	maxArchiveBytes = 1024 * OneGb
+	// nested zip archives are read into memory, so only smaller ones are
+	// looked inside
+	maxNestedZipSize = 256 * OneMb
+)
+
+var errArchiveLimit = errors.New("archive exceeds the limits for looking inside it")
+
+// returns true if the file is an archive that can be looked inside
+func isArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// what remains of the limits while looking inside an archive
+type archiveBudget struct {
+	entries int64
+	bytes   int64
+}
+
+// uses up the budget for one file, returning errArchiveLimit if there is none
+// left
+func (b *archiveBudget) spend(size int64) error {
+	if size < 0 {
+		return errArchiveLimit
+	}
+	b.entries = b.entries - 1
+	b.bytes = b.bytes - size
+	if b.entries < 0 || b.bytes < 0 {
+		return errArchiveLimit
+	}
+	return nil
+}
+
+// returns the sizes of the files in an archive, looking inside nested
+// archives up to depth
+func archiveSizes(filename string, depth int) ([]int64, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	b := &archiveBudget{maxArchiveEntries, maxArchiveBytes}
+	return readArchive(filename, f, info.Size(), depth, b)
+}
+
+// returns the sizes of the files in an archive read from r
+func readArchive(name string, r io.Reader, size int64, depth int, b *archiveBudget) ([]int64, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		ra, ok := r.(io.ReaderAt)
+		if !ok {
+			if size > maxNestedZipSize {
+				return nil, fmt.Errorf("nested zip %v is too large to look inside", name)
+			}
+			content, err := ioutil.ReadAll(io.LimitReader(r, maxNestedZipSize))
+			if err != nil {
+				return nil, err
+			}
+			ra = bytes.NewReader(content)
+			size = int64(len(content))
+		}
+		return readZip(ra, size, depth, b)
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return readTar(zr, depth, b)
+	case strings.HasSuffix(lower, ".tar"):
+		return readTar(r, depth, b)
+	}
+	return nil, fmt.Errorf("%v is not an archive", name)
+}
+
+// returns the sizes for one file inside an archive, looking inside it if it
+// is a nested archive and the depth allows
+func archiveEntry(name string, open func() (io.Reader, error), size int64, depth int, b *archiveBudget) ([]int64, error) {
+	if depth > 1 && isArchive(name) {
+		r, err := open()
+		if c, ok := r.(io.Closer); ok {
+			defer c.Close()
+		}
+		if err == nil {
+			sizes, err := readArchive(name, r, size, depth-1, b)
+			if err == nil || err == errArchiveLimit {
+				return sizes, err
+			}
+		}
+		// nested archives that can't be read are counted as files
+	}
+	return []int64{size}, b.spend(size)
+}
+
+// returns the sizes of the files in a zip archive
+func readZip(r io.ReaderAt, size int64, depth int, b *archiveBudget) ([]int64, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	sizes := []int64{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		// a size that doesn't fit in an int64 would go negative and add to
+		// the budget instead of spending it, but is over the limit anyway
+		if f.UncompressedSize64 > math.MaxInt64 {
+			return nil, errArchiveLimit
+		}
+		f := f
+		open := func() (io.Reader, error) {
+			return f.Open()
+		}
+		entrySizes, err := archiveEntry(f.Name, open, int64(f.UncompressedSize64), depth, b)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, entrySizes...)
+	}
+	return sizes, nil
+}
+
+// returns the sizes of the files in a tar archive
+func readTar(r io.Reader, depth int, b *archiveBudget) ([]int64, error) {
+	tr := tar.NewReader(r)
+	sizes := []int64{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return sizes, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		open := func() (io.Reader, error) {
+			return tr, nil
+		}
+		entrySizes, err := archiveEntry(h.Name, open, h.Size, depth, b)
+		if err != nil {
+			return nil, err
+		}
+		sizes = append(sizes, entrySizes...)
+	}
+}
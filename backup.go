@@ -0,0 +1,177 @@
+package main
+
+// Analyses backup sets that keep many versions of the same files, so the
+// versions aren't counted as separate files. Time Machine snapshots (on HFS+
+// backup disks) are trees of hard links, where unchanged files and folders
+// link to the same inode as the previous snapshot. Windows File History
+// copies a file each time it changes, adding the time to the name, like
+// "report (2024_01_31 10_15_00 UTC).docx".
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// the time File History adds to each version's name
+var fileHistoryVersion = regexp.MustCompile(` \((\d{4}_\d{2}_\d{2} \d{2}_\d{2}_\d{2}) UTC\)`)
+
+// a version of a file in a backup set
+type backupVersion struct {
+	file    string // identifies the file the version is of
+	version string // identifies the version, the same for identical versions
+	when    string // sorts versions of a file oldest first
+	size    int64
+}
+
+// totals for one way of counting the backup set
+type backupTotals struct {
+	name   string
+	result *Result
+}
+
+// compares counting each version once with counting every path in a backup
+func defineBackup(flags *flag.FlagSet) func() error {
+	rulesName := flags.String("rules", defaultRules, "chunking rules of a network era: "+strings.Join(ruleSetNames(), ", "))
+	kind := flags.String("type", "", "backup type, timemachine or filehistory, detected if not set")
+	return func() error {
+		rules, exists := ruleSets[*rulesName]
+		if !exists {
+			return fmt.Errorf("unknown rules %v, use one of %v", *rulesName, strings.Join(ruleSetNames(), ", "))
+		}
+		if flags.NArg() != 1 {
+			return errors.New("usage: chunk_distribution backup [-type timemachine|filehistory] backup_dir")
+		}
+		root := flags.Arg(0)
+		if _, err := os.Stat(root); err != nil {
+			return err
+		}
+		w := &walker{ctx: context.Background()}
+		rootFiles, dirs := w.walkRoot(root)
+		files := rootFiles
+		for _, dirFiles := range dirs {
+			files = append(files, dirFiles...)
+		}
+		if *kind == "" {
+			*kind = detectBackup(files)
+		}
+		var versions []backupVersion
+		switch *kind {
+		case "timemachine":
+			versions = timeMachineVersions(root, files)
+		case "filehistory":
+			versions = fileHistoryVersions(files)
+		default:
+			return fmt.Errorf("unknown backup type %v, use timemachine or filehistory", *kind)
+		}
+		fmt.Println("Backup type:", *kind)
+		reportBackup(os.Stdout, rules, versions)
+		reportWarnings(os.Stdout, w.warnings)
+		return nil
+	}
+}
+
+// returns filehistory if any file is named like a File History version,
+// otherwise timemachine
+func detectBackup(files []file) string {
+	for _, f := range files {
+		if fileHistoryVersion.MatchString(path.Base(f.path)) {
+			return "filehistory"
+		}
+	}
+	return "timemachine"
+}
+
+// returns the versions in Time Machine snapshots, where each top level
+// directory of root is a snapshot named by its date. Hard links to the same
+// inode are the same version.
+func timeMachineVersions(root string, files []file) []backupVersion {
+	versions := []backupVersion{}
+	for _, f := range files {
+		if !f.info.Mode().IsRegular() {
+			continue
+		}
+		rel := strings.TrimPrefix(strings.TrimPrefix(f.path, root), "/")
+		snapshot, name := rel, rel
+		if i := strings.Index(rel, "/"); i >= 0 {
+			snapshot, name = rel[:i], rel[i+1:]
+		}
+		id, ok := fileID(f.info)
+		if !ok {
+			// without inodes, assume unchanged files keep their size and time
+			id = fmt.Sprintf("%v:%v:%v", name, f.info.Size(), f.info.ModTime().UnixNano())
+		}
+		versions = append(versions, backupVersion{file: name, version: id, when: snapshot, size: f.info.Size()})
+	}
+	return versions
+}
+
+// returns the versions in a File History backup, where every copy is a
+// different version
+func fileHistoryVersions(files []file) []backupVersion {
+	versions := []backupVersion{}
+	for _, f := range files {
+		if !f.info.Mode().IsRegular() {
+			continue
+		}
+		v := backupVersion{file: f.path, version: f.path, size: f.info.Size()}
+		if m := fileHistoryVersion.FindStringSubmatchIndex(f.path); m != nil {
+			v.file = f.path[:m[0]] + f.path[m[1]:]
+			v.when = f.path[m[2]:m[3]]
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// prints the chunks counting every path, each distinct version, and only
+// the latest version of each file
+func reportBackup(w io.Writer, rules Rules, versions []backupVersion) {
+	every := NewResult()
+	distinct := NewResult()
+	latest := NewResult()
+	for _, r := range []*Result{every, distinct, latest} {
+		r.Rules = rules
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].when < versions[j].when
+	})
+	seen := map[string]bool{}
+	newest := map[string]backupVersion{}
+	snapshots := map[string]bool{}
+	for _, v := range versions {
+		every.AddFile(v.size)
+		if !seen[v.version] {
+			seen[v.version] = true
+			distinct.AddFile(v.size)
+		}
+		newest[v.file] = v
+		snapshots[v.when] = true
+	}
+	for _, v := range newest {
+		latest.AddFile(v.size)
+	}
+	fmt.Fprintln(w, "Snapshots or version times:", len(snapshots))
+	fmt.Fprintln(w, "Files:", len(newest))
+	fmt.Fprintln(w, "\nCounting  Files  Chunks  GB")
+	for _, t := range []backupTotals{
+		{"Every path", every},
+		{"Each version once", distinct},
+		{"Latest versions only", latest},
+	} {
+		fmt.Fprintf(w, "%v  %v  %v  %f\n", t.name, t.result.Files, t.result.TotalChunks,
+			float64(t.result.LargeBytes+t.result.SmallBytes)/float64(OneGb))
+	}
+	fmt.Fprintln(w, "\nEach version once")
+	fmt.Fprintln(w, "Chunk Size  Count")
+	chunkdist.WriteHistogram(w, distinct.Histogram)
+}
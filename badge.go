@@ -0,0 +1,89 @@
+package main
+
+// Writes a small SVG badge with the chunks and network size of a result, in
+// the style of shields.io, for a wiki page or forum signature. A scheduled
+// scan with -format badge -o keeps it up to date.
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+const (
+	badgeLabel = "SAFE upload"
+	// approximate width of a character of 11px Verdana, which the badge text
+	// is drawn in, since the text can't be measured here
+	badgeCharWidth = 7
+	badgePadding   = 10
+	badgeHeight    = 20
+)
+
+func init() {
+	chunkdist.RegisterRenderer("badge", resultRenderer(writeBadge))
+}
+
+// returns a count with a K, M or B suffix, eg 2.1M
+func shortCount(n int64) string {
+	suffixes := []string{"", "K", "M", "B"}
+	value := float64(n)
+	i := 0
+	for value >= 1000 && i < len(suffixes)-1 {
+		value = value / 1000
+		i = i + 1
+	}
+	if i == 0 {
+		return strconv.FormatInt(n, 10)
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + suffixes[i]
+}
+
+// returns a size in bytes as MB, GB or TB, eg 840 GB
+func shortBytes(n int64) string {
+	units := []struct {
+		name string
+		size int64
+	}{
+		{"TB", 1024 * OneGb},
+		{"GB", OneGb},
+		{"MB", OneMb},
+	}
+	for _, unit := range units {
+		if n >= unit.size {
+			value := float64(n) / float64(unit.size)
+			if value >= 100 {
+				return fmt.Sprintf("%.0f %v", value, unit.name)
+			}
+			return fmt.Sprintf("%.1f %v", value, unit.name)
+		}
+	}
+	return fmt.Sprintf("%.1f MB", float64(n)/float64(OneMb))
+}
+
+// writes the badge, a grey label on the left and the totals on the right
+func writeBadge(w io.Writer, r *Result) error {
+	message := fmt.Sprintf("%v chunks / %v network", shortCount(r.TotalChunks), shortBytes(r.NetworkBytes))
+	labelWidth := len(badgeLabel)*badgeCharWidth + badgePadding
+	messageWidth := len(message)*badgeCharWidth + badgePadding
+	width := labelWidth + messageWidth
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]v" height="%[2]v" role="img" aria-label="%[3]v: %[4]v">
+<title>%[3]v: %[4]v</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]v" height="%[2]v" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)">
+<rect width="%[5]v" height="%[2]v" fill="#555"/>
+<rect x="%[5]v" width="%[6]v" height="%[2]v" fill="#007ec6"/>
+<rect width="%[1]v" height="%[2]v" fill="url(#s)"/>
+</g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]v" y="14">%[3]v</text>
+<text x="%[8]v" y="14">%[4]v</text>
+</g>
+</svg>
+`, width, badgeHeight, html.EscapeString(badgeLabel), html.EscapeString(message),
+		labelWidth, messageWidth, labelWidth/2, labelWidth+messageWidth/2)
+	return err
+}
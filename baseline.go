@@ -0,0 +1,160 @@
+package main
+
+// Baselines are modeled workloads to compare a scan against, so users can see
+// how their data differs from a typical developer, photographer or media
+// collection. They are built from a described mix of file sizes rather than
+// from anyone's real files, so no personal data is bundled.
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// a number of files of the same size in a baseline
+type baselineFiles struct {
+	count int64
+	size  int64
+}
+
+// a modeled workload
+type baseline struct {
+	description string
+	files       []baselineFiles
+}
+
+var baselines = map[string]baseline{
+	"developer": {
+		"source trees, dependencies and build output",
+		[]baselineFiles{
+			{100000, 500},
+			{200000, 4 * OneKb},
+			{50000, 30 * OneKb},
+			{5000, 2 * OneMb},
+			{500, 50 * OneMb},
+			{20, OneGb},
+		},
+	},
+	"photographer": {
+		"jpeg and raw photos with sidecar files and a few videos",
+		[]baselineFiles{
+			{30000, 8 * OneKb},
+			{10000, 40 * OneKb},
+			{40000, 8 * OneMb},
+			{30000, 25 * OneMb},
+			{200, 500 * OneMb},
+		},
+	},
+	"media-hoarder": {
+		"movies, tv episodes and music with artwork and subtitles",
+		[]baselineFiles{
+			{5000, 2 * OneKb},
+			{20000, 50 * OneKb},
+			{60000, 300 * OneKb},
+			{60000, 8 * OneMb},
+			{20000, 700 * OneMb},
+			{3000, 4 * OneGb},
+		},
+	},
+}
+
+// returns the names of the bundled baselines
+func baselineNames() []string {
+	names := []string{}
+	for name := range baselines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns the result for a bundled baseline using the rules, or for a saved
+// result if name is not a bundled baseline
+func loadBaseline(name string, rules Rules) (*Result, error) {
+	b, exists := baselines[name]
+	if !exists {
+		m, err := loadResult(name)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a saved result or one of %v: %v", name, strings.Join(baselineNames(), ", "), err)
+		}
+		return m.Result, nil
+	}
+	r := NewResult()
+	r.Rules = rules
+	for _, files := range b.files {
+		for i := int64(0); i < files.count; i++ {
+			r.AddFile(files.size)
+		}
+	}
+	return r, nil
+}
+
+// reads a result saved with -save
+func loadResult(filename string) (MachineResult, error) {
+	var m MachineResult
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return m, err
+	}
+	if isSealed(data) {
+		return m, errors.New("saved result is encrypted, use decrypt first")
+	}
+	err = json.Unmarshal(data, &m)
+	if err == nil && m.Result == nil {
+		err = errors.New("no result found")
+	}
+	return m, err
+}
+
+// returns part as a percentage of total
+func percent(part, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(total)
+}
+
+// returns the average of total over count
+func average(total, count int64) float64 {
+	if count == 0 {
+		return 0
+	}
+	return float64(total) / float64(count)
+}
+
+// prints how a result differs from a baseline, using proportions so
+// datasets of different sizes can be compared
+func reportBaseline(w io.Writer, name string, r, b *Result) {
+	fmt.Fprintf(w, "\nCompared to %v\n", name)
+	if desc, exists := baselines[name]; exists {
+		fmt.Fprintf(w, "(modeled on %v)\n", desc.description)
+	}
+	fmt.Fprintf(w, "%-22s %8s %9s\n", "", "You", "Baseline")
+	fmt.Fprintf(w, "%-22s %7.1f%% %8.1f%%\n", "Files larger than "+r.Rules.ChunkSizeLabel(),
+		percent(r.LargeFiles, r.Files), percent(b.LargeFiles, b.Files))
+	fmt.Fprintf(w, "%-22s %8.1f %9.1f\n", "Average file size KB",
+		average(r.LargeBytes+r.SmallBytes, r.Files*OneKb), average(b.LargeBytes+b.SmallBytes, b.Files*OneKb))
+	fmt.Fprintf(w, "%-22s %8.2f %9.2f\n", "Chunks per file",
+		average(r.TotalChunks, r.Files), average(b.TotalChunks, b.Files))
+	fmt.Fprintf(w, "%-22s %7.1f%% %8.1f%%\n", "Large chunks",
+		percent(r.LargeChunks, r.TotalChunks), percent(b.LargeChunks, b.TotalChunks))
+	fmt.Fprintf(w, "%-22s %7.1f%% %8.1f%%\n", "Small chunks",
+		percent(r.SmallChunks, r.TotalChunks), percent(b.SmallChunks, b.TotalChunks))
+	fmt.Fprintf(w, "\n%-22s %8s %9s\n", "Chunk Size", "You", "Baseline")
+	keys := []int{}
+	for key := range r.Histogram {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		k := int64(key)
+		fmt.Fprintf(w, "%-22s %7.1f%% %8.1f%%\n",
+			chunkdist.BucketStart(k), percent(r.Histogram[k], r.TotalChunks), percent(b.Histogram[k], b.TotalChunks))
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+// Benchmarks the directory walkers on a synthetic tree, with storage
+// profiles that add a delay to each directory read to simulate disks that
+// are slower to seek. The benchmark command runs them on this machine, and
+// the same harness backs go test -bench.
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// a storage profile for benchmarks
+type storageProfile struct {
+	name      string
+	readDelay time.Duration // added to each directory read
+}
+
+var storageProfiles = []storageProfile{
+	{"ssd", 0},
+	{"hdd", 2 * time.Millisecond},
+}
+
+// a walker implementation to benchmark, returning the number of files found
+type benchWalker struct {
+	name string
+	walk func(root string, profile storageProfile) int
+}
+
+var benchWalkers = []benchWalker{
+	{"serial", func(root string, profile storageProfile) int {
+		w := &walker{ctx: context.Background(), readDelay: profile.readDelay}
+		files, dirs := w.walkRoot(root)
+		n := len(files)
+		for _, dirFiles := range dirs {
+			n = n + len(dirFiles)
+		}
+		return n
+	}},
+	{"workers-8", func(root string, profile storageProfile) int {
+		w := &walker{ctx: context.Background(), readDelay: profile.readDelay, workers: 8}
+		files, dirs := w.walkRoot(root)
+		n := len(files)
+		for _, dirFiles := range dirs {
+			n = n + len(dirFiles)
+		}
+		return n
+	}},
+}
+
+// the shape of a synthetic tree
+type treeShape struct {
+	depth       int // levels of directories below the root
+	dirsPerDir  int
+	filesPerDir int
+}
+
+// creates a synthetic tree of sparse files under root, returning the number
+// of files created. File sizes cycle through a range of sizes so every kind
+// of chunking is exercised.
+func makeSyntheticTree(root string, shape treeShape) (int, error) {
+	sizes := []int64{100, 2 * OneKb, 50 * OneKb, 700 * OneKb, 3 * OneMb, 40 * OneMb}
+	created := 0
+	var makeDir func(dirname string, depth int) error
+	makeDir = func(dirname string, depth int) error {
+		if err := os.MkdirAll(dirname, 0755); err != nil {
+			return err
+		}
+		for i := 0; i < shape.filesPerDir; i++ {
+			f, err := os.Create(filepath.Join(dirname, "file"+strconv.Itoa(i)))
+			if err != nil {
+				return err
+			}
+			err = f.Truncate(sizes[created%len(sizes)])
+			f.Close()
+			if err != nil {
+				return err
+			}
+			created = created + 1
+		}
+		if depth == 0 {
+			return nil
+		}
+		for i := 0; i < shape.dirsPerDir; i++ {
+			if err := makeDir(filepath.Join(dirname, "dir"+strconv.Itoa(i)), depth-1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return created, makeDir(root, shape.depth)
+}
+
+// benchmarks each walker on each storage profile on this machine
+func defineBenchmark(flags *flag.FlagSet) func() error {
+	depth := flags.Int("depth", 3, "levels of directories in the synthetic tree")
+	dirs := flags.Int("dirs", 6, "subdirectories in each directory of the synthetic tree")
+	files := flags.Int("files", 20, "files in each directory of the synthetic tree")
+	return func() error {
+		root, err := ioutil.TempDir("", "chunk_distribution-benchmark-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(root)
+		created, err := makeSyntheticTree(root, treeShape{*depth, *dirs, *files})
+		if err != nil {
+			return err
+		}
+		fmt.Println("Synthetic tree of", created, "files in", root)
+		fmt.Println("\nWalker  Storage  Time  Files/s")
+		for _, bw := range benchWalkers {
+			for _, profile := range storageProfiles {
+				start := time.Now()
+				found := bw.walk(root, profile)
+				elapsed := time.Since(start)
+				if found != created {
+					return errors.New(bw.name + " walker found " + strconv.Itoa(found) + " files")
+				}
+				fmt.Printf("%v  %v  %v  %.0f\n", bw.name, profile.name,
+					elapsed.Round(time.Microsecond), float64(found)/elapsed.Seconds())
+			}
+		}
+		return nil
+	}
+}
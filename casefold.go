@@ -0,0 +1,80 @@
+package main
+
+// Folds the case of imported paths, so a listing from a case-sensitive
+// filesystem is counted as it would be stored on a case-insensitive one,
+// where names differing only by case are the same file.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// two paths in a listing that are the same when case is ignored
+type caseCollision struct {
+	kept    string
+	dropped string
+	dir     bool // both are directories, whose files were merged
+}
+
+// returns the entries with paths that differ only by case counted once.
+// Paths are sorted first so the same spelling is kept whatever order the
+// listing is in: the first in byte order, which puts upper case first. Each
+// directory takes the spelling of its first path, so files in Docs/ and
+// docs/ end up in the same directory, and a file with the same folded path
+// as an earlier one is dropped.
+func foldCase(entries []importEntry) ([]importEntry, []caseCollision) {
+	sorted := make([]importEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].path < sorted[j].path
+	})
+	dirs := map[string]string{}  // folded directory to its kept spelling
+	files := map[string]string{} // folded file path to its kept spelling
+	merged := map[string]bool{}  // directory spellings already reported
+	collisions := []caseCollision{}
+	folded := []importEntry{}
+	for _, e := range sorted {
+		parts := strings.Split(e.path, "/")
+		for i := 1; i < len(parts); i++ {
+			dir := strings.Join(parts[:i], "/")
+			key := strings.ToLower(dir)
+			kept, seen := dirs[key]
+			if !seen {
+				dirs[key] = dir
+				continue
+			}
+			if kept != dir && !merged[dir] {
+				collisions = append(collisions, caseCollision{kept, dir, true})
+				merged[dir] = true
+			}
+			parts[i-1] = kept[strings.LastIndex(kept, "/")+1:]
+		}
+		filename := strings.Join(parts, "/")
+		key := strings.ToLower(filename)
+		if kept, seen := files[key]; seen {
+			collisions = append(collisions, caseCollision{kept, e.path, false})
+			continue
+		}
+		files[key] = filename
+		folded = append(folded, importEntry{filename, e.size})
+	}
+	return folded, collisions
+}
+
+// prints the paths that were merged or dropped by foldCase
+func reportCaseCollisions(w io.Writer, collisions []caseCollision) {
+	fmt.Fprintln(w, "\nCase collisions:", len(collisions))
+	if len(collisions) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Kept  Merged or dropped")
+	for _, c := range collisions {
+		if c.dir {
+			fmt.Fprintf(w, "%v/  %v/\n", c.kept, c.dropped)
+		} else {
+			fmt.Fprintf(w, "%v  %v\n", c.kept, c.dropped)
+		}
+	}
+}
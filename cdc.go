@@ -0,0 +1,229 @@
+package main
+
+// Compares the network's fixed size chunks with content-defined chunking, as
+// used by deduplicating backup tools such as restic and borg. Content-defined
+// chunking cuts where a rolling hash of the content matches a pattern, so an
+// insert only changes the chunks around it and identical runs of bytes in
+// different files become identical chunks. This reads every file, using
+// FastCDC's gear hash with normalized chunking.
+
+import (
+	"context"
+	"crypto/sha3"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// a content-defined chunker, cutting chunks between min and max bytes long
+// that average about avg bytes
+type cdcChunker struct {
+	min, avg, max int64
+	// below the average a chunk is cut on a harder pattern, and above it on
+	// an easier one, which keeps sizes close to the average
+	maskHard, maskEasy uint64
+}
+
+// a random value for each byte, from splitmix64 with a fixed seed so chunks
+// are cut in the same places on every run
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	x := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		x = x + 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+func newCDCChunker(min, avg, max int64) (*cdcChunker, error) {
+	if min <= 0 || min >= avg || avg >= max {
+		return nil, errors.New("chunk sizes must be 0 < min < avg < max")
+	}
+	// the number of hash bits that must be zero for a chunk of avg bytes
+	avgBits := int(math.Round(math.Log2(float64(avg))))
+	if avgBits < 3 || avgBits > 60 {
+		return nil, fmt.Errorf("average chunk size %v is out of range", avg)
+	}
+	// the gear hash shifts older bytes towards the top, so the top bits
+	// depend on the most bytes
+	topBits := func(n int) uint64 {
+		return ^uint64(0) << (64 - n)
+	}
+	return &cdcChunker{
+		min:      min,
+		avg:      avg,
+		max:      max,
+		maskHard: topBits(avgBits + 2),
+		maskEasy: topBits(avgBits - 2),
+	}, nil
+}
+
+// splits content into chunks, calling fn with the hash and size of each
+func (c *cdcChunker) split(r io.Reader, fn func(sum [32]byte, size int64)) error {
+	buf := make([]byte, 256*OneKb)
+	h := sha3.New256()
+	var hash uint64
+	var n int64
+	cut := func() {
+		var sum [32]byte
+		copy(sum[:], h.Sum(nil))
+		fn(sum, n)
+		h.Reset()
+		hash = 0
+		n = 0
+	}
+	for {
+		read, err := r.Read(buf)
+		data := buf[:read]
+		start := 0
+		for i, b := range data {
+			hash = (hash << 1) + gearTable[b]
+			n = n + 1
+			if n < c.min {
+				continue
+			}
+			mask := c.maskEasy
+			if n < c.avg {
+				mask = c.maskHard
+			}
+			if hash&mask == 0 || n >= c.max {
+				h.Write(data[start : i+1])
+				start = i + 1
+				cut()
+			}
+		}
+		h.Write(data[start:])
+		if err == io.EOF {
+			if n > 0 {
+				cut()
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// the chunks of every file and of the distinct content among them
+type cdcTotals struct {
+	chunks       int64
+	bytes        int64
+	uniqueChunks int64
+	uniqueBytes  int64
+}
+
+// compares fixed and content-defined chunking of the files in directories
+func defineCompareCDC(flags *flag.FlagSet) func() error {
+	minSize := flags.String("min", "256K", "smallest content-defined chunk")
+	avgSize := flags.String("avg", "1M", "average content-defined chunk, rounded to a power of two")
+	maxSize := flags.String("max", "4M", "largest content-defined chunk")
+	return func() error {
+		if flags.NArg() == 0 {
+			return errors.New("usage: compare-cdc [-min 256K] [-avg 1M] [-max 4M] dir...")
+		}
+		sizes := []int64{}
+		for _, s := range []string{*minSize, *avgSize, *maxSize} {
+			size, err := parseSize(s)
+			if err != nil {
+				return err
+			}
+			sizes = append(sizes, size)
+		}
+		chunker, err := newCDCChunker(sizes[0], sizes[1], sizes[2])
+		if err != nil {
+			return err
+		}
+		rules := ruleSets[defaultRules]
+		var fixed, cdc cdcTotals
+		files := map[[32]byte]bool{}  // content hashes of the files seen
+		chunks := map[[32]byte]bool{} // hashes of the content-defined chunks seen
+		visit := func(f file) {
+			if !f.info.Mode().IsRegular() {
+				return
+			}
+			fh, err := os.Open(f.path)
+			if err != nil {
+				fmt.Println("Skipping", f.path, err)
+				return
+			}
+			defer fh.Close()
+			whole := sha3.New256()
+			err = chunker.split(io.TeeReader(fh, whole), func(sum [32]byte, size int64) {
+				cdc.chunks = cdc.chunks + 1
+				cdc.bytes = cdc.bytes + size
+				if !chunks[sum] {
+					chunks[sum] = true
+					cdc.uniqueChunks = cdc.uniqueChunks + 1
+					cdc.uniqueBytes = cdc.uniqueBytes + size
+				}
+			})
+			if err != nil {
+				fmt.Println("Skipping", f.path, err)
+				return
+			}
+			// self encryption only shares chunks between identical files
+			var sum [32]byte
+			copy(sum[:], whole.Sum(nil))
+			size := f.info.Size()
+			count := rules.ChunksForSize(size).Count + 1 // + 1 for datamap
+			fixed.chunks = fixed.chunks + count
+			fixed.bytes = fixed.bytes + size
+			if !files[sum] {
+				files[sum] = true
+				fixed.uniqueChunks = fixed.uniqueChunks + count
+				fixed.uniqueBytes = fixed.uniqueBytes + size
+			}
+		}
+		for _, root := range flags.Args() {
+			if _, err := os.Stat(root); err != nil {
+				return err
+			}
+			fmt.Println("Reading", root)
+			w := &walker{ctx: context.Background(), root: root}
+			rootFiles, names, project, ignore := w.readRoot(root)
+			for _, f := range rootFiles {
+				visit(f)
+			}
+			for _, name := range names {
+				w.walkTree(path.Join(root, name), project, ignore, visit)
+			}
+		}
+		reportCDC(os.Stdout, rules, chunker, fixed, cdc)
+		return nil
+	}
+}
+
+// prints the chunks for each way of chunking, and how many are left once
+// duplicates are removed
+func reportCDC(w io.Writer, rules Rules, c *cdcChunker, fixed, cdc cdcTotals) {
+	fmt.Fprintln(w, "\nChunker  Chunks  Unique chunks  Unique GB  Saved  Average KB")
+	rows := []struct {
+		name   string
+		totals cdcTotals
+	}{
+		{"Fixed (" + rules.Name + ")", fixed},
+		{fmt.Sprintf("Content-defined (%v/%v/%v)", chunkdist.SizeLabel(c.min/OneKb), chunkdist.SizeLabel(c.avg/OneKb), chunkdist.SizeLabel(c.max/OneKb)), cdc},
+	}
+	for _, row := range rows {
+		t := row.totals
+		average := 0.0
+		if t.uniqueChunks > 0 {
+			average = float64(t.uniqueBytes) / float64(t.uniqueChunks) / OneKb
+		}
+		fmt.Fprintf(w, "%v  %v  %v  %f  %.1f%%  %.1f\n",
+			row.name, t.chunks, t.uniqueChunks, float64(t.uniqueBytes)/float64(OneGb),
+			percent(t.bytes-t.uniqueBytes, t.bytes), average)
+	}
+	fmt.Fprintln(w, "Fixed chunks include a datamap per file. Content-defined chunks leave out the index a backup tool keeps.")
+}
@@ -0,0 +1,189 @@
+package main
+
+// Draws the histogram of a result as a bar chart, as SVG or PNG, so the
+// collector can serve charts to hot-link into forum posts and wikis. PNG
+// text is drawn with a small built in pixel font, since there is no font
+// renderer in the standard library.
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+const (
+	chartWidth      = 600
+	chartRowHeight  = 20
+	chartLabelWidth = 110 // room for the bucket labels left of the bars
+	chartCountWidth = 60  // room for the counts right of the bars
+	chartTop        = 36  // room for the title
+	chartMargin     = 10
+)
+
+// the colour of the bars, as in the pdf
+var chartBarColor = color.RGBA{0x33, 0x66, 0xcc, 0xff}
+
+// a bar of the chart
+type chartBar struct {
+	label string
+	count int64
+	width int // in pixels, scaled to the largest count
+}
+
+// returns the bars for the histogram buckets, smallest chunks first
+func chartBars(r *Result) []chartBar {
+	keys := []int{}
+	var most int64
+	for key, count := range r.Histogram {
+		keys = append(keys, int(key))
+		if count > most {
+			most = count
+		}
+	}
+	sort.Ints(keys)
+	barSpace := chartWidth - 2*chartMargin - chartLabelWidth - chartCountWidth
+	bars := []chartBar{}
+	bucketWidth := chunkdist.HistogramWidth(r.Histogram)
+	for _, key := range keys {
+		count := r.Histogram[int64(key)]
+		width := 0
+		if most > 0 {
+			width = int(float64(barSpace) * float64(count) / float64(most))
+		}
+		bars = append(bars, chartBar{chunkdist.BucketLabelFor(int64(key), bucketWidth), count, width})
+	}
+	return bars
+}
+
+// returns the height of a chart with the given number of bars
+func chartHeight(bars int) int {
+	return chartTop + bars*chartRowHeight + chartMargin
+}
+
+// writes the histogram as an SVG bar chart
+func writeHistogramSVG(w io.Writer, r *Result) error {
+	bars := chartBars(r)
+	height := chartHeight(len(bars))
+	title := fmt.Sprintf("Chunks by size: %v chunks, %v files (%v)", shortCount(r.TotalChunks), shortCount(r.Files), r.Rules.Name)
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%v" height="%v" role="img" aria-label="%v">
+<rect width="100%%" height="100%%" fill="#fff"/>
+<g font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11" fill="#333">
+<text x="%v" y="22" font-size="13">%v</text>
+`, chartWidth, height, html.EscapeString(title), chartMargin, html.EscapeString(title))
+	barX := chartMargin + chartLabelWidth
+	for i, bar := range bars {
+		y := chartTop + i*chartRowHeight
+		fmt.Fprintf(w, `<text x="%v" y="%v" text-anchor="end">%v</text>
+<rect x="%v" y="%v" width="%v" height="%v" fill="#3366cc"/>
+<text x="%v" y="%v">%v</text>
+`, barX-6, y+13, html.EscapeString(bar.label),
+			barX, y+3, bar.width, chartRowHeight-6,
+			barX+bar.width+6, y+13, bar.count)
+	}
+	_, err := fmt.Fprintln(w, "</g>\n</svg>")
+	return err
+}
+
+// 5x7 pixel glyphs for the characters in bucket labels and short counts
+var chartGlyphs = map[rune][7]string{
+	'0': {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1': {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2': {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3': {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4': {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5': {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6': {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7': {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8': {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9': {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+	'K': {"#   #", "#  # ", "# #  ", "##   ", "# #  ", "#  # ", "#   #"},
+	'M': {"#   #", "## ##", "# # #", "# # #", "#   #", "#   #", "#   #"},
+	'B': {"#### ", "#   #", "#   #", "#### ", "#   #", "#   #", "#### "},
+	'G': {" ### ", "#   #", "#    ", "# ###", "#   #", "#   #", " ####"},
+	'T': {"#####", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  "},
+	'+': {"     ", "  #  ", "  #  ", "#####", "  #  ", "  #  ", "     "},
+	'-': {"     ", "     ", "     ", "#####", "     ", "     ", "     "},
+	'.': {"     ", "     ", "     ", "     ", "     ", " ##  ", " ##  "},
+}
+
+// draws text in the pixel font with its baseline at y. Characters without a
+// glyph are left as spaces.
+func drawChartText(img *image.RGBA, x, y int, text string, c color.Color) {
+	for _, ch := range text {
+		if glyph, exists := chartGlyphs[ch]; exists {
+			for row, line := range glyph {
+				for col, pixel := range line {
+					if pixel == '#' {
+						img.Set(x+col, y-7+row, c)
+					}
+				}
+			}
+		}
+		x = x + 6
+	}
+}
+
+// writes the histogram as a PNG bar chart, titled with the totals since the
+// pixel font has only digits and units
+func writeHistogramPNG(w io.Writer, r *Result) error {
+	bars := chartBars(r)
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight(len(bars))))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+	text := color.RGBA{0x33, 0x33, 0x33, 0xff}
+	drawChartText(img, chartMargin, 22, shortCount(r.TotalChunks)+" - "+shortBytes(r.NetworkBytes), text)
+	barX := chartMargin + chartLabelWidth
+	for i, bar := range bars {
+		y := chartTop + i*chartRowHeight
+		labelWidth := len(bar.label) * 6
+		drawChartText(img, barX-6-labelWidth, y+13, bar.label, text)
+		for by := y + 3; by < y+chartRowHeight-3; by++ {
+			for bx := barX; bx < barX+bar.width; bx++ {
+				img.Set(bx, by, chartBarColor)
+			}
+		}
+		drawChartText(img, barX+bar.width+6, y+13, fmt.Sprint(bar.count), text)
+	}
+	return png.Encode(w, img)
+}
+
+// serves the histogram of the combined results at /chart/histogram.svg and
+// /chart/histogram.png, or of one machine's result with ?machine=id
+func (c *collector) serveChart(w http.ResponseWriter, r *http.Request) {
+	var write func(io.Writer, *Result) error
+	var contentType string
+	switch r.URL.Path {
+	case "/chart/histogram.svg":
+		write, contentType = writeHistogramSVG, "image/svg+xml"
+	case "/chart/histogram.png":
+		write, contentType = writeHistogramPNG, "image/png"
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	machine := r.URL.Query().Get("machine")
+	combined := NewResult()
+	found := false
+	for _, m := range c.results() {
+		if machine == "" || m.MachineID == machine {
+			combined.Merge(m.Result)
+			found = true
+		}
+	}
+	if machine != "" && !found {
+		http.Error(w, "unknown machine "+machine, http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	// charts are hot-linked, so let caches keep them only briefly
+	w.Header().Set("Cache-Control", "max-age=60")
+	write(w, combined)
+}
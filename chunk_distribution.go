@@ -10,142 +10,898 @@ package main
 // and what their distribution is.
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"math"
 	"os"
 	"os/user"
 	"path"
-	"sort"
-	"strconv"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
 )
 
-const OneKb = 1024
-const OneMb = 1024 * 1024
-const OneGb = 1024 * 1024 * 1024
+// sizes in bytes, used throughout
+const (
+	OneKb = chunkdist.OneKb
+	OneMb = chunkdist.OneMb
+	OneGb = chunkdist.OneGb
+)
 
 func main() {
-	fmt.Println("chunk_distribution v0.1.0")
-	u, err := user.Current()
+	// on stderr so stdout is only the output, for formats and scripts
+	fmt.Fprintln(os.Stderr, "chunk_distribution", version)
+	// the language is chosen before the flags are parsed, as -h prints a
+	// translated usage line
+	if err := setLanguage(langFromArgs(os.Args[1:])); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	var err error
+	if c, args, found := findCommand(os.Args[1:]); found {
+		err = c.run(args)
+	} else if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		// not a command, so the directories to scan
+		if _, statErr := os.Stat(os.Args[1]); statErr == nil {
+			err = commandNamed("").run(os.Args[1:])
+		} else {
+			err = fmt.Errorf("unknown command or directory %v", os.Args[1])
+		}
+	} else {
+		err = commandNamed("").run(os.Args[1:])
+	}
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
-	fmt.Println("Gathering current user HomeDir stats")
-	files := walkDir(u.HomeDir)
-	reportSizes(files)
 }
 
-// returns all files from a director, including files in subdirectories
-func walkDir(dirname string) []os.FileInfo {
-	allFiles := []os.FileInfo{}
-	files, _ := ioutil.ReadDir(dirname)
-	for _, file := range files {
-		if file.IsDir() {
-			subdirFiles := walkDir(path.Join(dirname, file.Name()))
-			allFiles = append(allFiles, subdirFiles...)
+// reports the distribution for the given directories, or the home directory
+// of the current user
+func defineScan(flags *flag.FlagSet) func() error {
+	helpAll := flags.Bool("help-all", false, "print the help for every command")
+	flags.String("lang", "en", "language of the report: "+strings.Join(languageNames(), ", "))
+	save := flags.String("save", "", "file to save the result to as json")
+	recipient := flags.String("encrypt-output", "", "public key to encrypt the saved result to, see keygen")
+	containers := flags.Bool("containers", false, "estimate the effect of encrypting files before upload")
+	rulesName := flags.String("rules", defaultRules, "chunking rules of a network era: "+strings.Join(ruleSetNames(), ", "))
+	chunkSize := flags.String("chunk-size", "", "override the chunk size of the rules, eg 512K or 4M")
+	minFileSize := flags.String("min-file-size", "", "override the size below which files are stored in the datamap, eg 1K")
+	chunkSizes := flags.String("chunk-sizes", "", "compare several chunk sizes in one scan, eg 256K,1M,4M")
+	stats := flags.Bool("stats", false, "print the min, max, mean, median, percentiles and standard deviation of file and chunk sizes")
+	smallRules := flags.Bool("small-file-rules", false, "compare the chunks if files no larger than a chunk were split into the minimum chunks, stored in the datamap, or stored as one chunk")
+	exact := flags.Bool("exact", false, "split files exactly as self_encryption does, with its equal split below three full chunks and its minimum chunk size")
+	networkVersion := flags.String("network-version", "", "warn if the rules differ from those of a network version: "+strings.Join(networkVersionNames(), ", "))
+	archiveDepth := flags.Int("archive-depth", 0, "count zip and tar archives as if extracted, looking inside nested archives up to this depth")
+	compare := flags.String("compare-baseline", "", "compare to a saved result or a baseline: "+strings.Join(baselineNames(), ", "))
+	opTimeout := flags.Duration("op-timeout", 0, "skip a directory that takes longer than this to read, eg 30s")
+	measureRead := flags.Bool("measure-read", false, "sample reads to measure how fast each directory can be read")
+	uploadSpeed := flags.Float64("upload-speed", 0, "upload speed in Mbit/s, to estimate the upload time")
+	putCost := flags.Float64("put-cost", 0, "price of each PUT, to estimate the cost of uploading, in any currency")
+	gbCost := flags.Float64("gb-cost", 0, "price of each GB stored, to estimate the cost of uploading, in any currency")
+	growth := flags.String("growth", "", "project the data forward at a yearly growth rate, eg 20%/yr, with rates for categories after it, eg 20%/yr,video=50%")
+	years := flags.Int("years", 3, "years to project for -growth")
+	projects := flags.Bool("projects", false, "report the chunks for each project, a directory containing a project marker")
+	markers := flags.String("project-markers", defaultProjectMarkers, "comma separated names of files or directories that mark a project")
+	mutable := flags.Bool("mutable", false, "report the chunks for mutable data, such as databases and logs, separately from static data")
+	mutablePatterns := patternList{}
+	flags.Var(&mutablePatterns, "mutable-patterns", "comma separated patterns of the names or paths of mutable files and directories, see -exclude, default "+defaultMutablePatterns)
+	exclude := patternList{}
+	include := patternList{}
+	flags.Var(&include, "include", "only count files matching a pattern, see -exclude, can be repeated")
+	flags.Var(&exclude, "exclude", "skip files and directories matching a glob, or a regular expression after re:, matched against the name or path, can be repeated")
+	labels := labelList{}
+	flags.Var(&labels, "label", "report the files matching patterns separately under a label, as name=patterns, eg work=Work,re:^Documents/clients/, can be repeated")
+	userDataOnly := flags.Bool("user-data-only", false, "skip the operating system, applications and caches, so only personal data is counted")
+	ignoreFiles := flags.Bool("ignore-files", false, "skip files and directories listed in .gitignore and .chunkdistignore files")
+	followSymlinks := flags.Bool("follow-symlinks", false, "count what symbolic links point to instead of the links, skipping loops")
+	skipSymlinks := flags.Bool("skip-symlinks", false, "leave symbolic links out of the scan")
+	maxDepth := flags.Int("max-depth", -1, "only scan directories up to this many levels below the scanned directory, 0 for only its own files, to sample the top of a huge tree")
+	oneFileSystem := flags.Bool("one-file-system", false, "don't scan directories on other filesystems than the scanned directory, like du -x")
+	showCoverage := flags.Bool("coverage", false, "compare the bytes found with the space in use on each filesystem scanned, warning if a whole filesystem was much less than covered")
+	strict := flags.Bool("strict", false, "exit with an error after the report if any directory could not be read")
+	quiet := flags.Bool("quiet", false, "don't show the progress of the scan")
+	rootTimeout := flags.Duration("root-timeout", 0, "give up on a directory that takes longer than this to scan, eg 10m")
+	modifyRates := flags.String("modify-rates", "", "estimate old versions kept by the network from edits per file per month for each size class, eg small=2,large=0.1")
+	months := flags.Int("months", 12, "months of edits for -modify-rates")
+	examples := flags.Int("examples", 0, "record up to this many example files for each chunk size")
+	redactExamples := flags.Bool("redact-examples", false, "replace the names of example files with a hash, keeping the extension")
+	format := flags.String("format", "text", "output format: "+strings.Join(chunkdist.RendererNames(), ", "))
+	output := flags.String("o", "", "file to write the output to, or stdout if not set")
+	impersonate := flags.String("impersonate", "", "scan the home of this user, counting only the files they own, run with sudo to read it")
+	perRoot := flags.Bool("per-root", false, "with several directories, print the full report for each before the combined report")
+	folderEntries := flags.Int64("folder-entries", 0, "model network folder objects holding up to this many directory entries each")
+	diskUsage := flags.Bool("disk-usage", false, "count the bytes files take on disk instead of their apparent size, so sparse files count only their allocated blocks, and compare the two")
+	lowMemory := flags.Bool("low-memory", false, "use fixed size estimates instead of remembering every directory and hard link, and at most 2 workers, for devices with little memory")
+	workers := flags.Int("workers", 1, "directories to read at the same time, more is faster on ssds and network drives")
+	publicNames := flags.Bool("public-names", false, "count the naming objects to publish each top level directory under a public name")
+	sizeRatios := flags.String("size-ratios", "", "scale the size of files by a ratio for their extension before chunking, eg wav=0.6,bmp=0.1 to model compressing them")
+	formats := flags.Bool("formats", false, "estimate the chunks if videos were split into segments and FLAC albums packed into archives")
+	segmentMinutes := flags.Int64("segment-minutes", 10, "minutes of video in each segment, see -formats")
+	duplicates := flags.Bool("duplicates", false, "hash files of the same size to report identical files and the chunks saved uploading each once")
+	dedupe := flags.Bool("dedupe", false, "read every file and hash its chunks to count the duplicate chunks")
+	dedupeHash := flags.String("dedupe-hash", "sha3", "hash for -dedupe: "+strings.Join(dedupeHashNames(), ", "))
+	largestFirst := flags.Bool("largest-first", false, "scan the largest top level directories first, so a root that times out keeps a partial result")
+	countHardLinks := flags.Bool("count-hard-links", false, "count a file once for each of its hard links, instead of once")
+	since := flags.String("since", "", "only count files modified after a saved result was scanned, to estimate an incremental upload")
+	partial := flags.String("partial-files", partialInclude, "how to count downloads in progress, by extension or sparse files: "+strings.Join(partialModes, ", "))
+	return func() error {
+		if *helpAll {
+			writeHelpAll(os.Stdout)
+			return nil
+		}
+		if *recipient != "" && *save == "" {
+			return errors.New("-encrypt-output requires -save")
+		}
+		rules, exists := ruleSets[*rulesName]
+		if !exists {
+			return fmt.Errorf("unknown rules %v, use one of %v", *rulesName, strings.Join(ruleSetNames(), ", "))
+		}
+		rules, err := customRules(rules, *chunkSize, *minFileSize, *exact)
+		if err != nil {
+			return err
+		}
+		if *diskUsage {
+			// saved results record that sizes are on disk, as for other changes
+			// to the rules
+			rules.Name = rules.Name + "+disk_usage"
+		}
+		renderer, err := chunkdist.LookupRenderer(*format)
+		if err != nil {
+			return err
+		}
+		if *format != "text" {
+			if err := checkTextOnlyFlags(flags, *format); err != nil {
+				return err
+			}
+		}
+		switch *partial {
+		case partialInclude, partialExclude, partialSeparate:
+		default:
+			return fmt.Errorf("unknown -partial-files %v, use one of %v", *partial, strings.Join(partialModes, ", "))
+		}
+		if _, exists := networkVersions[*networkVersion]; *networkVersion != "" && !exists {
+			return fmt.Errorf("unknown network version %v, use one of %v", *networkVersion, strings.Join(networkVersionNames(), ", "))
+		}
+		var b *Result
+		if *compare != "" {
+			var err error
+			b, err = loadBaseline(*compare, rules)
+			if err != nil {
+				return err
+			}
+		}
+		// progress goes to stderr when the output is on stdout, so it can be
+		// piped into another program
+		progress := os.Stdout
+		if *format != "text" && *output == "" {
+			progress = os.Stderr
+		}
+		var owner *user.User
+		if *impersonate != "" {
+			if !canReadOwners {
+				return errors.New("-impersonate isn't supported on this platform")
+			}
+			owner, err = user.Lookup(*impersonate)
+			if err != nil {
+				return err
+			}
+		}
+		roots := flags.Args()
+		if len(roots) == 0 && owner != nil {
+			roots = []string{owner.HomeDir}
+			fmt.Fprintln(progress, tr("Gathering HomeDir stats for"), owner.Username)
+		} else if len(roots) == 0 {
+			home, err := homeDir()
+			if err != nil {
+				return err
+			}
+			roots = []string{home}
+			fmt.Fprintln(progress, tr("Gathering current user HomeDir stats"))
 		} else {
-			allFiles = append(allFiles, file)
+			fmt.Fprintln(progress, tr("Gathering stats for"), strings.Join(roots, ", "))
+		}
+		models := []fileModel{}
+		if *containers {
+			models = append(models, &containerModel{rules: rules})
+		}
+		if *modifyRates != "" {
+			history, err := newHistoryModel(rules, *modifyRates, *months)
+			if err != nil {
+				return err
+			}
+			models = append(models, history)
+		}
+		if *chunkSizes != "" {
+			sizes, err := newChunkSizeModel(rules, *chunkSizes)
+			if err != nil {
+				return err
+			}
+			models = append(models, sizes)
+		}
+		if *smallRules {
+			models = append(models, newSmallRulesModel(rules))
+		}
+		if *stats {
+			models = append(models, newStatsModel(rules))
+		}
+		if *putCost < 0 || *gbCost < 0 {
+			return errors.New("-put-cost and -gb-cost can't be negative")
+		}
+		if *putCost > 0 || *gbCost > 0 {
+			models = append(models, &costModel{rules: rules, putCost: *putCost, gbCost: *gbCost})
+		}
+		opts := scanOptions{
+			rules:        rules,
+			archiveDepth: *archiveDepth,
+			opTimeout:    *opTimeout,
+			measureRead:  *measureRead,
+			partialFiles: *partial,
+			examples:     *examples,
+			redact:       *redactExamples,
+			largestFirst: *largestFirst,
+			workers:      *workers,
+		}
+		if owner != nil {
+			opts.owner = owner.Uid
+		}
+		if *publicNames {
+			opts.naming = newNamingModel(rules)
+		}
+		if *folderEntries > 0 {
+			opts.folders = newFolderModel(*folderEntries)
+		}
+		if *sizeRatios != "" {
+			ratios, err := chunkdist.ParseExtensionRatios(*sizeRatios)
+			if err != nil {
+				return fmt.Errorf("-size-ratios: %v", err)
+			}
+			opts.transform = ratios
+		}
+		if *formats {
+			if *segmentMinutes <= 0 {
+				return errors.New("-segment-minutes must be more than zero")
+			}
+			opts.formats = newFormatModel(rules, *segmentMinutes)
 		}
+		if *duplicates {
+			opts.duplicates = newDupeModel(rules)
+		}
+		if *diskUsage {
+			opts.apparent = newApparentModel(rules)
+		}
+		if len(labels) > 0 {
+			opts.labels = newLabelModel(rules, labels)
+		}
+		if *growth != "" {
+			opts.growth, err = newGrowthModel(rules, *growth, *years)
+			if err != nil {
+				return err
+			}
+			opts.growth.putCost, opts.growth.gbCost = *putCost, *gbCost
+		}
+		if *dedupe {
+			opts.dedupe, err = newDedupeModel(rules, *dedupeHash)
+			if err != nil {
+				return err
+			}
+		}
+		if *mutable {
+			if len(mutablePatterns) == 0 {
+				mutablePatterns.Set(defaultMutablePatterns)
+			}
+			opts.mutable = mutablePatterns
+		}
+		if *userDataOnly {
+			exclude = append(exclude, userDataPatterns(runtime.GOOS)...)
+		}
+		opts.exclude = exclude
+		opts.include = include
+		opts.ignoreFiles = *ignoreFiles
+		opts.xdev = *oneFileSystem
+		opts.maxDepth = *maxDepth + 1
+		if !*countHardLinks {
+			opts.links = newLinkSet()
+		}
+		if *lowMemory {
+			if *dedupe || *duplicates || *examples > 0 || *stats {
+				return errors.New("-dedupe, -duplicates, -examples and -stats remember every file, so can't be used with -low-memory")
+			}
+			if opts.workers > lowMemoryWorkers {
+				opts.workers = lowMemoryWorkers
+			}
+			if opts.links != nil {
+				opts.links = newLinkFilter()
+			}
+			opts.lowMemory = true
+			startLowMemory()
+		}
+		if *since != "" {
+			previous, err := loadResult(*since)
+			if err != nil {
+				return err
+			}
+			if previous.Scanned.IsZero() {
+				return fmt.Errorf("%v doesn't say when it was scanned", *since)
+			}
+			opts.since = previous.Scanned
+		}
+		if *followSymlinks && *skipSymlinks {
+			return errors.New("use only one of -follow-symlinks and -skip-symlinks")
+		}
+		if *followSymlinks {
+			opts.symlinks = symlinksFollow
+		}
+		if *skipSymlinks {
+			opts.symlinks = symlinksSkip
+		}
+		if *projects {
+			opts.projects = strings.Split(*markers, ",")
+		}
+		if !*quiet {
+			opts.progress = startProgress()
+		}
+		start := time.Now()
+		var dirsRead int64
+		opts.dirsRead = &dirsRead
+		scans := scanRoots(roots, opts, *rootTimeout, models)
+		opts.progress.stop()
+		m := MachineResult{
+			Scanned: start,
+			Result:  combineRoots(scans, rules),
+		}
+		if owner != nil {
+			m.User = owner.Username
+		}
+		m.Result.limitExamples(*examples)
+		m.Result.Usage = measureUsage(start, m.Result.Files, atomic.LoadInt64(&dirsRead))
+		var coverage []fsCoverage
+		if *showCoverage {
+			coverage = checkCoverage(scans)
+			m.Result.Warnings = append(m.Result.Warnings, coverageWarnings(coverage)...)
+		}
+		if *networkVersion != "" {
+			m.Result.Warnings = append(m.Result.Warnings, checkNetworkVersion(rules, *networkVersion)...)
+		}
+		if *archiveDepth > 0 {
+			fmt.Fprintln(progress, "Archives are counted as if extracted, up to depth", *archiveDepth)
+		}
+		// the text report has sections from the options besides the result,
+		// which the other formats are checked not to need above
+		if *format == "text" {
+			renderer = resultRenderer(func(w io.Writer, r *Result) error {
+				if len(roots) > 1 && *perRoot {
+					for _, s := range scans {
+						if s.result != nil {
+							fmt.Fprintln(w, "\n"+tr("Report for"), s.root)
+							s.result.Report(w)
+						}
+					}
+					fmt.Fprintln(w, "\n"+tr("Combined report"))
+				}
+				if len(roots) > 1 {
+					reportRoots(w, scans)
+				}
+				r.Report(w)
+				for _, model := range models {
+					model.report(w)
+				}
+				if opts.folders != nil {
+					opts.folders.report(w)
+				}
+				if opts.naming != nil {
+					opts.naming.report(w)
+				}
+				if opts.formats != nil {
+					opts.formats.report(w)
+				}
+				if opts.dedupe != nil {
+					opts.dedupe.report(w)
+				}
+				if opts.duplicates != nil {
+					opts.duplicates.report(w)
+				}
+				if opts.apparent != nil {
+					opts.apparent.report(w, r)
+				}
+				if opts.labels != nil {
+					opts.labels.report(w)
+				}
+				if opts.growth != nil {
+					opts.growth.report(w)
+				}
+				if *showCoverage {
+					reportCoverage(w, coverage)
+				}
+				if *measureRead || *uploadSpeed > 0 {
+					// Mbit/s to bytes per second
+					reportUploadTime(w, scans, *uploadSpeed*1000*1000/8)
+				}
+				if b != nil {
+					reportBaseline(w, *compare, r, b)
+				}
+				return nil
+			})
+		}
+		if err := renderOutput(*output, renderer, m.Result); err != nil {
+			return err
+		}
+		if *save != "" {
+			// only a saved result needs the id, which is created in the config
+			// directory the first time, so a scan alone works where that isn't
+			// writable
+			machineID, err := localMachineID()
+			if err != nil {
+				return err
+			}
+			m.MachineID = machineID
+			if err := saveResult(*save, m, *recipient); err != nil {
+				return err
+			}
+		}
+		return checkStrict(*strict, m.Result)
 	}
-	return allFiles
 }
 
-// prints out the details of the files
-func reportSizes(files []os.FileInfo) {
-	var gt int64
-	var lt int64
-	var totalChunks int64      // how many chunks of any size on this disk
-	var largeChunks int64      // how many 1 MB chunks on this disk
-	var smallChunks int64      // how many chunks smaller than 1 MB on this disk
-	var largeGigabytes float64 // total gigabytes consumed by large files
-	var smallGigabytes float64 // total gigabytes consumed by small files
-	histogram := map[int64]int64{
-		0:    0,
-		100:  0,
-		200:  0,
-		300:  0,
-		400:  0,
-		500:  0,
-		600:  0,
-		700:  0,
-		800:  0,
-		900:  0,
-		1000: 0,
-	}
-	for _, file := range files {
-		size := file.Size()
-		if size > OneMb {
-			gt = gt + 1
-			largeGigabytes = largeGigabytes + float64(size)/float64(OneGb)
-			fileChunks := int64(math.Ceil(float64(size) / float64(OneMb)))
-			totalChunks = totalChunks + fileChunks + 1                   // + 1 for datamap
-			largeChunks = largeChunks + fileChunks - 1                   // - 1 for last chunk which is smaller
-			smallChunks = smallChunks + 2                                // + 2 for last chunk plus datamap
-			histogram = addToHistogram(histogram, 1024, fileChunks-1)    // large chunks
-			histogram = addToHistogram(histogram, (size%OneMb)/OneKb, 1) // last chunk
-			histogram = addToHistogram(histogram, 1, 1)                  // datamap
-		} else {
-			lt = lt + 1
-			smallGigabytes = smallGigabytes + float64(size)/float64(OneGb)
-			// files less than 3KB are chunked to a minimum of 3 chunks, each
-			// chunk being 1/3 of the original file size.
-			if size < 3*OneKb {
-				totalChunks = totalChunks + 1 // + 1 for datamap with no chunks
-				smallChunks = smallChunks + 1 // + 1 for datamap with no chunks
-				histogram = addToHistogram(histogram, size/OneKb, 1)
-			} else {
-				totalChunks = totalChunks + 4                          // + 3 + 1 for 3 chunks plus datamap
-				smallChunks = smallChunks + 4                          // + 3 + 1 for 3 chunks plus datamap
-				histogram = addToHistogram(histogram, size/OneKb/3, 3) // chunks
-				histogram = addToHistogram(histogram, 1, 1)            // datamap which is typically about 500 B
-			}
-		}
-	}
-	// stats
-	fmt.Println("Total files:", len(files))
-	fmt.Printf("Files larger than 1 MB: %v (%f GB)\n", gt, largeGigabytes)
-	fmt.Printf("Files smaller than 1 MB: %v (%f GB)\n", lt, smallGigabytes)
-	fmt.Println("Total chunks:", totalChunks)
-	fmt.Println("Large chunks:", largeChunks)
-	fmt.Println("Small chunks:", smallChunks)
-	// histogram
-	fmt.Println("\nChunk Size  Count")
-	reportHistogram(histogram)
+// the scan flags whose sections are only in the text report, as the other
+// formats render the result alone
+var textOnlyFlags = []string{
+	"per-root", "containers", "modify-rates", "chunk-sizes", "small-file-rules",
+	"stats", "put-cost", "gb-cost", "folder-entries", "public-names",
+	"formats", "dedupe", "duplicates", "label", "growth",
+	"coverage", "measure-read", "upload-speed", "compare-baseline",
 }
 
-func addToHistogram(histogram map[int64]int64, size, count int64) map[int64]int64 {
-	key := (size / 100) * 100
-	_, exists := histogram[key]
-	if !exists {
-		fmt.Println("Missing key in histogram", key)
-		histogram[key] = 0
+// returns an error naming any flag set that only adds to the text report, so
+// a section asked for is never silently left out of another format
+func checkTextOnlyFlags(flags *flag.FlagSet, format string) error {
+	set := []string{}
+	flags.Visit(func(f *flag.Flag) {
+		for _, name := range textOnlyFlags {
+			if f.Name == name {
+				set = append(set, "-"+name)
+			}
+		}
+	})
+	if len(set) > 0 {
+		return fmt.Errorf("-format %v has no sections for %v, use the text format for them", format, strings.Join(set, ", "))
 	}
-	histogram[key] = histogram[key] + count
-	return histogram
+	return nil
 }
 
-func reportHistogram(h map[int64]int64) {
-	sortedKeys := []int{}
-	for key := range h {
-		sortedKeys = append(sortedKeys, int(key))
-	}
-	sort.Ints(sortedKeys)
-	for _, sortedKey := range sortedKeys {
-		spacing := ""
-		upperRange := "-" + strconv.Itoa(sortedKey+100)
-		if sortedKey < 1 {
-			spacing = "   "
-			upperRange = "-" + strconv.Itoa(sortedKey+100) + " KB"
-		} else if sortedKey < 900 {
-			spacing = " "
-			upperRange = "-" + strconv.Itoa(sortedKey+100) + "   "
-		} else if sortedKey < 999 {
-			spacing = " "
-			upperRange = "-" + strconv.Itoa(sortedKey+100) + "  "
-		} else {
-			upperRange = "+      "
+// returns an error if the scan is strict and a directory couldn't be read,
+// so scripts can tell a complete scan from one with directories missing
+func checkStrict(strict bool, r *Result) error {
+	if !strict {
+		return nil
+	}
+	unread := 0
+	for _, warning := range r.Warnings {
+		if warning.Code == "read_error" || warning.Code == "op_timeout" {
+			unread = unread + 1
+		}
+	}
+	if unread > 0 {
+		return fmt.Errorf("%v directories could not be read, see the warnings", unread)
+	}
+	return nil
+}
+
+// renders a result to a file, or to stdout if filename is empty
+func renderOutput(filename string, renderer chunkdist.Renderer, r *Result) error {
+	if filename == "" {
+		return renderer.Render(os.Stdout, r)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err := renderer.Render(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writes a result to a file as json, encrypted if a recipient is given
+func saveResult(filename string, m MachineResult, recipient string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if recipient != "" {
+		data, err = seal(data, recipient)
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(filename, data, 0600)
+}
+
+// returns the home directory of the current user
+func homeDir() (string, error) {
+	u, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+	return u.HomeDir, nil
+}
+
+// fileModel is an alternative model that is given the size of every scanned
+// file, and reports on it after the scan.
+type fileModel interface {
+	addFile(size int64)
+	report(w io.Writer)
+}
+
+// options that change how a directory is scanned
+type scanOptions struct {
+	rules        Rules          // the chunking rules, or the default rules if not set
+	archiveDepth int            // how many levels of nested archives to count as extracted
+	opTimeout    time.Duration  // how long to wait for each directory read, or forever if zero
+	measureRead  bool           // sample reads to measure how fast the files can be read
+	projects     []string       // names of files that mark a project, to report each project
+	mutable      patternList    // patterns of mutable files, to report them separately from static files
+	exclude      patternList    // patterns of files and directories to skip
+	include      patternList    // patterns of the only files to count, or every file if empty
+	ignoreFiles  bool           // skip entries listed in .gitignore and .chunkdistignore files
+	symlinks     string         // how symbolic links are handled, counting the links if not set
+	xdev         bool           // skip directories on other filesystems than the root, like du -x
+	maxDepth     int            // levels of directories to scan, the root being the first, or every level if zero
+	partialFiles string         // how to count partial downloads, included if not set
+	examples     int            // how many example files to record for each histogram bucket
+	redact       bool           // record a hash of each example's path instead of the path
+	owner        string         // the user id whose files are counted, or everyone's if not set
+	since        time.Time      // only count files modified after this, or every file if zero
+	links        *linkSet       // files with several hard links counted so far, or every link is counted if not set
+	workers      int            // directories to read at the same time
+	lowMemory    bool           // keep sketches instead of a count for every directory, for devices with little memory
+	apparent     *apparentModel // counts files by apparent size while the result counts their size on disk, if set
+	progress     *progressMeter // counts the files scanned to show progress, if set
+	dirsRead     *int64         // counts the directories read, if set
+	naming       *namingModel   // counts the naming objects to publish each top level directory, if set
+	folders      *folderModel   // counts the entries in each directory, if set
+	formats      *formatModel   // counts the chunks of files prepared for upload, if set
+	dedupe       *dedupeModel   // hashes the chunks of each file to find duplicates, if set
+	labels       *labelModel    // counts the files of each label separately, if set
+	duplicates   *dupeModel     // records files by size to find identical files, if set
+	growth       *growthModel   // totals each category of file to project its growth, if set
+	largestFirst bool           // scan the largest top level directories first, keeping a partial result on timeout
+	// changes the size of each file before it is chunked, if set
+	transform chunkdist.SizeTransformer
+}
+
+// a file found by walking a directory
+type file struct {
+	path    string
+	info    os.FileInfo
+	project string // the project directory the file is part of, if any
+}
+
+// returns the chunk distribution of all files in a directory, also adding
+// each file to any alternative models
+func scan(ctx context.Context, dirname string, opts scanOptions, models ...fileModel) *Result {
+	// paths below the root are made with path.Join, which cleans them
+	dirname = path.Clean(dirname)
+	r := NewResult()
+	if opts.rules.Name != "" {
+		r.Rules = opts.rules
+	}
+	sampler := newReadSampler()
+	// each worker walking the tree adds files to a result and finder of its
+	// own, so they need no lock, and the workers are merged after the walk
+	workers := opts.workers
+	if workers < 1 {
+		workers = 1
+	}
+	b := newResultBuilder(r.Rules)
+	results := make([]*Result, workers)
+	finders := make([]*anomalyFinder, workers)
+	for i := range results {
+		results[i] = b.Worker()
+		finders[i] = newAnomalyFinder()
+		if opts.lowMemory {
+			finders[i] = newLowMemoryAnomalyFinder()
+		}
+	}
+	// the models and sampler are shared by the workers, so are only locked
+	// when there are any
+	var mu sync.Mutex
+	shared := opts.measureRead || len(models) > 0 || opts.labels != nil || opts.growth != nil ||
+		opts.formats != nil || opts.dedupe != nil || opts.duplicates != nil || opts.apparent != nil
+	blockSize, _ := fsBlockSize(dirname)
+	// files not counted because they belong to someone else
+	var otherFiles, otherBytes int64
+	// files not counted because they haven't changed since opts.since
+	var unchangedFiles, unchangedBytes int64
+	// hard links not counted because another link to the file was
+	var extraLinks, extraLinkBytes int64
+	w := &walker{
+		ctx:            ctx,
+		opTimeout:      opts.opTimeout,
+		projectMarkers: opts.projects,
+		workers:        opts.workers,
+		root:           dirname,
+		exclude:        opts.exclude,
+		include:        opts.include,
+		ignoreFiles:    opts.ignoreFiles,
+		symlinks:       opts.symlinks,
+		xdev:           opts.xdev,
+		maxDepth:       opts.maxDepth,
+	}
+	// adds a file to the result of a worker, returning its chunks and bytes
+	add := func(worker int, f file) (int64, int64) {
+		if ctx.Err() != nil {
+			return 0, 0
+		}
+		r := results[worker]
+		if uid, ok := fileOwner(f.info); ok && opts.owner != "" && uid != opts.owner {
+			atomic.AddInt64(&otherFiles, 1)
+			atomic.AddInt64(&otherBytes, f.info.Size())
+			return 0, 0
+		}
+		if opts.links != nil && linkCount(f.info) > 1 {
+			if id, ok := fileID(f.info); ok && !opts.links.first(id) {
+				atomic.AddInt64(&extraLinks, 1)
+				atomic.AddInt64(&extraLinkBytes, f.info.Size())
+				return 0, 0
+			}
+		}
+		if !opts.since.IsZero() && !f.info.ModTime().After(opts.since) {
+			atomic.AddInt64(&unchangedFiles, 1)
+			atomic.AddInt64(&unchangedBytes, f.info.Size())
+			return 0, 0
+		}
+		if opts.partialFiles != "" && opts.partialFiles != partialInclude && isPartial(f) {
+			if opts.partialFiles == partialSeparate {
+				size := f.info.Size()
+				r.PartialFiles = r.PartialFiles + 1
+				r.PartialBytes = r.PartialBytes + size
+				r.PartialChunks = r.PartialChunks + r.Rules.ChunksForSize(size).Count + 1
+			}
+			return 0, 0
+		}
+		r.addDiskBytes(diskBytes(f.info, blockSize))
+		var chunks int64
+		var bytes int64
+		rel := w.rel(f.path)
+		sizes, err := fileSizes(f, opts)
+		if err != nil {
+			r.Warnings = append(r.Warnings, Warning{
+				Code:    "archive_error",
+				Subject: f.path,
+				Message: "counted as a file, " + err.Error(),
+			})
+		}
+		for _, size := range sizes {
+			r.AddFile(size)
+			if opts.examples > 0 {
+				r.addExample(f.path, size, opts.examples, opts.redact)
+			}
+			chunks = chunks + r.Rules.ChunksForSize(size).Count + 1 // + 1 for datamap
+			bytes = bytes + size
+		}
+		finders[worker].add(f, chunks)
+		if shared {
+			mu.Lock()
+			if opts.measureRead {
+				sampler.add(f)
+			}
+			label := ""
+			if opts.labels != nil {
+				label = opts.labels.label(rel)
+			}
+			for _, size := range sizes {
+				if opts.labels != nil {
+					opts.labels.addFile(label, rel, size)
+				}
+				if opts.growth != nil {
+					opts.growth.addFile(f.path, size)
+				}
+				for _, m := range models {
+					m.addFile(size)
+				}
+			}
+			if opts.formats != nil {
+				opts.formats.addFile(f)
+			}
+			if opts.dedupe != nil {
+				opts.dedupe.addFile(f)
+			}
+			if opts.duplicates != nil {
+				opts.duplicates.addFile(f)
+			}
+			if opts.apparent != nil {
+				opts.apparent.addFile(f)
+			}
+			mu.Unlock()
+		}
+		opts.progress.add(f, chunks)
+		extension := fileExtension(f.path)
+		total := r.Extensions[extension]
+		total.Chunks = total.Chunks + chunks
+		total.Bytes = total.Bytes + bytes
+		r.Extensions[extension] = total
+		if len(opts.mutable) > 0 {
+			class := dataClass(rel, opts.mutable)
+			total := r.Classes[class]
+			total.Chunks = total.Chunks + chunks
+			total.Bytes = total.Bytes + bytes
+			r.Classes[class] = total
+		}
+		if len(opts.projects) > 0 {
+			project := f.project
+			if project == "" {
+				project = noProjectName
+			}
+			total := r.Projects[project]
+			total.Chunks = total.Chunks + chunks
+			total.Bytes = total.Bytes + bytes
+			r.Projects[project] = total
+		}
+		return chunks, bytes
+	}
+	if opts.folders != nil || opts.dirsRead != nil {
+		w.onDir = func(entries int64) {
+			if opts.dirsRead != nil {
+				atomic.AddInt64(opts.dirsRead, 1)
+			}
+			if opts.folders != nil {
+				opts.folders.addDir(entries)
+			}
+		}
+	}
+	files, names, project, ignore := w.readRoot(dirname)
+	var estimates map[string]int64
+	if opts.largestFirst {
+		estimates = w.largestFirst(dirname, names, ignore)
+	}
+	for _, f := range files {
+		add(0, f)
+	}
+	scanned := 0
+	for _, name := range names {
+		// the totals of each worker for the directory
+		totals := make([]DirTotal, workers)
+		var dirFiles int64
+		w.walkTreeWorkers(path.Join(dirname, name), project, ignore, func(worker int, f file) {
+			chunks, bytes := add(worker, f)
+			totals[worker].Chunks = totals[worker].Chunks + chunks
+			totals[worker].Bytes = totals[worker].Bytes + bytes
+			atomic.AddInt64(&dirFiles, 1)
+		})
+		if ctx.Err() != nil {
+			break
+		}
+		var total DirTotal
+		for _, t := range totals {
+			total.Chunks = total.Chunks + t.Chunks
+			total.Bytes = total.Bytes + t.Bytes
+		}
+		r.Dirs[name] = total
+		if opts.naming != nil {
+			opts.naming.addFolder(dirname, name, dirFiles, total.Chunks)
+		}
+		scanned = scanned + 1
+	}
+	r.Merge(b.Totals())
+	finder := finders[0]
+	for _, other := range finders[1:] {
+		finder.merge(other)
+	}
+	if ctx.Err() != nil && opts.largestFirst {
+		r.Warnings = append(r.Warnings, partialScanWarning(dirname, names, scanned, estimates))
+	}
+	r.Warnings = append(r.Warnings, w.warnings...)
+	r.Warnings = append(r.Warnings, overflowWarnings(r, dirname)...)
+	if w.tooDeep > 0 {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    "max_depth",
+			Subject: dirname,
+			Message: fmt.Sprintf("%v directories deeper than -max-depth %v aren't counted", w.tooDeep, opts.maxDepth-1),
+		})
+	}
+	if w.changed > 0 {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    "changed_during_scan",
+			Subject: dirname,
+			Message: fmt.Sprintf("%v files and directories were deleted while the scan listed them and aren't counted", w.changed),
+		})
+	}
+	if w.special > 0 {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    "special_files",
+			Subject: dirname,
+			Message: fmt.Sprintf("%v sockets, devices, FIFOs and other special files aren't counted", w.special),
+		})
+	}
+	if otherFiles > 0 {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    "other_owner",
+			Subject: dirname,
+			Message: fmt.Sprintf("%v files (%f GB) owned by other users aren't counted", otherFiles, float64(otherBytes)/float64(OneGb)),
+		})
+	}
+	if extraLinks > 0 {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    "hard_links",
+			Subject: dirname,
+			Message: fmt.Sprintf("%v hard links (%f GB) to files already counted aren't counted again, see -count-hard-links", extraLinks, float64(extraLinkBytes)/float64(OneGb)),
+		})
+	}
+	if unchangedFiles > 0 {
+		r.Warnings = append(r.Warnings, Warning{
+			Code:    "unchanged_files",
+			Subject: dirname,
+			Message: fmt.Sprintf("%v files (%f GB) not modified since %v aren't counted", unchangedFiles, float64(unchangedBytes)/float64(OneGb), opts.since.Format(time.RFC3339)),
+		})
+	}
+	r.Anomalies = finder.anomalies(r.TotalChunks)
+	if opts.measureRead && ctx.Err() == nil {
+		r.ReadRate = sampler.measure()
+	}
+	return r
+}
+
+// returns a warning that a scan was cut short after the given number of its
+// largest directories, with the share of the estimated bytes they hold
+func partialScanWarning(root string, names []string, scanned int, estimates map[string]int64) Warning {
+	var covered, total int64
+	for i, name := range names {
+		if i < scanned {
+			covered = covered + estimates[name]
+		}
+		total = total + estimates[name]
+	}
+	return Warning{
+		Code:    "partial_scan",
+		Subject: root,
+		Message: fmt.Sprintf("timed out after the largest %v of %v directories, about %.0f%% of the bytes",
+			scanned, len(names), percent(covered, total)),
+	}
+}
+
+// the extension used for files without one
+const noExtensionName = "(none)"
+
+// returns the lowercase extension of a file, used to total by file type
+func fileExtension(path string) string {
+	extension := strings.ToLower(filepath.Ext(path))
+	if extension == "" {
+		return noExtensionName
+	}
+	return extension
+}
+
+// returns the sizes of the files to count for a file, which is the file
+// itself unless it is an archive being counted as extracted. An archive that
+// can't be read is counted as a file, with the error returned for a warning.
+func fileSizes(f file, opts scanOptions) ([]int64, error) {
+	sizes := []int64{f.info.Size()}
+	if opts.apparent != nil {
+		sizes[0] = usedBytes(f.info)
+	}
+	var err error
+	if opts.archiveDepth > 0 && isArchive(f.path) {
+		var extracted []int64
+		extracted, err = archiveSizes(f.path, opts.archiveDepth)
+		if err == nil {
+			sizes = extracted
+		}
+	}
+	if opts.transform != nil {
+		for i, size := range sizes {
+			sizes[i] = opts.transform.TransformSize(f.path, size)
 		}
-		fmt.Printf(spacing+"%v%v %v\n", sortedKey, upperRange, h[int64(sortedKey)])
 	}
+	return sizes, err
 }
@@ -0,0 +1,1200 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func BenchmarkWalkers(b *testing.B) {
+	root := b.TempDir()
+	created, err := makeSyntheticTree(root, treeShape{depth: 2, dirsPerDir: 5, filesPerDir: 20})
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, bw := range benchWalkers {
+		for _, profile := range storageProfiles {
+			b.Run(bw.name+"/"+profile.name, func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if found := bw.walk(root, profile); found != created {
+						b.Fatalf("found %v of %v files", found, created)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSummarizeSizes(t *testing.T) {
+	// 1 to 100, one file of each size
+	counts := map[int64]int64{}
+	for size := int64(1); size <= 100; size++ {
+		counts[size] = 1
+	}
+	s := summarizeSizes(counts)
+	if s.count != 100 || s.min != 1 || s.max != 100 || s.mean != 50.5 || s.median != 50 {
+		t.Fatalf("got count %v min %v max %v mean %v median %v", s.count, s.min, s.max, s.mean, s.median)
+	}
+	if !reflect.DeepEqual(s.percentiles, []int64{90, 95, 99}) {
+		t.Fatalf("got percentiles %v", s.percentiles)
+	}
+	// the same size many times has no spread
+	if s := summarizeSizes(map[int64]int64{7: 1000}); s.stddev != 0 || s.median != 7 {
+		t.Fatalf("got median %v and standard deviation %v for one size", s.median, s.stddev)
+	}
+}
+
+func TestPatternListRegexpWithComma(t *testing.T) {
+	p := patternList{}
+	if err := p.Set(`*.iso,re:^a/f[0-9]{1,2}\.bin$`); err != nil {
+		t.Fatal(err)
+	}
+	if len(p) != 2 {
+		t.Fatalf("got %v patterns from a glob and a regexp, expected 2", len(p))
+	}
+	for rel, expected := range map[string]bool{"a/f7.bin": true, "a/f42.bin": true, "a/f123.bin": false, "b/x.iso": true, "a/f.bin": false} {
+		if p.matches(rel) != expected {
+			t.Errorf("%v matched %v, expected %v", rel, !expected, expected)
+		}
+	}
+	if err := (&patternList{}).Set("re:a(b"); err == nil {
+		t.Fatal("expected an error for a regexp that doesn't compile")
+	}
+}
+
+func TestTokenNotSentOverHTTP(t *testing.T) {
+	sent := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = sent || r.Header.Get("Authorization") != ""
+	}))
+	defer server.Close()
+	sec := &security{token: "secret"}
+	// an agent advertising plain http must not get the token
+	agent := mdnsAgent{instance: "fake", addr: server.Listener.Addr().String()}
+	if _, err := fetchResult(server.Client(), sec, agent); err == nil {
+		t.Fatal("expected an error fetching over http with a token")
+	}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	if err := sec.authorize(req); err == nil || req.Header.Get("Authorization") != "" {
+		t.Fatal("the token was added to an http request")
+	}
+	if sent {
+		t.Fatal("the token was sent over http")
+	}
+}
+
+func TestCollectorRejectsGzipBomb(t *testing.T) {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zeros := make([]byte, OneMb)
+	for written := int64(0); written <= maxResultBytes; written = written + OneMb {
+		zw.Write(zeros)
+	}
+	zw.Close()
+	req := httptest.NewRequest("POST", "/results", &body)
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	c := &collector{machines: map[string]MachineResult{}}
+	c.serveResults(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("got status %v for %v KB expanding past the limit", w.Code, body.Len()/OneKb)
+	}
+}
+
+func TestFetchResultRejectsGzipBomb(t *testing.T) {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zw.Write([]byte(`{"machine_id":"`))
+	name := bytes.Repeat([]byte("a"), OneMb)
+	for written := int64(0); written <= maxResultBytes; written = written + OneMb {
+		zw.Write(name)
+	}
+	zw.Write([]byte(`"}`))
+	zw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(body.Bytes())
+	}))
+	defer server.Close()
+	agent := mdnsAgent{addr: strings.TrimPrefix(server.URL, "http://")}
+	_, err := fetchResult(server.Client(), &security{}, agent)
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Fatalf("got error %v for %v KB expanding past the limit", err, body.Len()/OneKb)
+	}
+}
+
+func TestZipEntryLargerThanInt64(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, size := range []uint64{1, math.MaxUint64} {
+		w, err := zw.CreateRaw(&zip.FileHeader{Name: fmt.Sprint(size), Method: zip.Store, UncompressedSize64: size})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("x"))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := &archiveBudget{maxArchiveEntries, maxArchiveBytes}
+	sizes, err := readZip(bytes.NewReader(archive.Bytes()), int64(archive.Len()), 1, b)
+	if err != errArchiveLimit {
+		t.Fatalf("got sizes %v and error %v, expected the archive limit", sizes, err)
+	}
+	if b.bytes > maxArchiveBytes {
+		t.Fatalf("the budget grew to %v bytes", b.bytes)
+	}
+}
+
+func TestUnreadableArchiveCountedAsFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "broken.zip")
+	if err := os.WriteFile(name, []byte("not a zip"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes, err := fileSizes(file{path: name, info: info}, scanOptions{archiveDepth: 1})
+	if err == nil {
+		t.Fatal("expected an error for the warnings from an archive that can't be read")
+	}
+	if !reflect.DeepEqual(sizes, []int64{9}) {
+		t.Fatalf("got sizes %v, expected the archive counted as one file of 9 bytes", sizes)
+	}
+}
+
+func TestChunkSizesChangeFromChosenRules(t *testing.T) {
+	m, err := newChunkSizeModel(ruleSets[defaultRules], "256K,4M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.addFile(8 * OneMb)
+	var out bytes.Buffer
+	m.report(&out)
+	// with the datamap, 9 chunks under the chosen 1 MB rules, 33 for 256K
+	// and 3 for 4M
+	for _, line := range []string{"(safe-2018): 9 chunks", "256K  33  +266.7%", "4M  3  -66.7%"} {
+		if !bytes.Contains(out.Bytes(), []byte(line)) {
+			t.Errorf("expected %q in the report, got\n%v", line, out.String())
+		}
+	}
+}
+
+func TestWalkRootWithTrailingSlash(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"top.bin", "a/keep.bin", "a/b/skip.bin", "a/c/deep/file.bin"} {
+		filename := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exclude := patternList{}
+	if err := exclude.Set("re:^a/b$"); err != nil {
+		t.Fatal(err)
+	}
+	// three levels: the root, a and a/b or a/c, leaving out a/c/deep
+	w := &walker{ctx: context.Background(), root: root + "/", exclude: exclude, maxDepth: 3}
+	rootFiles, dirs := w.walkRoot(root + "/")
+	found := []string{}
+	for _, f := range rootFiles {
+		found = append(found, w.rel(f.path))
+	}
+	for _, files := range dirs {
+		for _, f := range files {
+			found = append(found, w.rel(f.path))
+		}
+	}
+	sort.Strings(found)
+	if expected := []string{"a/keep.bin", "top.bin"}; !reflect.DeepEqual(found, expected) {
+		t.Errorf("got files %v, expected %v", found, expected)
+	}
+	if w.tooDeep != 1 {
+		t.Errorf("got %v directories too deep, expected 1", w.tooDeep)
+	}
+	for _, c := range []struct{ root, filename, rel string }{
+		{".", "a/b", "a/b"},
+		{"./x", "x/a", "a"},
+		{"x/", "x/a/b", "a/b"},
+		{"/", "/a", "a"},
+		{"x/", "x", ""},
+	} {
+		w := &walker{root: c.root}
+		if got := w.rel(c.filename); got != c.rel {
+			t.Errorf("root %q: got %q for %v, expected %q", c.root, got, c.filename, c.rel)
+		}
+	}
+}
+
+func TestSealedFormat(t *testing.T) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := encodeKey(publicKeyPrefix, key.PublicKey().Bytes())
+	sealed, err := seal([]byte(`{"files":1}`), public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// decrypt by the documented format, without open
+	magic := "chunk_distribution-sealed-v1\n"
+	if !bytes.HasPrefix(sealed, []byte(magic)) {
+		t.Fatalf("sealed data doesn't start with %q", magic)
+	}
+	rest := sealed[len(magic):]
+	ephemeral, err := ecdh.X25519().NewPublicKey(rest[:32])
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := append(append([]byte{}, rest[:32]...), key.PublicKey().Bytes()...)
+	aesKey, err := hkdf.Key(sha256.New, shared, salt, "chunk_distribution result", 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := aead.Open(nil, rest[32:44], rest[44:], []byte(magic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"files":1}` {
+		t.Fatalf("got %q", data)
+	}
+	opened, err := open(sealed, encodeKey(privateKeyPrefix, key.Bytes()))
+	if err != nil || !bytes.Equal(opened, data) {
+		t.Fatalf("open got %q, %v", opened, err)
+	}
+}
+
+func TestTextOnlyFlagsRejectedWithFormat(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		ok   bool
+	}{
+		{[]string{"-quiet", "-o", "r.json"}, true},
+		{[]string{"-stats"}, false},
+		{[]string{"-chunk-sizes", "256K,4M", "-quiet"}, false},
+		{[]string{"-compare-baseline", "developer"}, false},
+	} {
+		flags := flag.NewFlagSet("", flag.ContinueOnError)
+		flags.Bool("quiet", false, "")
+		flags.String("o", "", "")
+		flags.Bool("stats", false, "")
+		flags.String("chunk-sizes", "", "")
+		flags.String("compare-baseline", "", "")
+		if err := flags.Parse(c.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkTextOnlyFlags(flags, "json"); (err == nil) != c.ok {
+			t.Errorf("%v: got error %v", c.args, err)
+		}
+	}
+}
+
+func TestConvertCSVWithOtherChunkSize(t *testing.T) {
+	rules, err := customRules(ruleSets[defaultRules], "256K", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := MachineResult{MachineID: "m", Result: NewResult()}
+	m.Result.Rules = rules
+	m.Result.AddFile(3 * OneMb)
+	converted, err := encodeResult(m, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check, err := decodeResult(converted, "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := compareResults(m, check); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestImportKeepsHiddenDirectories(t *testing.T) {
+	for _, c := range []struct {
+		listing string
+		dirs    []string
+	}{
+		{"10 ./.config/x\n20 ./Documents/y\n", []string{".config", "Documents"}},
+		{"10 ./home/.cache/x\n20 ./home/Music/y\n", []string{".cache", "Music"}},
+		{"10 /home/a/.ssh/id\n20 /home/b/notes\n", []string{"a", "b"}},
+		{"10 .bashrc/x\n20 .profile/y\n", []string{".bashrc", ".profile"}},
+	} {
+		entries, err := readSizeListing(strings.NewReader(c.listing), 1, " ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dirs := []string{}
+		for name := range importResult(entries, ruleSets[defaultRules]).Dirs {
+			dirs = append(dirs, name)
+		}
+		sort.Strings(dirs)
+		if !reflect.DeepEqual(dirs, c.dirs) {
+			t.Errorf("%q: got dirs %v, expected %v", c.listing, dirs, c.dirs)
+		}
+	}
+}
+
+func TestScanWorkersMatchOneWorker(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 40; i++ {
+		filename := filepath.Join(root, fmt.Sprintf("d%v/e%v/f%v.txt", i%3, i%5, i))
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, make([]byte, i*100*OneKb), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := scanOptions{rules: ruleSets[defaultRules], projects: []string{"e1"}}
+	one := scan(context.Background(), root, opts)
+	opts.workers = 4
+	four := scan(context.Background(), root, opts)
+	if !reflect.DeepEqual(one.Totals, four.Totals) {
+		t.Errorf("got totals %+v with 4 workers, expected %+v", four.Totals, one.Totals)
+	}
+	for name, maps := range map[string][2]map[string]DirTotal{
+		"dirs":       {one.Dirs, four.Dirs},
+		"extensions": {one.Extensions, four.Extensions},
+		"projects":   {one.Projects, four.Projects},
+	} {
+		if !reflect.DeepEqual(maps[0], maps[1]) {
+			t.Errorf("got %v %v with 4 workers, expected %v", name, maps[1], maps[0])
+		}
+	}
+}
+
+func TestOverlapSharesChunksOfChangedFiles(t *testing.T) {
+	rules := ruleSets[defaultRules]
+	content := make([]byte, 10*rules.ChunkSize-1)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+	trees := []*overlapTree{}
+	for i, data := range [][]byte{content, append(content, 'x')} {
+		root := t.TempDir()
+		files := []file{}
+		for name, data := range map[string][]byte{"big.bin": data, "small.txt": []byte("hi")} {
+			filename := filepath.Join(root, name)
+			if err := os.WriteFile(filename, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files = append(files, file{path: filename, info: info})
+		}
+		tree := &overlapTree{root: fmt.Sprint("tree", i), files: files, ids: map[string]overlapChunk{}, datamaps: map[string]bool{}}
+		hashOverlapTree(rules, tree)
+		trees = append(trees, tree)
+	}
+	var out bytes.Buffer
+	reportOverlap(&out, rules, trees[0], trees[1])
+	// the appended byte changes the last chunk, and the first two, whose keys
+	// come from it, leaving 7 of 10 chunks shared, and the small file's datamap
+	for _, line := range []string{
+		"tree0  12\n",
+		"Shared distinct files: 1\n",
+		"Shared: 8 chunks",
+		"Chunks to upload tree1 after tree0: 4 ",
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("expected %q in the report, got\n%v", line, out.String())
+		}
+	}
+}
+
+func TestCommandFlagsWithoutRunning(t *testing.T) {
+	if err := setLanguage("es"); err != nil {
+		t.Fatal(err)
+	}
+	defer setLanguage("en")
+	for _, c := range commands {
+		flags := c.flags()
+		if flags.Parsed() {
+			t.Errorf("the flags of %q were parsed, so it ran", c.name)
+		}
+		if c.name == "" && flags.Lookup("format") == nil {
+			t.Errorf("the scan has no -format flag")
+		}
+	}
+	if language != "es" {
+		t.Errorf("listing the flags changed the language to %v", language)
+	}
+}
+
+func TestCustomRules(t *testing.T) {
+	rules := ruleSets[defaultRules]
+	for _, c := range []struct {
+		chunkSize, minFileSize string
+		valid                  bool
+	}{
+		{"", "", true},
+		{"4M", "", true},
+		{"", "1K", true},
+		{"", "3", true},
+		{"3", "3", true},
+		{"", "2", false},
+		{"", "1", false},
+		{"", "0", false},
+		{"2", "", false},
+		{"", "lots", false},
+	} {
+		custom, err := customRules(rules, c.chunkSize, c.minFileSize, false)
+		if (err == nil) != c.valid {
+			t.Errorf("-chunk-size %q -min-file-size %q: got error %v", c.chunkSize, c.minFileSize, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		// no file can then be split into an empty chunk
+		for _, size := range []int64{custom.MinFileSize, custom.MinFileSize + 1, custom.ChunkSize * custom.MinChunks} {
+			chunks := custom.ChunksForSize(size)
+			if chunks.Count > 0 && (chunks.Size == 0 || chunks.LastSize == 0) {
+				t.Errorf("-chunk-size %q -min-file-size %q: empty chunks for %v bytes: %+v", c.chunkSize, c.minFileSize, size, chunks)
+			}
+		}
+	}
+}
+
+func TestCatalogsHaveEveryMessage(t *testing.T) {
+	filenames, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := map[string]bool{}
+	fset := token.NewFileSet()
+	for _, filename := range filenames {
+		if strings.HasSuffix(filename, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filename, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) != 1 {
+				return true
+			}
+			if name, ok := call.Fun.(*ast.Ident); !ok || name.Name != "tr" {
+				return true
+			}
+			literal, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || literal.Kind != token.STRING {
+				t.Errorf("%v: tr is only given string literals, to check here", fset.Position(call.Pos()))
+				return true
+			}
+			message, err := strconv.Unquote(literal.Value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			messages[message] = true
+			return true
+		})
+	}
+	for lang, catalog := range catalogs {
+		for message := range messages {
+			if _, exists := catalog[message]; !exists {
+				t.Errorf("%v has no translation for %q", lang, message)
+			}
+		}
+		for message := range catalog {
+			if !messages[message] {
+				t.Errorf("%v translates %q, which isn't used", lang, message)
+			}
+		}
+	}
+}
+
+func TestImportParsers(t *testing.T) {
+	du := func(r io.Reader) ([]importEntry, error) {
+		return readSizeListing(r, OneKb, "\t")
+	}
+	find := func(r io.Reader) ([]importEntry, error) {
+		return readSizeListing(r, 1, " ")
+	}
+	for _, c := range []struct {
+		name    string
+		read    func(io.Reader) ([]importEntry, error)
+		listing string
+		entries []importEntry // sorted by path, or nil for an error
+	}{
+		{"du", du, "4\t./a/x\n8\t./a/y\n12\t./a\n16\t.\n", []importEntry{{"a/x", 4 * OneKb}, {"a/y", 8 * OneKb}}},
+		{"du with a tab in a name", du, "1\t./a\tb\n", []importEntry{{"a\tb", OneKb}}},
+		{"du without a path", du, "12\n", nil},
+		{"find", find, "100 /home/u/file one.txt\n\n200 /home/u/b\n", []importEntry{{"/home/u/b", 200}, {"/home/u/file one.txt", 100}}},
+		{"find with a bad size", find, "big /home/u/b\n", nil},
+		{"ncdu", readNcdu, `[1,0,{"progname":"ncdu"},[{"name":"/home/u"},{"name":"a","asize":10},[{"name":"sub"},{"name":"b","asize":20},{"name":"c","asize":5,"excluded":"pattern"}]]]`,
+			[]importEntry{{"/home/u/a", 10}, {"/home/u/sub/b", 20}}},
+		{"ncdu without a tree", readNcdu, `[1,0,{}]`, nil},
+		{"ncdu that isn't json", readNcdu, `{`, nil},
+		{"rsync", readRsync, "drwxr-xr-x          4,096 2024/01/02 10:00:00 .\n" +
+			"-rw-r--r--          1,234 2024/01/02 10:00:00 docs/a file.txt\n" +
+			"-rw-r--r--             10 2024/01/02 10:00:00 b\n" +
+			"module  comment\n",
+			[]importEntry{{"/b", 10}, {"/docs/a file.txt", 1234}}},
+		{"rsync without a path", readRsync, "-rw-r--r-- 12 2024/01/02 10:00:00\n", nil},
+		{"rsync with human readable sizes", readRsync, "-rw-r--r-- 1.2K 2024/01/02 10:00:00 a\n", nil},
+		{"treesize", readSizeCSV, `TreeSize Report
+"Full Path","Size","Files"
+"C:\Users\u\","3.0 MB",2
+"C:\Users\u\a.txt","1,024 Bytes",1
+"C:\Users\u\b.bin","2 MB",1
+`, []importEntry{{"C:/Users/u/a.txt", 1024}, {"C:/Users/u/b.bin", 2 * OneMb}}},
+		{"windirstat", readSizeCSV, "Name,Size,Items\n/data/x,5,1\n/data,5,1\n", []importEntry{{"/data/x", 5}}},
+		{"csv without a header", readSizeCSV, "a,1\nb,2\n", nil},
+	} {
+		entries, err := c.read(strings.NewReader(c.listing))
+		if c.entries == nil {
+			if err == nil {
+				t.Errorf("%v: expected an error, got %v", c.name, entries)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].path < entries[j].path
+		})
+		if !reflect.DeepEqual(entries, c.entries) {
+			t.Errorf("%v: got %v, expected %v", c.name, entries, c.entries)
+		}
+	}
+}
+
+func TestPatternListMatches(t *testing.T) {
+	for _, c := range []struct {
+		patterns string
+		rel      string
+		matches  bool
+	}{
+		{"*.iso", "a/b/disk.iso", true},
+		{"*.iso", "a/disk.iso.part", false},
+		{"node_modules", "web/node_modules/x.js", true},
+		{"node_modules", "web/node_modules2/x.js", false},
+		{"a/*.txt", "a/b.txt", true},
+		{"a/*.txt", "c/a/b.txt", false},
+		{"*.tmp,*.bak", "x.bak", true},
+		{"*.tmp,*.bak", "x.txt", false},
+		{"re:^Library/Caches/", "Library/Caches/x", true},
+		{"re:^Library/Caches/", "x/Library/Caches/y", false},
+		{`re:\.(jpe?g|png)$`, "p/a.JPG", false},
+		{`re:(?i)\.(jpe?g|png)$`, "p/a.JPG", true},
+	} {
+		p := patternList{}
+		if err := p.Set(c.patterns); err != nil {
+			t.Fatal(err)
+		}
+		if got := p.matches(c.rel); got != c.matches {
+			t.Errorf("%v matching %v: got %v, expected %v", c.patterns, c.rel, got, c.matches)
+		}
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	set := func(base string, parent *ignoreSet, lines ...string) *ignoreSet {
+		rules := []ignoreRule{}
+		for _, line := range lines {
+			if rule, ok := parseIgnoreLine(line); ok {
+				rules = append(rules, rule)
+			}
+		}
+		return &ignoreSet{base, rules, parent}
+	}
+	top := set("/r", nil, "*.log", "/build", "tmp/", "docs/**/*.md", `\#notes`, "# a comment", "", "!keep.log")
+	nested := set("/r/sub", top, "!*.log")
+	for _, c := range []struct {
+		set      *ignoreSet
+		filename string
+		isDir    bool
+		ignored  bool
+	}{
+		{top, "/r/a.log", false, true},
+		{top, "/r/x/b.log", false, true},
+		{top, "/r/a.log.txt", false, false},
+		{top, "/r/keep.log", false, false},
+		{top, "/r/build", true, true},
+		{top, "/r/x/build", true, false},
+		{top, "/r/tmp", true, true},
+		{top, "/r/tmp", false, false},
+		{top, "/r/docs/c.md", false, true},
+		{top, "/r/docs/a/b/c.md", false, true},
+		{top, "/r/other/c.md", false, false},
+		{top, "/r/#notes", false, true},
+		{top, "/r/# a comment", false, false},
+		{nested, "/r/sub/x.log", false, false},
+		{nested, "/r/sub/deeper/y.log", false, false},
+		{nested, "/r/sub/tmp", true, true},
+		{nil, "/r/a.log", false, false},
+	} {
+		if got := c.set.ignored(c.filename, c.isDir); got != c.ignored {
+			t.Errorf("%v (directory %v): got ignored %v, expected %v", c.filename, c.isDir, got, c.ignored)
+		}
+	}
+}
+
+func TestWalkerIncludeExcludeIgnore(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":         "build/\n*.png\n",
+		"notes.txt":          "",
+		"build/out.jpg":      "",
+		"photos/a.jpg":       "",
+		"photos/b.png":       "",
+		"photos/cache/c.jpg": "",
+	} {
+		filename := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		include, exclude string
+		ignoreFiles      bool
+		files            []string
+	}{
+		{"", "", false, []string{".gitignore", "build/out.jpg", "notes.txt", "photos/a.jpg", "photos/b.png", "photos/cache/c.jpg"}},
+		{"*.jpg", "", false, []string{"build/out.jpg", "photos/a.jpg", "photos/cache/c.jpg"}},
+		{"*.jpg", "cache", false, []string{"build/out.jpg", "photos/a.jpg"}},
+		{"", "", true, []string{".gitignore", "notes.txt", "photos/a.jpg", "photos/cache/c.jpg"}},
+		{"*.jpg", "", true, []string{"photos/a.jpg", "photos/cache/c.jpg"}},
+		{"re:^photos/", "*.png", false, []string{"photos/a.jpg", "photos/cache/c.jpg"}},
+	} {
+		include, exclude := patternList{}, patternList{}
+		if err := include.Set(c.include); err != nil {
+			t.Fatal(err)
+		}
+		if err := exclude.Set(c.exclude); err != nil {
+			t.Fatal(err)
+		}
+		w := &walker{ctx: context.Background(), root: root, include: include, exclude: exclude, ignoreFiles: c.ignoreFiles}
+		rootFiles, dirs := w.walkRoot(root)
+		found := []string{}
+		for _, f := range rootFiles {
+			found = append(found, w.rel(f.path))
+		}
+		for _, files := range dirs {
+			for _, f := range files {
+				found = append(found, w.rel(f.path))
+			}
+		}
+		sort.Strings(found)
+		if !reflect.DeepEqual(found, c.files) {
+			t.Errorf("-include %q -exclude %q -ignore-files %v: got %v, expected %v", c.include, c.exclude, c.ignoreFiles, found, c.files)
+		}
+	}
+}
+
+func TestHardLinksCountedOnce(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "file"), make([]byte, 2*OneMb), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "other"), make([]byte, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"link", "sub/link"} {
+		if err := os.Link(filepath.Join(root, "file"), filepath.Join(root, link)); err != nil {
+			t.Skip("hard links aren't supported here:", err)
+		}
+	}
+	rules := ruleSets[defaultRules]
+	for _, c := range []struct {
+		name  string
+		links *linkSet
+		files int64
+	}{
+		{"every link", nil, 4},
+		{"once", newLinkSet(), 2},
+		{"once in low memory", newLinkFilter(), 2},
+	} {
+		r := scan(context.Background(), root, scanOptions{rules: rules, links: c.links})
+		if r.Files != c.files {
+			t.Errorf("%v: got %v files, expected %v", c.name, r.Files, c.files)
+		}
+		warned := false
+		for _, warning := range r.Warnings {
+			warned = warned || warning.Code == "hard_links"
+		}
+		if warned != (c.links != nil) {
+			t.Errorf("%v: got a hard_links warning %v", c.name, warned)
+		}
+	}
+	// roots sharing a link set count a file linked from both once
+	links := newLinkSet()
+	sub := scan(context.Background(), filepath.Join(root, "sub"), scanOptions{rules: rules, links: links})
+	all := scan(context.Background(), root, scanOptions{rules: rules, links: links})
+	if sub.Files != 1 || all.Files != 1 {
+		t.Errorf("got %v files in sub and %v in the rest, expected 1 and 1", sub.Files, all.Files)
+	}
+}
+
+func TestDedupeTotals(t *testing.T) {
+	rules, err := customRules(ruleSets[defaultRules], "4K", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	random := func(n int64) []byte {
+		data := make([]byte, n)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	chunk := rules.ChunkSize
+	a := random(10*chunk - 1)
+	for _, c := range []struct {
+		name                                                 string
+		files                                                [][]byte
+		plainChunks, plainUnique, encryptedChunks, encUnique int64
+	}{
+		{"distinct", [][]byte{a, random(5 * chunk)}, 15, 15, 15, 15},
+		{"copies", [][]byte{a, a}, 20, 10, 20, 10},
+		// the changed last chunk, and the first two whose keys come from it
+		{"appended", [][]byte{a, append(append([]byte{}, a...), 'x')}, 20, 11, 20, 13},
+		{"repeated chunks", [][]byte{bytes.Repeat(random(chunk), 3)}, 3, 1, 3, 1},
+		{"small copies", [][]byte{[]byte("hi"), []byte("hi")}, 2, 1, 2, 1},
+	} {
+		m, err := newDedupeModel(rules, "sha3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := t.TempDir()
+		for i, data := range c.files {
+			filename := filepath.Join(root, fmt.Sprint(i))
+			if err := os.WriteFile(filename, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.addFile(file{path: filename, info: info})
+		}
+		got := []int64{m.plain.chunks, m.plain.uniqueChunks, m.encrypted.chunks, m.encrypted.uniqueChunks}
+		expected := []int64{c.plainChunks, c.plainUnique, c.encryptedChunks, c.encUnique}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("%v: got chunks and unique chunks %v, expected %v", c.name, got, expected)
+		}
+		if m.unread != 0 || m.changed != 0 {
+			t.Errorf("%v: got %v unread and %v changed files", c.name, m.unread, m.changed)
+		}
+	}
+	// files that are gone or have grown since they were listed aren't counted
+	m, err := newDedupeModel(rules, "fnv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "f")
+	if err := os.WriteFile(filename, a, 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, append(a, 'x'), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.addFile(file{path: filename, info: info})
+	m.addFile(file{path: filename + ".gone", info: info})
+	if m.plain.chunks != 0 || m.unread != 1 || m.changed != 1 {
+		t.Errorf("got %v chunks, %v unread and %v changed files, expected 0, 1 and 1", m.plain.chunks, m.unread, m.changed)
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	for _, c := range []struct {
+		paths      []string
+		folded     []string
+		collisions int
+	}{
+		{[]string{"a/b", "c/d"}, []string{"a/b", "c/d"}, 0},
+		{[]string{"docs/b", "Docs/a"}, []string{"Docs/a", "Docs/b"}, 1},
+		{[]string{"a/file.txt", "a/File.txt"}, []string{"a/File.txt"}, 1},
+		{[]string{"a/2", "A/1", "a/3"}, []string{"A/1", "A/2", "A/3"}, 1},
+		{[]string{"x/Y/z", "X/y/z"}, []string{"X/y/z"}, 3},
+	} {
+		entries := []importEntry{}
+		for _, p := range c.paths {
+			entries = append(entries, importEntry{p, 1})
+		}
+		folded, collisions := foldCase(entries)
+		paths := []string{}
+		for _, e := range folded {
+			paths = append(paths, e.path)
+		}
+		sort.Strings(paths)
+		if !reflect.DeepEqual(paths, c.folded) || len(collisions) != c.collisions {
+			t.Errorf("%v: got %v with %v collisions, expected %v with %v", c.paths, paths, len(collisions), c.folded, c.collisions)
+		}
+	}
+}
+
+func TestSymlinkLoops(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a", "f"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"a/up": "..", "b": "a", "broken": "missing"} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skip("symbolic links aren't supported here:", err)
+		}
+	}
+	for _, c := range []struct {
+		symlinks string
+		files    int64
+		warnings []string
+	}{
+		// the links themselves are counted as files
+		{symlinksCount, 4, []string{}},
+		{symlinksFollow, 1, []string{"broken_symlink", "symlink_loop", "symlink_loop"}},
+		{symlinksSkip, 1, []string{}},
+	} {
+		done := make(chan *Result, 1)
+		go func() {
+			done <- scan(context.Background(), root, scanOptions{rules: ruleSets[defaultRules], symlinks: c.symlinks})
+		}()
+		var r *Result
+		select {
+		case r = <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("symlinks %q: the scan didn't finish", c.symlinks)
+		}
+		codes := []string{}
+		for _, warning := range r.Warnings {
+			codes = append(codes, warning.Code)
+		}
+		sort.Strings(codes)
+		if r.Files != c.files || !reflect.DeepEqual(codes, c.warnings) {
+			t.Errorf("symlinks %q: got %v files and warnings %v, expected %v and %v", c.symlinks, r.Files, codes, c.files, c.warnings)
+		}
+	}
+}
+
+func TestCDCChunker(t *testing.T) {
+	for _, c := range []struct {
+		min, avg, max int64
+		valid         bool
+	}{
+		{2 * OneKb, 8 * OneKb, 32 * OneKb, true},
+		{0, 8 * OneKb, 32 * OneKb, false},
+		{8 * OneKb, 8 * OneKb, 32 * OneKb, false},
+		{2 * OneKb, 8 * OneKb, 4 * OneKb, false},
+		{1, 2, 8, false},
+	} {
+		if _, err := newCDCChunker(c.min, c.avg, c.max); (err == nil) != c.valid {
+			t.Errorf("%v, %v, %v: got error %v", c.min, c.avg, c.max, err)
+		}
+	}
+	chunker, err := newCDCChunker(2*OneKb, 8*OneKb, 32*OneKb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	split := func(data []byte) map[[32]byte]bool {
+		sums := map[[32]byte]bool{}
+		sizes := []int64{}
+		if err := chunker.split(bytes.NewReader(data), func(sum [32]byte, size int64) {
+			sums[sum] = true
+			sizes = append(sizes, size)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		var total int64
+		for i, size := range sizes {
+			total = total + size
+			if size > chunker.max || size <= 0 || (size < chunker.min && i < len(sizes)-1) {
+				t.Errorf("chunk %v of %v bytes is out of range", i, size)
+			}
+		}
+		if total != int64(len(data)) {
+			t.Errorf("got chunks of %v bytes for %v bytes", total, len(data))
+		}
+		return sums
+	}
+	data := make([]byte, OneMb)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, input := range [][]byte{data, make([]byte, OneMb), data[:100], nil} {
+		split(input)
+	}
+	// an insert only changes the chunks around it
+	inserted := append(append(append([]byte{}, data[:OneMb/2]...), "inserted"...), data[OneMb/2:]...)
+	before, after := split(data), split(inserted)
+	changed := 0
+	for sum := range after {
+		if !before[sum] {
+			changed = changed + 1
+		}
+	}
+	if changed > 2 {
+		t.Errorf("an insert changed %v of %v chunks", changed, len(after))
+	}
+}
+
+func TestPackFits(t *testing.T) {
+	for _, c := range []struct {
+		budget  int64
+		byBytes bool
+		fits    []string
+	}{
+		{1000, false, []string{"photos", "code", "docs"}},
+		{500, false, []string{"photos", "docs"}},
+		{50, false, []string{}},
+		{105 * OneMb, true, []string{"photos", "docs"}},
+		{0, true, []string{}},
+	} {
+		entries := []exclusion{{"photos", 100, 100 * OneMb}, {"code", 800, 10 * OneMb}, {"docs", 100, OneMb}}
+		fits, rest := packFits(entries, c.budget, c.byBytes)
+		names := []string{}
+		for _, e := range fits {
+			names = append(names, e.name)
+		}
+		if !reflect.DeepEqual(names, c.fits) || len(fits)+len(rest) != 3 {
+			t.Errorf("budget %v by bytes %v: got %v fitting and %v not, expected %v to fit", c.budget, c.byBytes, names, len(rest), c.fits)
+		}
+	}
+}
+
+func TestPlanOptimize(t *testing.T) {
+	r := NewResult()
+	r.TotalChunks = 1000
+	r.Dirs = map[string]DirTotal{
+		"photos": {100, 100 * OneMb}, // a few large files, which a bundle can't improve
+		"code":   {800, 10 * OneMb},  // many small files
+		"docs":   {100, OneMb},
+	}
+	for _, c := range []struct {
+		target int64
+		steps  []string
+	}{
+		{2000, []string{}},
+		{300, []string{"bundle code"}},
+		{150, []string{"bundle code", "bundle docs"}},
+		{20, []string{"exclude docs", "exclude code", "exclude photos"}},
+	} {
+		steps := []string{}
+		for _, s := range planOptimize(r, c.target) {
+			steps = append(steps, s.action+" "+s.entry.name)
+		}
+		if !reflect.DeepEqual(steps, c.steps) {
+			t.Errorf("target %v: got %v, expected %v", c.target, steps, c.steps)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		size int64 // or -1 for an error
+	}{
+		{"100", 100},
+		{"512K", 512 * OneKb},
+		{"4M", 4 * OneMb},
+		{"4mb", 4 * OneMb},
+		{"4MiB", 4 * OneMb},
+		{"1G", OneGb},
+		{"1.5T", 3 * OneGb * OneKb / 2},
+		{"-1", -1},
+		{"lots", -1},
+	} {
+		size, err := parseSize(c.s)
+		if c.size < 0 && err == nil || c.size >= 0 && (err != nil || size != c.size) {
+			t.Errorf("%q: got %v, %v, expected %v", c.s, size, err, c.size)
+		}
+	}
+}
+
+func TestParseDNS(t *testing.T) {
+	// a record named by a pointer to the name of the question before it
+	compressed := make([]byte, 12)
+	binary.BigEndian.PutUint16(compressed[4:], 1)
+	binary.BigEndian.PutUint16(compressed[6:], 1)
+	compressed = appendName(compressed, mdnsService)
+	compressed = binary.BigEndian.AppendUint16(compressed, dnsTypePTR)
+	compressed = binary.BigEndian.AppendUint16(compressed, dnsClassIN)
+	compressed = append(compressed, 0xC0, 12)
+	compressed = binary.BigEndian.AppendUint16(compressed, dnsTypeSRV)
+	compressed = binary.BigEndian.AppendUint16(compressed, dnsClassIN)
+	compressed = binary.BigEndian.AppendUint32(compressed, dnsTTL)
+	compressed = binary.BigEndian.AppendUint16(compressed, 2)
+	compressed = append(compressed, 1, 2)
+	// a name pointing to itself
+	loop := make([]byte, 12)
+	binary.BigEndian.PutUint16(loop[4:], 1)
+	loop = append(loop, 0xC0, 12, 0, 12, 0, 1)
+	response := mdnsResponse(9, "host", 8485, true)
+	instance := "host." + mdnsService
+	for _, c := range []struct {
+		name      string
+		msg       []byte
+		id        uint16
+		questions []string
+		records   []string // name and type of each record
+		valid     bool
+	}{
+		{"query", mdnsQuery(7), 7, []string{mdnsService}, []string{}, true},
+		{"response", response, 9, []string{}, []string{mdnsService + " 12", instance + " 33", instance + " 16"}, true},
+		{"compressed", compressed, 0, []string{mdnsService}, []string{mdnsService + " 33"}, true},
+		{"short", []byte{1, 2, 3}, 0, nil, nil, false},
+		{"loop", loop, 0, nil, nil, false},
+		{"truncated record", response[:len(response)-3], 0, nil, nil, false},
+		{"truncated name", mdnsQuery(7)[:20], 0, nil, nil, false},
+	} {
+		id, questions, records, err := parseDNS(c.msg)
+		if !c.valid {
+			if err == nil {
+				t.Errorf("%v: expected an error", c.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", c.name, err)
+			continue
+		}
+		got := []string{}
+		for _, r := range records {
+			got = append(got, fmt.Sprint(r.name, " ", r.rtype))
+		}
+		if id != c.id || !reflect.DeepEqual(questions, c.questions) || !reflect.DeepEqual(got, c.records) {
+			t.Errorf("%v: got id %v, questions %v and records %v, expected %v, %v and %v", c.name, id, questions, got, c.id, c.questions, c.records)
+		}
+	}
+	// the response advertises the port and tls
+	_, _, records, _ := parseDNS(response)
+	if port := binary.BigEndian.Uint16(records[1].data[4:]); port != 8485 {
+		t.Errorf("got port %v, expected 8485", port)
+	}
+	if string(records[2].data) != "\x05tls=1" {
+		t.Errorf("got txt %q, expected tls=1", records[2].data)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sums := []byte("0123abcd  chunk_distribution_linux_amd64\n")
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(private, sums))
+	defer func(key string) {
+		releasePublicKey = key
+	}(releasePublicKey)
+	for _, c := range []struct {
+		name  string
+		key   []byte
+		sums  []byte
+		sig   string
+		valid bool
+	}{
+		{"valid", public, sums, sig, true},
+		{"valid with a newline", public, sums, sig + "\n", true},
+		{"other sums", public, []byte("tampered"), sig, false},
+		{"signature not base64", public, sums, "not base64!", false},
+		{"other key", other, sums, sig, false},
+		{"no key", nil, sums, sig, false},
+		{"short key", []byte("short"), sums, sig, false},
+	} {
+		releasePublicKey = base64.StdEncoding.EncodeToString(c.key)
+		if err := verifySignature(c.sums, []byte(c.sig)); (err == nil) != c.valid {
+			t.Errorf("%v: got error %v", c.name, err)
+		}
+	}
+}
+
+func TestChecksumFor(t *testing.T) {
+	sums := []byte("ABCDEF  chunk_distribution_linux_amd64\n0123 *chunk_distribution_windows_amd64.exe\n")
+	for _, c := range []struct {
+		name, sum string // or no sum for an error
+	}{
+		{"chunk_distribution_linux_amd64", "abcdef"},
+		{"chunk_distribution_windows_amd64.exe", "0123"},
+		{"chunk_distribution_darwin_arm64", ""},
+		{"chunk_distribution", ""},
+	} {
+		sum, err := checksumFor(sums, c.name)
+		if sum != c.sum || (err == nil) != (c.sum != "") {
+			t.Errorf("%v: got %q, %v, expected %q", c.name, sum, err, c.sum)
+		}
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	for _, c := range []struct {
+		a, b  string
+		newer bool
+	}{
+		{"v0.2.0", "v0.1.0", true},
+		{"v0.1.0", "v0.1.0", false},
+		{"v0.1.10", "v0.1.9", true},
+		{"v1.0", "v0.9.9", true},
+		{"v0.1", "v0.1.1", false},
+		{"0.2.0", "v0.1.0", true},
+		{"v0.1.0", "v0.2.0", false},
+	} {
+		if got := newerVersion(c.a, c.b); got != c.newer {
+			t.Errorf("%v newer than %v: got %v", c.a, c.b, got)
+		}
+	}
+}
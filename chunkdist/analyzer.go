@@ -0,0 +1,72 @@
+package chunkdist
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+)
+
+// Analyzer totals the chunks for files, either added one at a time by size or
+// found by walking a directory. It is not safe for concurrent use.
+type Analyzer struct {
+	Totals
+	// Transform, if set, changes the size of each file found by WalkDir
+	// before it is chunked.
+	Transform SizeTransformer
+	// Progress, if set, counts each file found by WalkDir, for another
+	// goroutine to show while the walk runs.
+	Progress *Progress
+}
+
+// NewAnalyzer returns an Analyzer with nothing added, using the rules.
+func NewAnalyzer(rules Rules) *Analyzer {
+	return &Analyzer{Totals: *NewTotals(rules)}
+}
+
+// WalkDir adds every regular file in a directory and its subdirectories.
+// Subdirectories and files that can't be read are skipped, and an error is
+// only returned if the directory itself can't be read.
+//
+// It is a plain walk, much simpler than the chunk_distribution tool's: it
+// doesn't follow symlinks, cross or skip mount points, honour excludes or
+// ignore files, count hard links once, or time out on slow directories, and
+// skipped entries aren't reported. Programs needing any of that can walk the
+// files themselves and call AddFile for each.
+func (a *Analyzer) WalkDir(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size := info.Size()
+		if a.Transform != nil {
+			size = a.Transform.TransformSize(path, size)
+		}
+		a.AddFile(size)
+		a.Progress.Add(path, size, a.Rules.ChunksForSize(size).Count+1) // + 1 for datamap
+		return nil
+	})
+}
+
+// Report writes the totals and the histogram of chunk sizes.
+func (a *Analyzer) Report(w io.Writer) {
+	fmt.Fprintln(w, "Rules:", a.Rules.Name)
+	fmt.Fprintln(w, "Total files:", a.Files)
+	fmt.Fprintf(w, "Files larger than %v: %v (%f GB)\n", a.Rules.ChunkSizeLabel(), a.LargeFiles, float64(a.LargeBytes)/float64(OneGb))
+	fmt.Fprintf(w, "Files smaller than %v: %v (%f GB)\n", a.Rules.ChunkSizeLabel(), a.SmallFiles, float64(a.SmallBytes)/float64(OneGb))
+	fmt.Fprintln(w, "Total chunks:", a.TotalChunks)
+	fmt.Fprintln(w, "Large chunks:", a.LargeChunks)
+	fmt.Fprintln(w, "Small chunks:", a.SmallChunks)
+	fmt.Fprintln(w, "\nChunk Size  Count")
+	WriteHistogram(w, a.Histogram)
+}
@@ -0,0 +1,109 @@
+package chunkdist
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Merger is a total that a Builder can build, with files added to it and
+// other totals of the same kind merged into it. *Totals is one, and so is
+// any type embedding Totals with a Merge method of its own.
+type Merger[T any] interface {
+	AddFile(size int64)
+	Merge(other T)
+}
+
+// Builder accumulates totals from many goroutines at once. Totals themselves
+// are not safe for concurrent use. Files are spread over shards, each with
+// its own lock, so goroutines rarely wait on each other, and the shards are
+// merged when the totals are needed. A long running goroutine can instead
+// take totals of its own from Worker, which need no locks at all.
+type Builder[T Merger[T]] struct {
+	newTotal func() T
+	next     uint64
+	shards   []builderShard[T]
+	mu       sync.Mutex // guards workers
+	workers  []T
+}
+
+type builderShard[T any] struct {
+	mu    sync.Mutex
+	total T
+	// keeps shards on separate cache lines
+	_ [64]byte
+}
+
+// NewBuilder returns an empty Builder of Totals using the rules, with a
+// shard for each processor.
+func NewBuilder(rules Rules) *Builder[*Totals] {
+	return NewBuilderOf(func() *Totals {
+		return NewTotals(rules)
+	})
+}
+
+// NewBuilderOf returns an empty Builder of the totals made by newTotal, for
+// programs keeping more than Totals for each file, with a shard for each
+// processor.
+func NewBuilderOf[T Merger[T]](newTotal func() T) *Builder[T] {
+	b := &Builder[T]{
+		newTotal: newTotal,
+		shards:   make([]builderShard[T], runtime.GOMAXPROCS(0)),
+	}
+	for i := range b.shards {
+		b.shards[i].total = newTotal()
+	}
+	return b
+}
+
+// returns the next shard to use, locked
+func (b *Builder[T]) shard() *builderShard[T] {
+	n := atomic.AddUint64(&b.next, 1)
+	s := &b.shards[n%uint64(len(b.shards))]
+	s.mu.Lock()
+	return s
+}
+
+// AddFile adds the chunks for a file of the given size. It is safe to call
+// from many goroutines at once.
+func (b *Builder[T]) AddFile(size int64) {
+	s := b.shard()
+	defer s.mu.Unlock()
+	s.total.AddFile(size)
+}
+
+// Merge adds other totals, such as ones built by a single goroutine. It is
+// safe to call from many goroutines at once.
+func (b *Builder[T]) Merge(other T) {
+	s := b.shard()
+	defer s.mu.Unlock()
+	s.total.Merge(other)
+}
+
+// Worker returns empty totals for a single goroutine to add files to without
+// locking, which are merged into the Builder's totals. The Builder's totals
+// must not be taken until the goroutine has finished with them.
+func (b *Builder[T]) Worker() T {
+	total := b.newTotal()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.workers = append(b.workers, total)
+	return total
+}
+
+// Totals returns the merged totals of everything added so far.
+func (b *Builder[T]) Totals() T {
+	total := b.newTotal()
+	for i := range b.shards {
+		s := &b.shards[i]
+		s.mu.Lock()
+		total.Merge(s.total)
+		s.mu.Unlock()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, worker := range b.workers {
+		total.Merge(worker)
+	}
+	return total
+}
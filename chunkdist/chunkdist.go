@@ -0,0 +1,180 @@
+// Package chunkdist predicts how files are split into chunks when uploaded
+// to the SAFE network, and totals the chunks for many files as a histogram of
+// chunk sizes. Files larger than the chunk size are split into chunks of that
+// size, smaller files into the minimum number of chunks, and the smallest
+// files are stored in their datamap.
+package chunkdist
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const OneKb = 1024
+const OneMb = 1024 * 1024
+const OneGb = 1024 * 1024 * 1024
+
+// DatamapSize is the typical size in bytes of a datamap
+const DatamapSize = 500
+
+// MinFileSize is the size in bytes below which files were stored in the
+// datamap instead of being chunked, before MaidSafe's Fleming release
+const MinFileSize = 3 * OneKb
+
+// histogramBuckets is the number of buckets below a chunk, the last bucket
+// holding chunks of ten times the bucket width and more.
+const histogramBuckets = 10
+
+// NewHistogram returns a histogram with every bucket empty, for 1 MB chunks.
+// Buckets are keyed by the smallest chunk size in KB they hold.
+func NewHistogram() map[int64]int64 {
+	return NewHistogramFor(DefaultBucketWidth)
+}
+
+// NewHistogramFor returns a histogram with every bucket empty, for buckets of
+// the given width in KB, as given by Rules.BucketWidth.
+func NewHistogramFor(width int64) map[int64]int64 {
+	h := map[int64]int64{}
+	for key := int64(0); key <= histogramBuckets*width; key = key + width {
+		h[key] = 0
+	}
+	return h
+}
+
+// HistogramWidth returns the width in KB of the buckets of a histogram, the
+// smallest key above 0, or the default width for an empty histogram.
+func HistogramWidth(h map[int64]int64) int64 {
+	width := int64(0)
+	for key := range h {
+		if key > 0 && (width == 0 || key < width) {
+			width = key
+		}
+	}
+	if width == 0 {
+		return DefaultBucketWidth
+	}
+	return width
+}
+
+// Chunks describes how a single file is split into chunks.
+type Chunks struct {
+	Count       int64 // number of chunks, not including the datamap
+	Size        int64 // size in bytes of every chunk except the last
+	LastSize    int64 // size in bytes of the last chunk
+	DatamapSize int64 // size in bytes of the datamap
+	// size in bytes of the chunk before the last when it differs from Size,
+	// which only exact rules produce
+	PenultimateSize int64
+}
+
+// Bytes returns the total size of the chunks, not including the datamap.
+func (c Chunks) Bytes() int64 {
+	if c.Count == 0 {
+		return 0
+	}
+	if c.PenultimateSize != 0 {
+		return (c.Count-2)*c.Size + c.PenultimateSize + c.LastSize
+	}
+	return (c.Count-1)*c.Size + c.LastSize
+}
+
+// ChunksForSize returns the chunks a file of the given size is split into
+// using the default rules.
+func ChunksForSize(size int64) Chunks {
+	return RuleSets[DefaultRules].ChunksForSize(size)
+}
+
+// HistogramKey returns the histogram bucket for a chunk of the given size in
+// KB, for 1 MB chunks. Sizes outside the range of the histogram go in the
+// first or last bucket.
+func HistogramKey(size int64) int64 {
+	return HistogramKeyFor(size, DefaultBucketWidth)
+}
+
+// HistogramKeyFor returns the histogram bucket for a chunk of the given size
+// in KB, for buckets of the given width in KB.
+func HistogramKeyFor(size, width int64) int64 {
+	if size < 0 {
+		return 0
+	}
+	if size > histogramBuckets*width {
+		return histogramBuckets * width
+	}
+	return (size / width) * width
+}
+
+// SizeLabel returns a size in KB as text, switching to MB once it is at
+// least 1 MB, eg 300 KB or 1.5 MB.
+func SizeLabel(kb int64) string {
+	number, unit := sizeUnits(kb)
+	return number + " " + unit
+}
+
+// returns a size in KB as a number and its unit, KB or MB
+func sizeUnits(kb int64) (string, string) {
+	if kb < OneKb {
+		return strconv.FormatInt(kb, 10), "KB"
+	}
+	mb := math.Round(float64(kb)/OneKb*10) / 10
+	return strconv.FormatFloat(mb, 'f', -1, 64), "MB"
+}
+
+// BucketStart returns the smallest chunk size in a histogram bucket, eg
+// 100+ KB.
+func BucketStart(key int64) string {
+	number, unit := sizeUnits(key)
+	return number + "+ " + unit
+}
+
+// BucketLabel returns the range of chunk sizes in a histogram bucket for 1 MB
+// chunks, eg 100-200 KB.
+func BucketLabel(key int64) string {
+	return BucketLabelFor(key, DefaultBucketWidth)
+}
+
+// BucketLabelFor returns the range of chunk sizes in a histogram bucket with
+// buckets of the given width in KB, eg 800 KB-1.2 MB, with the unit of each
+// end if they differ.
+func BucketLabelFor(key, width int64) string {
+	if key >= histogramBuckets*width {
+		return BucketStart(key)
+	}
+	lower, lowerUnit := sizeUnits(key)
+	upper, upperUnit := sizeUnits(key + width)
+	if lowerUnit == upperUnit {
+		return lower + "-" + upper + " " + upperUnit
+	}
+	return lower + " " + lowerUnit + "-" + upper + " " + upperUnit
+}
+
+// AddToHistogram adds count chunks of the given size in KB to their bucket,
+// using the width of the histogram's buckets. A bucket missing from the
+// histogram is added.
+func AddToHistogram(histogram map[int64]int64, size, count int64) map[int64]int64 {
+	key := HistogramKeyFor(size, HistogramWidth(histogram))
+	// a count that overflows is capped, and the total chunks record it
+	histogram[key], _ = SaturatingAdd(histogram[key], count)
+	return histogram
+}
+
+// WriteHistogram writes the count of chunks in each bucket, smallest first,
+// with the labels right aligned so the counts line up.
+func WriteHistogram(w io.Writer, h map[int64]int64) {
+	sortedKeys := []int{}
+	width := 0
+	bucketWidth := HistogramWidth(h)
+	for key := range h {
+		sortedKeys = append(sortedKeys, int(key))
+		if len(BucketLabelFor(key, bucketWidth)) > width {
+			width = len(BucketLabelFor(key, bucketWidth))
+		}
+	}
+	sort.Ints(sortedKeys)
+	for _, sortedKey := range sortedKeys {
+		key := int64(sortedKey)
+		fmt.Fprintf(w, "%*s %v\n", width, BucketLabelFor(key, bucketWidth), h[key])
+	}
+}
@@ -0,0 +1,407 @@
+package chunkdist
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+var chunkSizeSeeds = []int64{
+	math.MinInt64,
+	-1,
+	0,
+	1,
+	3*OneKb - 1,
+	3 * OneKb,
+	3*OneKb + 1,
+	OneMb - 1,
+	OneMb,
+	OneMb + 1,
+	2 * OneMb,
+	OneGb,
+	math.MaxInt64 - 1,
+	math.MaxInt64,
+}
+
+func FuzzChunksForSize(f *testing.F) {
+	for _, size := range chunkSizeSeeds {
+		f.Add(size)
+	}
+	f.Fuzz(func(t *testing.T, size int64) {
+		for _, rules := range RuleSets {
+			checkChunks(t, rules, size)
+			rules.Exact = true
+			checkChunks(t, rules, size)
+		}
+	})
+}
+
+func checkChunks(t *testing.T, rules Rules, size int64) {
+	c := rules.ChunksForSize(size)
+	if c.Count < 0 {
+		t.Fatalf("%v size %v: negative chunk count %v", rules.Name, size, c.Count)
+	}
+	if c.DatamapSize < 0 {
+		t.Fatalf("%v size %v: negative datamap size %v", rules.Name, size, c.DatamapSize)
+	}
+	if c.Count > 0 {
+		if c.Size <= 0 || c.Size > rules.ChunkSize {
+			t.Fatalf("%v size %v: chunk size %v out of range", rules.Name, size, c.Size)
+		}
+		// self_encryption lets the last of the minimum number of chunks go
+		// over the chunk size by what's left after dividing
+		maxLast := rules.ChunkSize
+		if rules.Exact {
+			maxLast = rules.ChunkSize + rules.MinChunks - 1
+		}
+		if c.LastSize <= 0 || c.LastSize > maxLast {
+			t.Fatalf("%v size %v: last chunk size %v out of range", rules.Name, size, c.LastSize)
+		}
+	}
+	if size <= 0 {
+		return
+	}
+	// content is stored in the datamap when there are no chunks
+	stored := c.Bytes()
+	if c.Count == 0 {
+		stored = c.DatamapSize
+	}
+	if stored < size {
+		t.Fatalf("%v size %v: chunks only hold %v bytes", rules.Name, size, stored)
+	}
+	if rules.Exact && c.Count > 0 && stored != size {
+		t.Fatalf("%v exact size %v: chunks hold %v bytes", rules.Name, size, stored)
+	}
+}
+
+func TestExactChunks(t *testing.T) {
+	rules := RuleSets[DefaultRules]
+	rules.Exact = true
+	cases := []struct {
+		size     int64
+		expected Chunks
+	}{
+		// three equal chunks below three full chunks
+		{2 * OneMb, Chunks{Count: 3, Size: 699050, LastSize: 699052, DatamapSize: DatamapSize}},
+		{3 * OneMb, Chunks{Count: 3, Size: OneMb, LastSize: OneMb, DatamapSize: DatamapSize}},
+		// a small remainder takes bytes from the chunk before it
+		{3*OneMb + 10, Chunks{Count: 4, Size: OneMb, PenultimateSize: OneMb - OneKb, LastSize: OneKb + 10, DatamapSize: DatamapSize}},
+		{3*OneMb + 2*OneKb, Chunks{Count: 4, Size: OneMb, LastSize: 2 * OneKb, DatamapSize: DatamapSize}},
+	}
+	for _, c := range cases {
+		if got := rules.ChunksForSize(c.size); got != c.expected {
+			t.Errorf("size %v: got %+v, expected %+v", c.size, got, c.expected)
+		}
+	}
+}
+
+func FuzzHistogramKey(f *testing.F) {
+	for _, size := range chunkSizeSeeds {
+		f.Add(size)
+		f.Add(size / OneKb)
+	}
+	f.Fuzz(func(t *testing.T, size int64) {
+		histogram := map[int64]int64{}
+		for key := int64(0); key <= 1000; key = key + 100 {
+			histogram[key] = 0
+		}
+		key := HistogramKey(size)
+		if _, exists := histogram[key]; !exists {
+			t.Fatalf("size %v: key %v is not a histogram bucket", size, key)
+		}
+		c := ChunksForSize(size)
+		AddToHistogram(histogram, c.Size/OneKb, c.Count)
+		AddToHistogram(histogram, c.LastSize/OneKb, 1)
+		AddToHistogram(histogram, c.DatamapSize/OneKb, 1)
+		if len(histogram) != 11 {
+			t.Fatalf("size %v: histogram grew to %v buckets", size, len(histogram))
+		}
+	})
+}
+
+func TestAnalyzerWalkDir(t *testing.T) {
+	root := t.TempDir()
+	sizes := []int64{0, 1, 3 * OneKb, OneMb + 1}
+	expected := NewTotals(RuleSets[DefaultRules])
+	for i, size := range sizes {
+		dirname := filepath.Join(root, "dir", string(rune('a'+i)))
+		if err := os.MkdirAll(dirname, 0700); err != nil {
+			t.Fatal(err)
+		}
+		f, err := os.Create(filepath.Join(dirname, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Truncate(size); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		expected.AddFile(size)
+	}
+	a := NewAnalyzer(RuleSets[DefaultRules])
+	a.Progress = NewProgress()
+	if err := a.WalkDir(root); err != nil {
+		t.Fatal(err)
+	}
+	if a.Files != expected.Files || a.TotalChunks != expected.TotalChunks {
+		t.Fatalf("got %v files in %v chunks, expected %v files in %v chunks",
+			a.Files, a.TotalChunks, expected.Files, expected.TotalChunks)
+	}
+	if s := a.Progress.Snapshot(); s.Files != a.Files || s.Chunks != a.TotalChunks {
+		t.Fatalf("progress counted %v files in %v chunks, the analyzer %v files in %v chunks",
+			s.Files, s.Chunks, a.Files, a.TotalChunks)
+	}
+	if err := NewAnalyzer(RuleSets[DefaultRules]).WalkDir(filepath.Join(root, "missing")); err == nil {
+		t.Fatal("expected an error walking a missing directory")
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	cases := []struct {
+		bytes, total int64
+		width        int
+		expected     string
+	}{
+		{0, 100, 10, "[----------] 0%"},
+		{50, 100, 10, "[#####-----] 50%"},
+		{150, 100, 10, "[##########] 100%"},
+		{50, 0, 10, "[----------] 0%"},
+		{-50, 100, 10, "[----------] 0%"},
+		{50, 100, 0, "[] 50%"},
+		{50, 100, -5, "[] 50%"},
+		{150, 100, -5, "[] 100%"},
+	}
+	for _, c := range cases {
+		if bar := (ProgressSnapshot{Bytes: c.bytes}).Bar(c.total, c.width); bar != c.expected {
+			t.Errorf("%v of %v bytes in %v characters gave %q, expected %q", c.bytes, c.total, c.width, bar, c.expected)
+		}
+	}
+}
+
+func TestExtensionRatios(t *testing.T) {
+	r, err := ParseExtensionRatios("wav=0.5, .BMP=0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]int64{
+		"a/song.wav":  500,
+		"a/image.bmp": 100,
+		"a/IMAGE.BMP": 100,
+		"a/notes.txt": 1000,
+		"a/wav":       1000,
+	}
+	for path, expected := range cases {
+		if got := r.TransformSize(path, 1000); got != expected {
+			t.Errorf("%v: got %v, expected %v", path, got, expected)
+		}
+	}
+	for _, invalid := range []string{"wav", "wav=x", "wav=-1"} {
+		if _, err := ParseExtensionRatios(invalid); err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func TestBucketLabel(t *testing.T) {
+	labels := []struct {
+		key, width int64
+		expected   string
+	}{
+		{0, 100, "0-100 KB"},
+		{900, 100, "900-1000 KB"},
+		{1000, 100, "1000+ KB"},
+		{800, 400, "800 KB-1.2 MB"},
+		{1200, 400, "1.2-1.6 MB"},
+		{4000, 400, "3.9+ MB"},
+	}
+	for _, l := range labels {
+		if got := BucketLabelFor(l.key, l.width); got != l.expected {
+			t.Errorf("key %v width %v: got %q, expected %q", l.key, l.width, got, l.expected)
+		}
+	}
+	if got := BucketLabel(900); got != "900-1000 KB" {
+		t.Errorf("got %q for the default buckets, expected 900-1000 KB", got)
+	}
+	sizes := map[int64]string{
+		1023:          "1023 KB",
+		OneKb:         "1 MB",
+		3 * OneKb / 2: "1.5 MB",
+		4 * OneKb:     "4 MB",
+	}
+	for kb, expected := range sizes {
+		if got := SizeLabel(kb); got != expected {
+			t.Errorf("%v KB: got %q, expected %q", kb, got, expected)
+		}
+	}
+}
+
+func TestTotalsHistogramScalesToChunkSize(t *testing.T) {
+	rules := RuleSets[DefaultRules]
+	rules.ChunkSize = 4 * OneMb
+	totals := NewTotals(rules)
+	totals.AddFile(8*OneMb + 3*OneMb/2)
+	if HistogramWidth(totals.Histogram) != 400 {
+		t.Fatalf("got buckets %v KB wide for 4 MB chunks, expected 400", HistogramWidth(totals.Histogram))
+	}
+	if totals.Histogram[4000] != 2 {
+		t.Errorf("got %v full chunks in the last bucket, expected 2", totals.Histogram[4000])
+	}
+	if totals.Histogram[1200] != 1 || BucketLabelFor(1200, 400) != "1.2-1.6 MB" {
+		t.Errorf("expected the 1.5 MB chunk in the 1.2-1.6 MB bucket, got histogram %v", totals.Histogram)
+	}
+	// rules changed after the totals were made move the buckets with them
+	moved := NewTotals(RuleSets[DefaultRules])
+	moved.Rules = rules
+	moved.AddFile(8*OneMb + 3*OneMb/2)
+	if !reflect.DeepEqual(moved.Histogram, totals.Histogram) {
+		t.Errorf("got histogram %v after changing the rules, expected %v", moved.Histogram, totals.Histogram)
+	}
+}
+
+func TestTotalsOverflow(t *testing.T) {
+	totals := NewTotals(RuleSets[DefaultRules])
+	totals.AddFile(math.MaxInt64 / 4)
+	totals.AddFile(math.MaxInt64 / 4)
+	if totals.Overflow {
+		t.Fatal("two files of a quarter of the largest size overflowed")
+	}
+	totals.AddFile(math.MaxInt64)
+	if !totals.Overflow {
+		t.Fatal("expected a file of the largest size to overflow")
+	}
+	if totals.LargeBytes != math.MaxInt64 {
+		t.Fatalf("got %v large bytes, expected them capped at %v", totals.LargeBytes, int64(math.MaxInt64))
+	}
+}
+
+func TestBuilderConcurrent(t *testing.T) {
+	rules := RuleSets[DefaultRules]
+	sizes := []int64{0, 1, 3 * OneKb, OneMb, OneMb + 1, 10 * OneMb}
+	expected := NewTotals(rules)
+	b := NewBuilder(rules)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for _, size := range sizes {
+			expected.AddFile(size)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			partial := NewTotals(rules)
+			for _, size := range sizes {
+				b.AddFile(size)
+				partial.AddFile(size)
+			}
+			b.Merge(partial)
+		}()
+	}
+	wg.Wait()
+	expected.Merge(expected)
+	got := b.Totals()
+	if got.Files != expected.Files || got.TotalChunks != expected.TotalChunks {
+		t.Fatalf("got %v files in %v chunks, expected %v files in %v chunks",
+			got.Files, got.TotalChunks, expected.Files, expected.TotalChunks)
+	}
+	if !reflect.DeepEqual(got.Histogram, expected.Histogram) {
+		t.Fatalf("got histogram %v, expected %v", got.Histogram, expected.Histogram)
+	}
+}
+
+func TestBuilderWorkers(t *testing.T) {
+	rules := RuleSets[DefaultRules]
+	sizes := []int64{0, 1, 3 * OneKb, OneMb, OneMb + 1, 10 * OneMb}
+	expected := NewTotals(rules)
+	b := NewBuilder(rules)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		for _, size := range sizes {
+			expected.AddFile(size)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := b.Worker()
+			for _, size := range sizes {
+				r.AddFile(size)
+			}
+		}()
+	}
+	wg.Wait()
+	got := b.Totals()
+	if got.Files != expected.Files || got.TotalChunks != expected.TotalChunks {
+		t.Fatalf("got %v files in %v chunks, expected %v files in %v chunks",
+			got.Files, got.TotalChunks, expected.Files, expected.TotalChunks)
+	}
+	if !reflect.DeepEqual(got.Histogram, expected.Histogram) {
+		t.Fatalf("got histogram %v, expected %v", got.Histogram, expected.Histogram)
+	}
+}
+
+func TestRegisterRenderer(t *testing.T) {
+	RegisterRenderer("test-chunks", RendererFunc(func(w io.Writer, r Reportable) error {
+		_, err := fmt.Fprint(w, r.ChunkTotals().TotalChunks)
+		return err
+	}))
+	r, err := LookupRenderer("test-chunks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	totals := NewTotals(RuleSets[DefaultRules])
+	totals.AddFile(5 * OneMb)
+	var out bytes.Buffer
+	if err := r.Render(&out, totals); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != fmt.Sprint(totals.TotalChunks) {
+		t.Fatalf("rendered %q, expected %v", out.String(), totals.TotalChunks)
+	}
+	if _, err := LookupRenderer("test-missing"); err == nil {
+		t.Fatal("expected an error for an unregistered format")
+	}
+	names := RendererNames()
+	if len(names) != 1 || names[0] != "test-chunks" {
+		t.Fatalf("got names %v", names)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected registering a name twice to panic")
+			}
+		}()
+		RegisterRenderer("test-chunks", RendererFunc(func(w io.Writer, r Reportable) error { return nil }))
+	}()
+}
+
+// compares adding files through the locked shards with adding them to
+// totals for each worker, run with eg -cpu 1,4,16,32 to see how they scale
+func BenchmarkBuilder(b *testing.B) {
+	rules := RuleSets[DefaultRules]
+	b.Run("shards", func(b *testing.B) {
+		builder := NewBuilder(rules)
+		b.RunParallel(func(pb *testing.PB) {
+			size := int64(0)
+			for pb.Next() {
+				builder.AddFile(size)
+				size = (size + 4*OneKb) % (4 * OneMb)
+			}
+		})
+	})
+	b.Run("workers", func(b *testing.B) {
+		builder := NewBuilder(rules)
+		b.RunParallel(func(pb *testing.PB) {
+			r := builder.Worker()
+			size := int64(0)
+			for pb.Next() {
+				r.AddFile(size)
+				size = (size + 4*OneKb) % (4 * OneMb)
+			}
+		})
+		builder.Totals()
+	})
+}
@@ -0,0 +1,92 @@
+package chunkdist
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Progress counts the files added to a scan while it runs, so a program can
+// show how far along it is. It is safe for concurrent use, so one goroutine
+// can show the progress while others add files.
+type Progress struct {
+	files   atomic.Int64
+	bytes   atomic.Int64
+	chunks  atomic.Int64
+	mu      sync.Mutex // guards dirname
+	dirname string
+	start   time.Time
+}
+
+// ProgressSnapshot is the progress of a scan at one moment.
+type ProgressSnapshot struct {
+	Files   int64   `json:"files"`
+	Bytes   int64   `json:"bytes"`
+	Chunks  int64   `json:"chunks"`
+	Dirname string  `json:"dirname"` // the directory of the last file added
+	Elapsed float64 `json:"elapsed"` // seconds since the scan started
+	// Done is left for the caller to set once the scan has finished, for
+	// programs that pass snapshots on
+	Done bool `json:"done"`
+}
+
+// NewProgress returns a Progress with nothing added, timed from now.
+func NewProgress() *Progress {
+	return &Progress{start: time.Now()}
+}
+
+// Add counts a file of the given size and its chunks. It does nothing on a
+// nil Progress, so scans can call it whether or not progress is wanted.
+func (p *Progress) Add(path string, size, chunks int64) {
+	if p == nil {
+		return
+	}
+	p.files.Add(1)
+	p.bytes.Add(size)
+	p.chunks.Add(chunks)
+	p.mu.Lock()
+	p.dirname = filepath.Dir(path)
+	p.mu.Unlock()
+}
+
+// Snapshot returns the progress so far.
+func (p *Progress) Snapshot() ProgressSnapshot {
+	p.mu.Lock()
+	dirname := p.dirname
+	p.mu.Unlock()
+	return ProgressSnapshot{
+		Files:   p.files.Load(),
+		Bytes:   p.bytes.Load(),
+		Chunks:  p.chunks.Load(),
+		Dirname: dirname,
+		Elapsed: time.Since(p.start).Seconds(),
+	}
+}
+
+// String returns the snapshot as one line, as the chunk_distribution tool
+// shows it.
+func (s ProgressSnapshot) String() string {
+	elapsed := time.Duration(s.Elapsed * float64(time.Second)).Round(time.Second)
+	return fmt.Sprintf("%v files  %.2f GB  %v  %v", s.Files, float64(s.Bytes)/float64(OneGb), elapsed, s.Dirname)
+}
+
+// Bar returns a bar of the given width in characters showing the bytes so
+// far as a share of the expected total, followed by the percentage, such as
+// [#####-----] 50%. Without an expected total the bar is left empty, and a
+// width below 0, such as from a terminal too narrow for the rest of a line,
+// gives just the brackets.
+func (s ProgressSnapshot) Bar(total int64, width int) string {
+	share := 0.0
+	if total > 0 {
+		share = math.Max(math.Min(float64(s.Bytes)/float64(total), 1), 0)
+	}
+	if width < 0 {
+		width = 0
+	}
+	filled := int(share * float64(width))
+	return fmt.Sprintf("[%v%v] %.0f%%", strings.Repeat("#", filled), strings.Repeat("-", width-filled), 100*share)
+}
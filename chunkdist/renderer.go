@@ -0,0 +1,79 @@
+package chunkdist
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Reportable is anything a Renderer can write: Totals themselves, or a
+// program's own result embedding Totals, which a Renderer can get back with a
+// type assertion to render more than the totals.
+type Reportable interface {
+	ChunkTotals() *Totals
+}
+
+// ChunkTotals returns the totals, so *Totals and any type embedding Totals
+// is Reportable.
+func (t *Totals) ChunkTotals() *Totals {
+	return t
+}
+
+// Renderer writes a result in some output format.
+type Renderer interface {
+	Render(w io.Writer, r Reportable) error
+}
+
+// RendererFunc is a function that can be used as a Renderer.
+type RendererFunc func(w io.Writer, r Reportable) error
+
+// Render calls f(w, r).
+func (f RendererFunc) Render(w io.Writer, r Reportable) error {
+	return f(w, r)
+}
+
+var (
+	renderersMu sync.RWMutex
+	renderers   = map[string]Renderer{}
+)
+
+// RegisterRenderer makes an output format available by name, such as for
+// the -format flag of the chunk_distribution tool when called from an init
+// function linked into it. It panics if the name is already registered, so
+// formats can't be replaced by accident.
+func RegisterRenderer(name string, r Renderer) {
+	renderersMu.Lock()
+	defer renderersMu.Unlock()
+	if r == nil {
+		panic("RegisterRenderer: renderer is nil")
+	}
+	if _, exists := renderers[name]; exists {
+		panic("RegisterRenderer: called twice for " + name)
+	}
+	renderers[name] = r
+}
+
+// LookupRenderer returns the renderer registered for a format.
+func LookupRenderer(name string) (Renderer, error) {
+	renderersMu.RLock()
+	r, exists := renderers[name]
+	renderersMu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown format %v, use one of %v", name, strings.Join(RendererNames(), ", "))
+	}
+	return r, nil
+}
+
+// RendererNames returns the names of the registered formats, sorted.
+func RendererNames() []string {
+	renderersMu.RLock()
+	defer renderersMu.RUnlock()
+	names := []string{}
+	for name := range renderers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
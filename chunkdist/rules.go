@@ -0,0 +1,147 @@
+package chunkdist
+
+// DefaultRules names the rules used when none are chosen.
+const DefaultRules = "safe-2018"
+
+// Rules are the chunking rules of one era of the network.
+type Rules struct {
+	Name        string `json:"name"`
+	ChunkSize   int64  `json:"chunk_size"`    // size in bytes of the largest chunk
+	MinChunks   int64  `json:"min_chunks"`    // files are split into at least this many chunks
+	MinFileSize int64  `json:"min_file_size"` // smaller files are stored in the datamap
+	DatamapSize int64  `json:"datamap_size"`  // typical size in bytes of a datamap
+	// Exact follows MaidSafe's self_encryption for where chunks are split,
+	// rather than the simpler model, see ChunksForSize
+	Exact bool `json:"exact,omitempty"`
+}
+
+// RuleSets are the rules of each era of the network, keyed by name.
+var RuleSets = map[string]Rules{
+	// self_encryption as used by the SAFE network alpha releases
+	"safe-2018": {
+		Name:        "safe-2018",
+		ChunkSize:   OneMb,
+		MinChunks:   3,
+		MinFileSize: MinFileSize,
+		DatamapSize: DatamapSize,
+	},
+	// the Fleming testnets encrypt any file of at least 3 bytes, one byte
+	// per chunk
+	"safe-fleming": {
+		Name:        "safe-fleming",
+		ChunkSize:   OneMb,
+		MinChunks:   3,
+		MinFileSize: 3,
+		DatamapSize: DatamapSize,
+	},
+	// Autonomi stores the datamap of every file as its own chunk
+	"autonomi-2024": {
+		Name:        "autonomi-2024",
+		ChunkSize:   OneMb,
+		MinChunks:   3,
+		MinFileSize: 3,
+		DatamapSize: OneKb,
+	},
+}
+
+// DefaultBucketWidth is the width in KB of histogram buckets for 1 MB chunks.
+const DefaultBucketWidth = 100
+
+// BucketWidth returns the width in KB of the histogram buckets for chunks of
+// these rules, a tenth of the chunk size, so the last bucket holds the full
+// chunks whatever their size.
+func (r Rules) BucketWidth() int64 {
+	width := r.ChunkSize * DefaultBucketWidth / OneMb
+	if width < 1 {
+		return 1
+	}
+	return width
+}
+
+// ChunkSizeLabel returns the chunk size as text, eg 1 MB, for the labels of
+// files larger and smaller than a chunk.
+func (r Rules) ChunkSizeLabel() string {
+	return SizeLabel(r.ChunkSize / OneKb)
+}
+
+// ChunksForSize returns the chunks a file of the given size is split into.
+// Negative sizes are treated as empty files.
+func (r Rules) ChunksForSize(size int64) Chunks {
+	if size < 0 {
+		size = 0
+	}
+	// small files are not chunked, the content is stored in the datamap.
+	if size < r.MinFileSize {
+		return Chunks{DatamapSize: size}
+	}
+	if r.Exact {
+		return r.exactChunks(size)
+	}
+	// files up to the chunk size are split into the minimum number of
+	// chunks, each chunk being an equal part of the original file size.
+	if size <= r.ChunkSize {
+		return Chunks{
+			Count:       r.MinChunks,
+			Size:        size / r.MinChunks,
+			LastSize:    size - (r.MinChunks-1)*(size/r.MinChunks),
+			DatamapSize: r.DatamapSize,
+		}
+	}
+	count := size / r.ChunkSize
+	if size%r.ChunkSize != 0 {
+		count = count + 1
+	}
+	return Chunks{
+		Count:       count,
+		Size:        r.ChunkSize,
+		LastSize:    size - (count-1)*r.ChunkSize,
+		DatamapSize: r.DatamapSize,
+	}
+}
+
+// returns the chunks as self_encryption splits them, which differs from the
+// simpler model in two ways. Files smaller than the minimum number of full
+// chunks are split into that many equal chunks, so a 2 MB file is three
+// chunks of 683 KB rather than one of 1 MB and one of 1 MB. And a last chunk
+// smaller than the minimum chunk size takes bytes from the one before it, so
+// that no chunk is smaller than the minimum. The minimum chunk size is the
+// minimum file size shared between the minimum number of chunks.
+func (r Rules) exactChunks(size int64) Chunks {
+	if size < r.MinChunks*r.ChunkSize {
+		// as in self_encryption, the last chunk takes what's left after
+		// dividing, so may be a byte or two over the chunk size
+		return Chunks{
+			Count:       r.MinChunks,
+			Size:        size / r.MinChunks,
+			LastSize:    size - (r.MinChunks-1)*(size/r.MinChunks),
+			DatamapSize: r.DatamapSize,
+		}
+	}
+	count := size / r.ChunkSize
+	remainder := size % r.ChunkSize
+	if remainder == 0 {
+		return Chunks{
+			Count:       count,
+			Size:        r.ChunkSize,
+			LastSize:    r.ChunkSize,
+			DatamapSize: r.DatamapSize,
+		}
+	}
+	count = count + 1
+	minChunkSize := r.MinFileSize / r.MinChunks
+	if remainder < minChunkSize {
+		return Chunks{
+			Count:           count,
+			Size:            r.ChunkSize,
+			PenultimateSize: r.ChunkSize - minChunkSize,
+			LastSize:        minChunkSize + remainder,
+			DatamapSize:     r.DatamapSize,
+		}
+	}
+	return Chunks{
+		Count:       count,
+		Size:        r.ChunkSize,
+		LastSize:    remainder,
+		DatamapSize: r.DatamapSize,
+	}
+}
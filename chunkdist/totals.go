@@ -0,0 +1,121 @@
+package chunkdist
+
+import "math"
+
+// Totals are the chunks for a set of files, and their sizes.
+type Totals struct {
+	Rules        Rules           `json:"rules"` // the chunking rules used
+	Files        int64           `json:"files"`
+	LargeFiles   int64           `json:"large_files"`             // files larger than the chunk size
+	SmallFiles   int64           `json:"small_files"`             // files of the chunk size or less
+	LargeBytes   int64           `json:"large_bytes"`             // total bytes consumed by large files
+	SmallBytes   int64           `json:"small_bytes"`             // total bytes consumed by small files
+	TotalChunks  int64           `json:"total_chunks"`            // how many chunks of any size
+	LargeChunks  int64           `json:"large_chunks"`            // how many chunks of the chunk size
+	SmallChunks  int64           `json:"small_chunks"`            // how many chunks smaller than the chunk size
+	Histogram    map[int64]int64 `json:"histogram"`               // chunk counts keyed by size in KB
+	NetworkBytes int64           `json:"network_bytes,omitempty"` // bytes of chunks and datamaps, for one copy
+	// Overflow is set if a total grew past the largest int64, and was capped
+	// there instead of wrapping around
+	Overflow bool `json:"overflow,omitempty"`
+}
+
+// NewTotals returns empty Totals using the rules.
+func NewTotals(rules Rules) *Totals {
+	return &Totals{
+		Rules:     rules,
+		Histogram: NewHistogramFor(rules.BucketWidth()),
+	}
+}
+
+// AddFile adds the chunks for a file of the given size.
+func (t *Totals) AddFile(size int64) {
+	chunks := t.Rules.ChunksForSize(size)
+	t.add(&t.Files, 1)
+	if size > t.Rules.ChunkSize {
+		t.add(&t.LargeFiles, 1)
+		t.add(&t.LargeBytes, size)
+	} else {
+		t.add(&t.SmallFiles, 1)
+		t.add(&t.SmallBytes, size)
+	}
+	t.add(&t.TotalChunks, chunks.Count+1) // + 1 for datamap
+	t.add(&t.NetworkBytes, chunks.Bytes())
+	t.add(&t.NetworkBytes, chunks.DatamapSize)
+	t.add(&t.SmallChunks, 1) // datamap
+	if chunks.Count > 0 {
+		middle := chunks.Count - 1
+		if chunks.PenultimateSize != 0 {
+			middle = middle - 1
+			t.addChunks(chunks.PenultimateSize, 1)
+		}
+		t.addChunks(chunks.Size, middle)
+		t.addChunks(chunks.LastSize, 1)
+	}
+	t.Histogram = AddToHistogram(t.histogram(), chunks.DatamapSize/OneKb, 1)
+}
+
+// adds n to a total, capping it and setting Overflow if it would overflow
+func (t *Totals) add(total *int64, n int64) {
+	sum, ok := SaturatingAdd(*total, n)
+	*total = sum
+	if !ok {
+		t.Overflow = true
+	}
+}
+
+// adds count chunks of a size in bytes to the large or small chunks and the
+// histogram
+func (t *Totals) addChunks(size, count int64) {
+	if size >= t.Rules.ChunkSize {
+		t.add(&t.LargeChunks, count)
+	} else {
+		t.add(&t.SmallChunks, count)
+	}
+	t.Histogram = AddToHistogram(t.histogram(), size/OneKb, count)
+}
+
+// Merge adds other totals to these ones.
+func (t *Totals) Merge(other *Totals) {
+	t.add(&t.Files, other.Files)
+	t.add(&t.LargeFiles, other.LargeFiles)
+	t.add(&t.SmallFiles, other.SmallFiles)
+	t.add(&t.LargeBytes, other.LargeBytes)
+	t.add(&t.SmallBytes, other.SmallBytes)
+	t.add(&t.TotalChunks, other.TotalChunks)
+	t.add(&t.LargeChunks, other.LargeChunks)
+	t.add(&t.SmallChunks, other.SmallChunks)
+	t.add(&t.NetworkBytes, other.NetworkBytes)
+	t.Overflow = t.Overflow || other.Overflow
+	for key, count := range other.Histogram {
+		t.Histogram = AddToHistogram(t.histogram(), key, count)
+	}
+}
+
+// returns the histogram with buckets for the chunk size of the rules, moving
+// any counts into the new buckets if the rules were changed after the totals
+// were made
+func (t *Totals) histogram() map[int64]int64 {
+	width := t.Rules.BucketWidth()
+	if t.Histogram != nil && HistogramWidth(t.Histogram) == width {
+		return t.Histogram
+	}
+	h := NewHistogramFor(width)
+	for key, count := range t.Histogram {
+		h = AddToHistogram(h, key, count)
+	}
+	t.Histogram = h
+	return h
+}
+
+// SaturatingAdd returns a + b, or the largest or smallest int64 and false if
+// the sum would overflow.
+func SaturatingAdd(a, b int64) (int64, bool) {
+	if b > 0 && a > math.MaxInt64-b {
+		return math.MaxInt64, false
+	}
+	if b < 0 && a < math.MinInt64-b {
+		return math.MinInt64, false
+	}
+	return a + b, true
+}
@@ -0,0 +1,61 @@
+package chunkdist
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SizeTransformer changes the size of a file before it is chunked, to model
+// preparing files for upload, such as transcoding or compressing them.
+type SizeTransformer interface {
+	// TransformSize returns the size the file at path would have once
+	// prepared. It must not be negative.
+	TransformSize(path string, size int64) int64
+}
+
+// SizeTransformerFunc lets a function be used as a SizeTransformer.
+type SizeTransformerFunc func(path string, size int64) int64
+
+// TransformSize calls f.
+func (f SizeTransformerFunc) TransformSize(path string, size int64) int64 {
+	return f(path, size)
+}
+
+// ExtensionRatios scales the size of files by the ratio for their
+// extension, lower case and with the dot, eg ".wav": 0.6 for lossless
+// compression. Files with other extensions keep their size.
+type ExtensionRatios map[string]float64
+
+// TransformSize returns size multiplied by the ratio for the extension of
+// path.
+func (r ExtensionRatios) TransformSize(path string, size int64) int64 {
+	ratio, exists := r[strings.ToLower(filepath.Ext(path))]
+	if !exists {
+		return size
+	}
+	return int64(float64(size) * ratio)
+}
+
+// ParseExtensionRatios reads ratios written as comma separated
+// extension=ratio pairs, eg "wav=0.6,bmp=0.1".
+func ParseExtensionRatios(s string) (ExtensionRatios, error) {
+	r := ExtensionRatios{}
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		extension, value, found := strings.Cut(pair, "=")
+		if !found {
+			return nil, fmt.Errorf("expected extension=ratio, got %q", pair)
+		}
+		ratio, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || ratio < 0 {
+			return nil, fmt.Errorf("invalid ratio for %v: %q", extension, value)
+		}
+		extension = strings.ToLower(strings.TrimSpace(extension))
+		r["."+strings.TrimPrefix(extension, ".")] = ratio
+	}
+	return r, nil
+}
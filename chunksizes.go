@@ -0,0 +1,63 @@
+package main
+
+// Compares several chunk sizes in one scan, chunking every file with each of
+// them, instead of scanning once with each -chunk-size.
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// chunkSizeModel counts the chunks for each of several chunk sizes.
+type chunkSizeModel struct {
+	names   []string  // the chunk sizes as given, eg 256K
+	results []*Result // the totals for each chunk size
+	chosen  *Result   // the totals for the chosen rules, the change is from these
+}
+
+// returns a model for a comma separated list of chunk sizes, each replacing
+// the chunk size of rules
+func newChunkSizeModel(rules Rules, sizes string) (*chunkSizeModel, error) {
+	chosen := NewResult()
+	chosen.Rules = rules
+	m := &chunkSizeModel{chosen: chosen}
+	for _, name := range strings.Split(sizes, ",") {
+		name = strings.TrimSpace(name)
+		sized, err := customRules(rules, name, "", false)
+		if err != nil {
+			return nil, err
+		}
+		r := NewResult()
+		r.Rules = sized
+		m.names = append(m.names, name)
+		m.results = append(m.results, r)
+	}
+	return m, nil
+}
+
+func (m *chunkSizeModel) addFile(size int64) {
+	m.chosen.AddFile(size)
+	for _, r := range m.results {
+		r.AddFile(size)
+	}
+}
+
+// prints the totals for each chunk size side by side, with the change in
+// chunks from the chosen rules
+func (m *chunkSizeModel) report(w io.Writer) {
+	fmt.Fprintln(w, "\nChunk sizes")
+	chosen := m.chosen.TotalChunks
+	fmt.Fprintf(w, "Change is from the chosen rules (%v): %v chunks\n", m.chosen.Rules.Name, chosen)
+	fmt.Fprintln(w, "Chunk size  Chunks  Change  Large chunks  Small chunks  Small files  Network GB")
+	for i, r := range m.results {
+		fmt.Fprintf(w, "%v  %v  %+.1f%%  %v  %v  %v  %f\n",
+			m.names[i],
+			r.TotalChunks,
+			percent(r.TotalChunks-chosen, chosen),
+			r.LargeChunks,
+			r.SmallChunks,
+			r.SmallFiles,
+			float64(r.NetworkBytes)/float64(OneGb))
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// a subcommand, or the scan when the name is empty
+type command struct {
+	name    string // one or two words, eg agent or query fits
+	args    string // the arguments after the flags, for the usage line
+	summary string
+	// declares the command's flags, returning the function that runs the
+	// command once they're parsed, so help, the man page and completion can
+	// list the flags without running it
+	define func(flags *flag.FlagSet) func() error
+}
+
+// the commands in the order they're listed, set in init since declaring a
+// command's flags refers back to this list for its usage
+var commands []command
+
+func init() {
+	commands = []command{
+		{"", "[dir...]", "scan directories and report their chunk distribution", defineScan},
+		{"agent", "[dir]", "scan and send the result to a collector, or serve it", defineAgent},
+		{"backup", "backup_dir", "count the versions in a Time Machine or File History backup once", defineBackup},
+		{"benchmark", "", "compare the directory walkers on a synthetic tree", defineBenchmark},
+		{"collector", "", "combine the results from agents on several machines", defineCollector},
+		{"compare-cdc", "dir...", "compare fixed chunks with content-defined chunking, reading every file", defineCompareCDC},
+		{"compare-overlap", "rootA rootB", "count the chunks two directory trees share", defineCompareOverlap},
+		{"completion", "bash|zsh|fish|powershell", "print a shell completion script", defineCompletion},
+		{"convert", "input output", "convert a saved result between json and csv", defineConvert},
+		{"decrypt", "result.json.enc", "decrypt a saved result", defineDecrypt},
+		{"explain", "file...", "show how a file is split into chunks", defineExplain},
+		{"git-history", "[repo]", "compare the chunks for all of a git repository's history to its latest commit", defineGitHistory},
+		{"import", "listing", "report on a file listing made by another tool", defineImport},
+		{"keygen", "", "create a key pair for encrypting saved results", defineKeygen},
+		{"man", "", "write the man page", defineMan},
+		{"network", "", "compare a saved result to published network statistics", defineNetwork},
+		{"optimize", "", "propose bundles and exclusions to get under a chunk budget", defineOptimize},
+		{"query fits", "", "list the top level directories that fit in a budget", defineQueryFits},
+		{"report proposal", "[dir...]", "write a markdown comparison of two rule sets", defineReportProposal},
+		{"self-update", "", "update to the latest release", defineSelfUpdate},
+		{"vault", "chunk_store_dir", "report the chunks stored by a vault", defineVault},
+	}
+}
+
+// returns the command named by the start of args, and the rest of args
+func findCommand(args []string) (command, []string, bool) {
+	for _, c := range commands {
+		words := strings.Fields(c.name)
+		if len(words) == 0 || len(args) < len(words) {
+			continue
+		}
+		if strings.Join(args[:len(words)], " ") == c.name {
+			return c, args[len(words):], true
+		}
+	}
+	return command{}, args, false
+}
+
+// returns the command with the given name, the scan if the name is empty
+func commandNamed(name string) command {
+	for _, c := range commands {
+		if c.name == name {
+			return c
+		}
+	}
+	return command{}
+}
+
+// returns the usage line for a command
+func (c command) usage() string {
+	parts := []string{"chunk_distribution"}
+	for _, part := range []string{c.name, "[flags]", c.args} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// returns the flag set for a command, which prints the command's usage line
+// before the flags for -h
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(strings.TrimSpace("chunk_distribution "+name), flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), tr("Usage:"), commandNamed(name).usage())
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parses the command's flags from args and runs it
+func (c command) run(args []string) error {
+	flags := newFlagSet(c.name)
+	run := c.define(flags)
+	flags.Parse(args)
+	return run()
+}
+
+// returns the flags of a command, without running it
+func (c command) flags() *flag.FlagSet {
+	flags := newFlagSet(c.name)
+	c.define(flags)
+	return flags
+}
@@ -0,0 +1,181 @@
+package main
+
+// Generates shell completion scripts from the commands and their flags.
+// Flags that take a saved result complete json files, and -compare-baseline
+// also completes the baseline names.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// flags whose value is a saved result file
+var resultFlags = map[string]bool{
+	"result":           true,
+	"compare-baseline": true,
+	"since":            true,
+}
+
+// prints the completion script for a shell
+func defineCompletion(flags *flag.FlagSet) func() error {
+
+	return func() error {
+		if flags.NArg() != 1 {
+			return errors.New("usage: completion bash|zsh|fish|powershell")
+		}
+		switch flags.Arg(0) {
+		case "bash":
+			writeBashCompletion(os.Stdout)
+		case "zsh":
+			// zsh can run bash completions
+			fmt.Println("autoload -U +X bashcompinit && bashcompinit")
+			writeBashCompletion(os.Stdout)
+		case "fish":
+			writeFishCompletion(os.Stdout)
+		case "powershell":
+			writePowerShellCompletion(os.Stdout)
+		default:
+			return fmt.Errorf("unknown shell %v, use bash, zsh, fish or powershell", flags.Arg(0))
+		}
+		return nil
+	}
+}
+
+// returns the names of a command's flags, sorted
+func flagNames(c command) []string {
+	names := []string{}
+	c.flags().VisitAll(func(f *flag.Flag) {
+		names = append(names, "-"+f.Name)
+	})
+	sort.Strings(names)
+	return names
+}
+
+// returns the first word of each command name, without duplicates
+func commandWords() []string {
+	words := []string{}
+	seen := map[string]bool{}
+	for _, c := range commands {
+		if word := strings.SplitN(c.name, " ", 2)[0]; word != "" && !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+func writeBashCompletion(w io.Writer) {
+	results := []string{}
+	for name := range resultFlags {
+		results = append(results, "-"+name)
+	}
+	sort.Strings(results)
+	fmt.Fprintln(w, "_chunk_distribution() {")
+	fmt.Fprintln(w, `	local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" cmd flags`)
+	fmt.Fprintln(w, `	cmd="${COMP_WORDS[1]}"`)
+	fmt.Fprintln(w, `	[ $COMP_CWORD -gt 2 ] && cmd="$cmd ${COMP_WORDS[2]}"`)
+	fmt.Fprintf(w, "\tcase \"$prev\" in\n\t%v)\n", strings.Join(results, "|"))
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -f -X '!*.json' -- "$cur") $(compgen -d -- "$cur"))`)
+	fmt.Fprintf(w, "\t\t[ \"$prev\" = -compare-baseline ] && COMPREPLY+=($(compgen -W %q -- \"$cur\"))\n", strings.Join(baselineNames(), " "))
+	fmt.Fprintln(w, "\t\treturn;;")
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	case "$cmd" in`)
+	for _, c := range commands {
+		if c.name == "" || c.name == "completion" {
+			continue
+		}
+		// cmd is the first two words, so one word commands match any second
+		pattern := fmt.Sprintf(`%v|"%v "*`, c.name, c.name)
+		if strings.Contains(c.name, " ") {
+			pattern = fmt.Sprintf("%q", c.name)
+		}
+		fmt.Fprintf(w, "\t%v) flags=%q;;\n", pattern, strings.Join(flagNames(c), " "))
+	}
+	fmt.Fprintf(w, "\t*) flags=%q;;\n", strings.Join(flagNames(commandNamed("")), " "))
+	fmt.Fprintln(w, "\tesac")
+	fmt.Fprintln(w, `	if [[ "$cur" == -* ]]; then`)
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -W "$flags" -- "$cur"))`)
+	fmt.Fprintln(w, `	elif [ $COMP_CWORD -eq 1 ]; then`)
+	fmt.Fprintf(w, "\t\tCOMPREPLY=($(compgen -W %q -- \"$cur\") $(compgen -d -- \"$cur\"))\n", strings.Join(commandWords(), " "))
+	fmt.Fprintln(w, `	elif [ $COMP_CWORD -eq 2 ] && [ "$cmd" = query ]; then`)
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -W fits -- "$cur"))`)
+	fmt.Fprintln(w, `	elif [ $COMP_CWORD -eq 2 ] && [ "$cmd" = report ]; then`)
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -W proposal -- "$cur"))`)
+	fmt.Fprintln(w, "\telse")
+	fmt.Fprintln(w, `		COMPREPLY=($(compgen -f -- "$cur"))`)
+	fmt.Fprintln(w, "\tfi")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w, "complete -o filenames -F _chunk_distribution chunk_distribution")
+}
+
+func writeFishCompletion(w io.Writer) {
+	fmt.Fprintln(w, "complete -c chunk_distribution -f")
+	fmt.Fprintln(w, "complete -c chunk_distribution -n __fish_use_subcommand -a '(__fish_complete_directories)'")
+	for _, c := range commands {
+		words := strings.Fields(c.name)
+		condition := "__fish_use_subcommand"
+		switch len(words) {
+		case 1:
+			fmt.Fprintf(w, "complete -c chunk_distribution -n __fish_use_subcommand -a %v -d %q\n", words[0], c.summary)
+			condition = "__fish_seen_subcommand_from " + words[0]
+		case 2:
+			fmt.Fprintf(w, "complete -c chunk_distribution -n '__fish_seen_subcommand_from %v' -a %v -d %q\n", words[0], words[1], c.summary)
+			condition = "__fish_seen_subcommand_from " + words[1]
+		}
+		c.flags().VisitAll(func(f *flag.Flag) {
+			value := ""
+			if resultFlags[f.Name] {
+				value = " -r -a '(__fish_complete_suffix .json)'"
+			}
+			fmt.Fprintf(w, "complete -c chunk_distribution -n '%v' -o %v -d %q%v\n", condition, f.Name, f.Usage, value)
+		})
+	}
+}
+
+func writePowerShellCompletion(w io.Writer) {
+	fmt.Fprintln(w, "Register-ArgumentCompleter -Native -CommandName chunk_distribution -ScriptBlock {")
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(w, "    $words = @($commandAst.CommandElements | ForEach-Object { $_.ToString() })")
+	fmt.Fprintln(w, "    if ($wordToComplete -ne '') { $words = $words[0..($words.Count - 2)] }")
+	fmt.Fprintln(w, "    $prev = $words[-1]")
+	fmt.Fprintln(w, "    $cmd = if ($words.Count -gt 1) { $words[1..($words.Count - 1)] -join ' ' } else { '' }")
+	fmt.Fprintln(w, "    $results = @()")
+	results := []string{}
+	for name := range resultFlags {
+		results = append(results, "'-"+name+"'")
+	}
+	sort.Strings(results)
+	fmt.Fprintf(w, "    if (@(%v) -contains $prev) {\n", strings.Join(results, ", "))
+	fmt.Fprintln(w, "        $results = Get-ChildItem -Filter *.json -Name")
+	fmt.Fprintln(w, "    } elseif ($words.Count -eq 1) {")
+	fmt.Fprintf(w, "        $results = @(%v)\n", quoteList(commandWords()))
+	fmt.Fprintln(w, "    } else {")
+	fmt.Fprintln(w, "        $results = switch -Wildcard ($cmd) {")
+	for _, c := range commands {
+		if c.name == "" {
+			continue
+		}
+		fmt.Fprintf(w, "            '%v*' { @(%v); break }\n", c.name, quoteList(flagNames(c)))
+	}
+	fmt.Fprintf(w, "            default { @(%v) }\n", quoteList(flagNames(commandNamed(""))))
+	fmt.Fprintln(w, "        }")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "    $results | Where-Object { $_ -like \"$wordToComplete*\" } | ForEach-Object {")
+	fmt.Fprintln(w, "        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)")
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintln(w, "}")
+}
+
+// returns a powershell list of single quoted strings
+func quoteList(items []string) string {
+	quoted := []string{}
+	for _, item := range items {
+		quoted = append(quoted, "'"+strings.Replace(item, "'", "''", -1)+"'")
+	}
+	return strings.Join(quoted, ", ")
+}
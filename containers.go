@@ -0,0 +1,94 @@
+package main
+
+// Models the effect of encrypting files before they are uploaded, either
+// each file separately (eg with age) or all files inside one encrypted volume
+// (eg VeraCrypt). Both change the sizes being chunked, and both prevent
+// convergent deduplication since the same content no longer produces the same
+// chunks.
+
+import (
+	"fmt"
+	"io"
+)
+
+const (
+	// size of an age header with one X25519 recipient
+	ageHeaderSize = 200
+	// age encrypts in 64 KiB chunks, each with a 16 byte tag, after a 16 byte
+	// nonce
+	ageChunkSize = 64 * OneKb
+	ageTagSize   = 16
+	ageNonceSize = 16
+	// VeraCrypt volumes have a 128 KiB header and a 128 KiB backup header
+	volumeHeaderSize = 256 * OneKb
+	// files inside a volume take whole filesystem clusters
+	volumeClusterSize = 4 * OneKb
+)
+
+// the chunks and network bytes for one approach
+type containerTotal struct {
+	chunks int64
+	bytes  int64
+}
+
+// adds the chunks for a file to the total
+func (t *containerTotal) add(rules Rules, size int64) {
+	chunks := rules.ChunksForSize(size)
+	t.chunks = t.chunks + chunks.Count + 1 // + 1 for datamap
+	t.bytes = t.bytes + chunks.Bytes() + chunks.DatamapSize
+}
+
+// containerModel compares uploading files as they are with encrypting them
+// first.
+type containerModel struct {
+	rules       Rules
+	plain       containerTotal
+	perFile     containerTotal
+	volumeBytes int64
+}
+
+// returns the size of a file after encrypting it with age
+func ageSize(size int64) int64 {
+	if size < 0 {
+		size = 0
+	}
+	chunks := size / ageChunkSize
+	if size%ageChunkSize != 0 || size == 0 {
+		chunks = chunks + 1
+	}
+	return ageHeaderSize + ageNonceSize + size + chunks*ageTagSize
+}
+
+func (m *containerModel) addFile(size int64) {
+	if size < 0 {
+		size = 0
+	}
+	m.plain.add(m.rules, size)
+	m.perFile.add(m.rules, ageSize(size))
+	clusters := (size + volumeClusterSize - 1) / volumeClusterSize
+	m.volumeBytes = m.volumeBytes + clusters*volumeClusterSize
+}
+
+func (m *containerModel) report(w io.Writer) {
+	var volume containerTotal
+	volume.add(m.rules, m.volumeBytes+volumeHeaderSize)
+	fmt.Fprintln(w, "\nEncryption before upload")
+	fmt.Fprintln(w, "Approach  Chunks  Change  Network GB  Deduplication")
+	rows := []struct {
+		name  string
+		total containerTotal
+		dedup string
+	}{
+		{"None", m.plain, "identical files share chunks"},
+		{"Per file (age)", m.perFile, "none"},
+		{"One volume (VeraCrypt)", volume, "none"},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(w, "%v  %v  %+.1f%%  %f  %v\n",
+			row.name,
+			row.total.chunks,
+			percent(row.total.chunks-m.plain.chunks, m.plain.chunks),
+			float64(row.total.bytes)/float64(OneGb),
+			row.dedup)
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+// convert transcodes saved results between formats, reading the output back
+// to check that nothing was lost.
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// the header of a saved result in csv
+var resultCSVHeader = []string{"section", "name", "value", "extra"}
+
+// converts a saved result between formats
+func defineConvert(flags *flag.FlagSet) func() error {
+	from := flags.String("from", "", "format of the input, json or csv, by default from the file extension")
+	to := flags.String("to", "", "format of the output, json or csv, by default from the file extension")
+	return func() error {
+		if flags.NArg() != 2 {
+			return errors.New("usage: convert [-from json|csv] [-to json|csv] input output")
+		}
+		in, out := flags.Arg(0), flags.Arg(1)
+		if *from == "" {
+			*from = strings.TrimPrefix(filepath.Ext(in), ".")
+		}
+		if *to == "" {
+			*to = strings.TrimPrefix(filepath.Ext(out), ".")
+		}
+		data, err := ioutil.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		if isSealed(data) {
+			return errors.New("saved result is encrypted, use decrypt first")
+		}
+		m, err := decodeResult(data, *from)
+		if err != nil {
+			return err
+		}
+		converted, err := encodeResult(m, *to)
+		if err != nil {
+			return err
+		}
+		check, err := decodeResult(converted, *to)
+		if err != nil {
+			return fmt.Errorf("reading back the converted result: %v", err)
+		}
+		if err := compareResults(m, check); err != nil {
+			return fmt.Errorf("converted result differs: %v", err)
+		}
+		if err := ioutil.WriteFile(out, converted, 0600); err != nil {
+			return err
+		}
+		fmt.Println("Converted", in, "to", out)
+		return nil
+	}
+}
+
+// decodes a saved result in the format
+func decodeResult(data []byte, format string) (MachineResult, error) {
+	var m MachineResult
+	switch format {
+	case "json":
+		err := json.Unmarshal(data, &m)
+		if err == nil && m.Result == nil {
+			err = errors.New("no result found")
+		}
+		return m, err
+	case "csv":
+		return readResultCSV(bytes.NewReader(data))
+	case "sqlite", "parquet":
+		return m, fmt.Errorf("%v needs a library this project doesn't depend on, use json or csv", format)
+	}
+	return m, fmt.Errorf("unknown format %v, use json or csv", format)
+}
+
+// encodes a saved result in the format
+func encodeResult(m MachineResult, format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(m, "", "  ")
+	case "csv":
+		var buf bytes.Buffer
+		err := writeResultCSV(&buf, m)
+		return buf.Bytes(), err
+	case "sqlite", "parquet":
+		return nil, fmt.Errorf("%v needs a library this project doesn't depend on, use json or csv", format)
+	}
+	return nil, fmt.Errorf("unknown format %v, use json or csv", format)
+}
+
+// writes a saved result as csv rows of section, name, value and extra. The
+// extra column of a histogram row is the range of the bucket, for charts.
+func writeResultCSV(w io.Writer, m MachineResult) error {
+	cw := csv.NewWriter(w)
+	r := m.Result
+	i := func(n int64) string {
+		return strconv.FormatInt(n, 10)
+	}
+	rows := [][]string{
+		resultCSVHeader,
+		{"machine", "machine_id", m.MachineID, ""},
+		{"machine", "user", m.User, ""},
+		{"machine", "scanned", m.Scanned.Format(time.RFC3339Nano), ""},
+		{"rules", "name", r.Rules.Name, ""},
+		{"rules", "chunk_size", i(r.Rules.ChunkSize), ""},
+		{"rules", "min_chunks", i(r.Rules.MinChunks), ""},
+		{"rules", "min_file_size", i(r.Rules.MinFileSize), ""},
+		{"rules", "datamap_size", i(r.Rules.DatamapSize), ""},
+		{"rules", "exact", strconv.FormatBool(r.Rules.Exact), ""},
+		{"summary", "files", i(r.Files), ""},
+		{"summary", "large_files", i(r.LargeFiles), ""},
+		{"summary", "small_files", i(r.SmallFiles), ""},
+		{"summary", "large_bytes", i(r.LargeBytes), ""},
+		{"summary", "small_bytes", i(r.SmallBytes), ""},
+		{"summary", "total_chunks", i(r.TotalChunks), ""},
+		{"summary", "large_chunks", i(r.LargeChunks), ""},
+		{"summary", "small_chunks", i(r.SmallChunks), ""},
+		{"summary", "partial_files", i(r.PartialFiles), ""},
+		{"summary", "partial_bytes", i(r.PartialBytes), ""},
+		{"summary", "partial_chunks", i(r.PartialChunks), ""},
+		{"summary", "disk_bytes", i(r.DiskBytes), ""},
+		{"summary", "network_bytes", i(r.NetworkBytes), ""},
+		{"summary", "read_rate", strconv.FormatFloat(r.ReadRate, 'g', -1, 64), ""},
+		{"summary", "overflow", strconv.FormatBool(r.Overflow), ""},
+	}
+	if u := r.Usage; u != nil {
+		f := func(v float64) string { return strconv.FormatFloat(v, 'g', -1, 64) }
+		rows = append(rows,
+			[]string{"usage", "wall_seconds", f(u.WallSeconds), ""},
+			[]string{"usage", "cpu_seconds", f(u.CPUSeconds), ""},
+			[]string{"usage", "peak_rss_bytes", i(u.PeakRSSBytes), ""},
+			[]string{"usage", "files_per_second", f(u.FilesPerSecond), ""},
+			[]string{"usage", "dirs", i(u.Dirs), ""})
+	}
+	keys := []int{}
+	for key := range r.Histogram {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+	bucketWidth := chunkdist.HistogramWidth(r.Histogram)
+	for _, key := range keys {
+		rows = append(rows, []string{"histogram", strconv.Itoa(key), i(r.Histogram[int64(key)]), chunkdist.BucketLabelFor(int64(key), bucketWidth)})
+	}
+	totals := func(section string, totals map[string]DirTotal) {
+		names := []string{}
+		for name := range totals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			rows = append(rows, []string{section, name, i(totals[name].Chunks), i(totals[name].Bytes)})
+		}
+	}
+	totals("dir", r.Dirs)
+	totals("project", r.Projects)
+	totals("extension", r.Extensions)
+	totals("class", r.Classes)
+	for _, key := range keys {
+		for _, example := range r.Examples[int64(key)] {
+			rows = append(rows, []string{"example", strconv.Itoa(key), example, ""})
+		}
+	}
+	for _, warning := range r.Warnings {
+		rows = append(rows, []string{"warning", warning.Code, warning.Subject, warning.Message})
+	}
+	for _, anomaly := range r.Anomalies {
+		rows = append(rows, []string{"anomaly", anomaly.Code, anomaly.Subject, anomaly.Message})
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// reads a saved result written by writeResultCSV
+func readResultCSV(reader io.Reader) (MachineResult, error) {
+	m := MachineResult{Result: NewResult()}
+	r := m.Result
+	records, err := csv.NewReader(reader).ReadAll()
+	if err != nil {
+		return m, err
+	}
+	if len(records) == 0 || !reflect.DeepEqual(records[0], resultCSVHeader) {
+		return m, errors.New("not a saved result csv")
+	}
+	ints := map[string]*int64{
+		"rules/chunk_size":       &r.Rules.ChunkSize,
+		"rules/min_chunks":       &r.Rules.MinChunks,
+		"rules/min_file_size":    &r.Rules.MinFileSize,
+		"rules/datamap_size":     &r.Rules.DatamapSize,
+		"summary/files":          &r.Files,
+		"summary/large_files":    &r.LargeFiles,
+		"summary/small_files":    &r.SmallFiles,
+		"summary/large_bytes":    &r.LargeBytes,
+		"summary/small_bytes":    &r.SmallBytes,
+		"summary/total_chunks":   &r.TotalChunks,
+		"summary/large_chunks":   &r.LargeChunks,
+		"summary/small_chunks":   &r.SmallChunks,
+		"summary/partial_files":  &r.PartialFiles,
+		"summary/partial_bytes":  &r.PartialBytes,
+		"summary/partial_chunks": &r.PartialChunks,
+		"summary/disk_bytes":     &r.DiskBytes,
+		"summary/network_bytes":  &r.NetworkBytes,
+	}
+	// the buckets depend on the chunk size, so are made once the rules rows,
+	// which come first, have been read
+	bucketed := false
+	for n, record := range records[1:] {
+		line := n + 2
+		section, name, value, extra := record[0], record[1], record[2], record[3]
+		parse := func(s string) int64 {
+			v, e := strconv.ParseInt(s, 10, 64)
+			if e != nil && err == nil {
+				err = fmt.Errorf("line %v: %v", line, e)
+			}
+			return v
+		}
+		switch section {
+		case "machine":
+			if name == "machine_id" {
+				m.MachineID = value
+			} else if name == "user" {
+				m.User = value
+			} else if name == "scanned" {
+				m.Scanned, err = time.Parse(time.RFC3339Nano, value)
+			}
+		case "rules", "summary":
+			if p, exists := ints[section+"/"+name]; exists {
+				*p = parse(value)
+			} else if section+"/"+name == "rules/name" {
+				r.Rules.Name = value
+			} else if section+"/"+name == "rules/exact" {
+				r.Rules.Exact, err = strconv.ParseBool(value)
+			} else if section+"/"+name == "summary/read_rate" {
+				r.ReadRate, err = strconv.ParseFloat(value, 64)
+			} else if section+"/"+name == "summary/overflow" {
+				r.Overflow, err = strconv.ParseBool(value)
+			}
+		case "usage":
+			if r.Usage == nil {
+				r.Usage = &ScanUsage{}
+			}
+			switch name {
+			case "wall_seconds":
+				r.Usage.WallSeconds, err = strconv.ParseFloat(value, 64)
+			case "cpu_seconds":
+				r.Usage.CPUSeconds, err = strconv.ParseFloat(value, 64)
+			case "peak_rss_bytes":
+				r.Usage.PeakRSSBytes = parse(value)
+			case "files_per_second":
+				r.Usage.FilesPerSecond, err = strconv.ParseFloat(value, 64)
+			case "dirs":
+				r.Usage.Dirs = parse(value)
+			}
+		case "histogram":
+			if !bucketed {
+				r.Histogram = chunkdist.NewHistogramFor(r.Rules.BucketWidth())
+				bucketed = true
+			}
+			r.Histogram[parse(name)] = parse(value)
+		case "dir":
+			r.Dirs[name] = DirTotal{Chunks: parse(value), Bytes: parse(extra)}
+		case "project":
+			r.Projects[name] = DirTotal{Chunks: parse(value), Bytes: parse(extra)}
+		case "extension":
+			r.Extensions[name] = DirTotal{Chunks: parse(value), Bytes: parse(extra)}
+		case "class":
+			r.Classes[name] = DirTotal{Chunks: parse(value), Bytes: parse(extra)}
+		case "example":
+			key := parse(name)
+			r.Examples[key] = append(r.Examples[key], value)
+		case "warning":
+			r.Warnings = append(r.Warnings, Warning{Code: name, Subject: value, Message: extra})
+		case "anomaly":
+			r.Anomalies = append(r.Anomalies, Warning{Code: name, Subject: value, Message: extra})
+		}
+		if err != nil {
+			return m, err
+		}
+	}
+	return m, nil
+}
+
+// returns an error describing the first difference between two saved results
+func compareResults(a, b MachineResult) error {
+	if a.MachineID != b.MachineID || a.User != b.User || !a.Scanned.Equal(b.Scanned) {
+		return errors.New("machine details differ")
+	}
+	ra, rb := *a.Result, *b.Result
+	if ra.Rules != rb.Rules {
+		return errors.New("rules differ")
+	}
+	summary := func(r Result) []int64 {
+		return []int64{r.Files, r.LargeFiles, r.SmallFiles, r.LargeBytes, r.SmallBytes,
+			r.TotalChunks, r.LargeChunks, r.SmallChunks, r.PartialFiles, r.PartialBytes, r.PartialChunks,
+			r.DiskBytes, r.NetworkBytes}
+	}
+	if !reflect.DeepEqual(summary(ra), summary(rb)) || ra.ReadRate != rb.ReadRate || ra.Overflow != rb.Overflow {
+		return errors.New("summary totals differ")
+	}
+	if !reflect.DeepEqual(ra.Usage, rb.Usage) {
+		return errors.New("usage differs")
+	}
+	if !reflect.DeepEqual(ra.Histogram, rb.Histogram) {
+		return errors.New("histograms differ")
+	}
+	// empty and missing maps are the same
+	sameTotals := func(x, y map[string]DirTotal) bool {
+		return len(x) == len(y) && (len(x) == 0 || reflect.DeepEqual(x, y))
+	}
+	if !sameTotals(ra.Dirs, rb.Dirs) {
+		return errors.New("directory totals differ")
+	}
+	if !sameTotals(ra.Projects, rb.Projects) {
+		return errors.New("project totals differ")
+	}
+	if !sameTotals(ra.Extensions, rb.Extensions) {
+		return errors.New("extension totals differ")
+	}
+	if !sameTotals(ra.Classes, rb.Classes) {
+		return errors.New("class totals differ")
+	}
+	if len(ra.Examples) != len(rb.Examples) || (len(ra.Examples) > 0 && !reflect.DeepEqual(ra.Examples, rb.Examples)) {
+		return errors.New("examples differ")
+	}
+	if len(ra.Warnings) != len(rb.Warnings) || (len(ra.Warnings) > 0 && !reflect.DeepEqual(ra.Warnings, rb.Warnings)) {
+		return errors.New("warnings differ")
+	}
+	if len(ra.Anomalies) != len(rb.Anomalies) || (len(ra.Anomalies) > 0 && !reflect.DeepEqual(ra.Anomalies, rb.Anomalies)) {
+		return errors.New("anomalies differ")
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+// Estimates what uploading the scanned data would cost, given a price for
+// each PUT, a price for each GB stored, or both. The network charges for
+// every chunk and datamap stored, so many small files can cost more than
+// their bytes suggest. Prices are in any currency, the report doesn't
+// convert them.
+
+import (
+	"fmt"
+	"io"
+)
+
+// the PUTs and bytes for one kind of stored object
+type costTotal struct {
+	puts  int64
+	bytes int64
+}
+
+func (t *costTotal) add(count, bytes int64) {
+	t.puts = t.puts + count
+	t.bytes = t.bytes + bytes
+}
+
+// returns the cost of the total at the given prices
+func (t costTotal) cost(putCost, gbCost float64) float64 {
+	return float64(t.puts)*putCost + float64(t.bytes)/float64(OneGb)*gbCost
+}
+
+// costModel totals the large chunks, small chunks and datamaps to price
+// them.
+type costModel struct {
+	rules    Rules
+	putCost  float64 // price of each PUT
+	gbCost   float64 // price of each GB stored
+	large    costTotal
+	small    costTotal
+	datamaps costTotal
+}
+
+func (m *costModel) addFile(size int64) {
+	chunks := m.rules.ChunksForSize(size)
+	m.datamaps.add(1, chunks.DatamapSize)
+	if chunks.Count == 0 {
+		return
+	}
+	middle := chunks.Count - 1
+	if chunks.PenultimateSize != 0 {
+		middle = middle - 1
+		m.addChunks(1, chunks.PenultimateSize)
+	}
+	m.addChunks(middle, chunks.Size)
+	m.addChunks(1, chunks.LastSize)
+}
+
+// adds count chunks of a size in bytes to the large or small chunks, as the
+// report counts them
+func (m *costModel) addChunks(count, size int64) {
+	if size >= m.rules.ChunkSize {
+		m.large.add(count, count*size)
+	} else {
+		m.small.add(count, count*size)
+	}
+}
+
+// prints the PUTs, GB and cost of each kind of object and their total
+func (m *costModel) report(w io.Writer) {
+	fmt.Fprintln(w, "\nStorage cost")
+	fmt.Fprintf(w, "Price per PUT: %v\n", m.putCost)
+	fmt.Fprintf(w, "Price per GB: %v\n", m.gbCost)
+	fmt.Fprintln(w, "Object  PUTs  GB  Cost  Share of cost")
+	var total costTotal
+	for _, t := range []costTotal{m.large, m.small, m.datamaps} {
+		total.add(t.puts, t.bytes)
+	}
+	rows := []struct {
+		name  string
+		total costTotal
+	}{
+		{"Large chunks", m.large},
+		{"Small chunks", m.small},
+		{"Datamaps", m.datamaps},
+		{"Total", total},
+	}
+	totalCost := total.cost(m.putCost, m.gbCost)
+	for _, row := range rows {
+		cost := row.total.cost(m.putCost, m.gbCost)
+		share := 0.0
+		if totalCost > 0 {
+			share = 100 * cost / totalCost
+		}
+		fmt.Fprintf(w, "%v  %v  %f  %.4f  %.1f%%\n",
+			row.name, row.total.puts, float64(row.total.bytes)/float64(OneGb), cost, share)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+// Checks how much of each filesystem a scan covered, by comparing the disk
+// space of the files found with the space the filesystem says is in use. A
+// scan of a whole filesystem that finds much less than is in use has missed
+// directories, through permissions, exclusions or timeouts, so its estimate
+// is low.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// a coverage below this percentage of a whole filesystem gets a warning
+const minCoveragePercent = 90
+
+// the bytes found on one filesystem against the bytes in use on it
+type fsCoverage struct {
+	roots      []string
+	scanned    int64
+	used       int64
+	wholeFs    bool // a root is the mount point, so the scan should find everything
+	percentage float64
+}
+
+// returns whether a directory is the top of its filesystem
+func isMountPoint(dirname string) bool {
+	info, err := os.Stat(dirname)
+	if err != nil {
+		return false
+	}
+	parent, err := os.Stat(filepath.Dir(dirname))
+	if err != nil || filepath.Dir(dirname) == dirname {
+		return true
+	}
+	device, ok := fileDevice(info)
+	parentDevice, parentOk := fileDevice(parent)
+	return ok && parentOk && device != parentDevice
+}
+
+// returns the coverage of each filesystem scanned, grouping roots on the
+// same filesystem. Filesystems whose use can't be read are left out.
+func checkCoverage(scans []rootScan) []fsCoverage {
+	byDevice := map[string]*fsCoverage{}
+	devices := []string{}
+	for _, s := range scans {
+		if s.err != nil || s.result == nil {
+			continue
+		}
+		info, err := os.Stat(s.root)
+		if err != nil {
+			continue
+		}
+		device, ok := fileDevice(info)
+		used, usedOk := fsUsedBytes(s.root)
+		if !ok || !usedOk {
+			continue
+		}
+		c := byDevice[device]
+		if c == nil {
+			c = &fsCoverage{used: used}
+			byDevice[device] = c
+			devices = append(devices, device)
+		}
+		c.roots = append(c.roots, s.root)
+		c.scanned = c.scanned + s.result.DiskBytes
+		c.wholeFs = c.wholeFs || isMountPoint(s.root)
+	}
+	sort.Strings(devices)
+	coverage := []fsCoverage{}
+	for _, device := range devices {
+		c := byDevice[device]
+		c.percentage = 100
+		if c.used > 0 {
+			c.percentage = 100 * float64(c.scanned) / float64(c.used)
+		}
+		coverage = append(coverage, *c)
+	}
+	return coverage
+}
+
+// returns a warning for each whole filesystem the scan found much less of
+// than is in use
+func coverageWarnings(coverage []fsCoverage) []Warning {
+	warnings := []Warning{}
+	for _, c := range coverage {
+		if !c.wholeFs || c.percentage >= minCoveragePercent {
+			continue
+		}
+		warnings = append(warnings, Warning{
+			Code:    "low_coverage",
+			Subject: c.roots[0],
+			Message: fmt.Sprintf("found %.1f%% of the %f GB in use on the filesystem, check for directories that couldn't be read or were excluded",
+				c.percentage, float64(c.used)/float64(OneGb)),
+		})
+	}
+	return warnings
+}
+
+// prints the coverage of each filesystem
+func reportCoverage(w io.Writer, coverage []fsCoverage) {
+	fmt.Fprintln(w, "\nFilesystem coverage")
+	fmt.Fprintln(w, "Roots  Scanned GB  Used GB  Coverage")
+	for _, c := range coverage {
+		note := ""
+		if !c.wholeFs {
+			note = " (part of the filesystem)"
+		}
+		fmt.Fprintf(w, "%v  %f  %f  %.1f%%%v\n", strings.Join(c.roots, ", "), float64(c.scanned)/float64(OneGb),
+			float64(c.used)/float64(OneGb), c.percentage, note)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+// Saved results contain path information, so they can be encrypted to a
+// recipient's public key. This is a sealed box built from the standard
+// library: an ephemeral X25519 key agreement with the recipient key, HKDF to
+// derive an AES-256-GCM key, and the ephemeral public key stored in the file
+// so only the holder of the recipient private key can decrypt it.
+//
+// It isn't age or NaCl box, since both need ciphers the standard library
+// doesn't export (ChaCha20-Poly1305 and XSalsa20-Poly1305) and the tool has no
+// dependencies. The format is:
+//
+//	"chunk_distribution-sealed-v1\n"  magic, also the additional data
+//	ephemeral public key              32 bytes, X25519
+//	nonce                             12 bytes
+//	ciphertext and tag                AES-256-GCM, 16 byte tag
+//
+// The key is HKDF-SHA256 of the X25519 shared secret, salted with the
+// ephemeral then the recipient public key, with the info "chunk_distribution
+// result". Keys are cdpub1 or cdsec1 followed by the 32 byte X25519 public or
+// private key in unpadded base64url.
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// the start of every encrypted file
+const sealedMagic = "chunk_distribution-sealed-v1\n"
+
+// prefixes for encoded keys, so the two are not confused
+const (
+	publicKeyPrefix  = "cdpub1"
+	privateKeyPrefix = "cdsec1"
+)
+
+// returns an encoded key
+func encodeKey(prefix string, key []byte) string {
+	return prefix + base64.RawURLEncoding.EncodeToString(key)
+}
+
+// returns the key from an encoded key
+func decodeKey(prefix, encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if !strings.HasPrefix(encoded, prefix) {
+		return nil, fmt.Errorf("key must start with %v", prefix)
+	}
+	return base64.RawURLEncoding.DecodeString(strings.TrimPrefix(encoded, prefix))
+}
+
+// returns the aes-gcm cipher for a shared secret between two public keys
+func sealCipher(shared, ephemeral, recipient []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	key, err := hkdf.Key(sha256.New, shared, salt, "chunk_distribution result", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encrypts data to the encoded public key
+func seal(data []byte, publicKey string) ([]byte, error) {
+	recipientBytes, err := decodeKey(publicKeyPrefix, publicKey)
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := ecdh.X25519().NewPublicKey(recipientBytes)
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralBytes := ephemeral.PublicKey().Bytes()
+	aead, err := sealCipher(shared, ephemeralBytes, recipientBytes)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := []byte(sealedMagic)
+	sealed = append(sealed, ephemeralBytes...)
+	sealed = append(sealed, nonce...)
+	return aead.Seal(sealed, nonce, data, []byte(sealedMagic)), nil
+}
+
+// returns true if the data was encrypted by seal
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedMagic))
+}
+
+// decrypts data encrypted by seal using the encoded private key
+func open(data []byte, privateKey string) ([]byte, error) {
+	if !isSealed(data) {
+		return nil, errors.New("data is not encrypted")
+	}
+	keyBytes, err := decodeKey(privateKeyPrefix, privateKey)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdh.X25519().NewPrivateKey(keyBytes)
+	if err != nil {
+		return nil, err
+	}
+	data = data[len(sealedMagic):]
+	if len(data) < 32 {
+		return nil, errors.New("encrypted data is too short")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(data[:32])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := key.ECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := sealCipher(shared, data[:32], key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	data = data[32:]
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("encrypted data is too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(sealedMagic))
+}
+
+// creates a key pair for encrypting saved results
+func defineKeygen(flags *flag.FlagSet) func() error {
+	out := flags.String("o", "", "file to write the private key to, the public key is printed")
+	return func() error {
+		if *out == "" {
+			return errors.New("keygen requires -o")
+		}
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		public := encodeKey(publicKeyPrefix, key.PublicKey().Bytes())
+		private := encodeKey(privateKeyPrefix, key.Bytes())
+		content := "# public key: " + public + "\n" + private + "\n"
+		if err := ioutil.WriteFile(*out, []byte(content), 0600); err != nil {
+			return err
+		}
+		fmt.Println("Public key:", public)
+		return nil
+	}
+}
+
+// reads the private key from a file written by keygen
+func readPrivateKey(filename string) (string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, privateKeyPrefix) {
+			return line, nil
+		}
+	}
+	return "", fmt.Errorf("no private key found in %v", filename)
+}
+
+// decrypts a saved result
+func defineDecrypt(flags *flag.FlagSet) func() error {
+	keyFile := flags.String("key", "", "private key file written by keygen")
+	out := flags.String("o", "", "file to write the decrypted result to")
+	return func() error {
+		if *keyFile == "" || *out == "" || flags.NArg() != 1 {
+			return errors.New("usage: decrypt -key key.txt -o result.json result.json.enc")
+		}
+		key, err := readPrivateKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		data, err = open(data, key)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(*out, data, 0600)
+	}
+}
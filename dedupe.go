@@ -0,0 +1,195 @@
+package main
+
+// Estimates how many chunks are duplicates by reading every file and hashing
+// each chunk where the rules would cut it. A content-addressed network stores
+// a chunk once however many files it is in, which sizes alone can't show.
+// Self encryption encrypts each chunk with keys from the hashes of the two
+// chunks before it in the file, so an encrypted chunk is only a duplicate
+// when those two chunks match too. Both plain and encrypted duplicates are
+// counted, the plain ones being what a store without self encryption would
+// save.
+
+import (
+	"crypto/sha256"
+	"crypto/sha3"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// the hashes chunks can be compared with. xxhash and blake3 aren't in the
+// standard library, fnv being the fast non-cryptographic choice here.
+var dedupeHashes = map[string]func() hash.Hash{
+	"sha3":   func() hash.Hash { return sha3.New256() },
+	"sha256": sha256.New,
+	"fnv":    func() hash.Hash { return fnv.New128a() },
+}
+
+// returns the names of the hashes for -dedupe-hash
+func dedupeHashNames() []string {
+	names := []string{}
+	for name := range dedupeHashes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// the chunks seen and how many were new
+type dedupeTotal struct {
+	chunks       int64
+	bytes        int64
+	uniqueChunks int64
+	uniqueBytes  int64
+	seen         map[string]bool
+}
+
+// adds a chunk, counting it as unique the first time its id is seen
+func (t *dedupeTotal) add(id string, size int64) {
+	t.chunks = t.chunks + 1
+	t.bytes = t.bytes + size
+	if !t.seen[id] {
+		t.seen[id] = true
+		t.uniqueChunks = t.uniqueChunks + 1
+		t.uniqueBytes = t.uniqueBytes + size
+	}
+}
+
+// dedupeModel counts the unique chunks in the files scanned.
+type dedupeModel struct {
+	mu        sync.Mutex
+	rules     Rules
+	hashName  string
+	newHash   func() hash.Hash
+	plain     dedupeTotal // chunks by their content
+	encrypted dedupeTotal // chunks by their content and the two chunks before them
+	unread    int64       // files that couldn't be read
+	changed   int64       // files that shrank or grew after they were listed
+}
+
+func newDedupeModel(rules Rules, hashName string) (*dedupeModel, error) {
+	newHash, exists := dedupeHashes[hashName]
+	if !exists {
+		return nil, fmt.Errorf("unknown hash %v, use one of %v", hashName, strings.Join(dedupeHashNames(), ", "))
+	}
+	return &dedupeModel{
+		rules:     rules,
+		hashName:  hashName,
+		newHash:   newHash,
+		plain:     dedupeTotal{seen: map[string]bool{}},
+		encrypted: dedupeTotal{seen: map[string]bool{}},
+	}, nil
+}
+
+// returns the size of each chunk of a file, or the whole file as one piece
+// when it is small enough to be stored in the datamap
+func chunkLengths(rules Rules, size int64) []int64 {
+	chunks := rules.ChunksForSize(size)
+	if chunks.Count == 0 {
+		return []int64{size}
+	}
+	sizes := []int64{}
+	for i := int64(0); i < chunks.Count-1; i++ {
+		sizes = append(sizes, chunks.Size)
+	}
+	if chunks.PenultimateSize != 0 {
+		sizes[len(sizes)-1] = chunks.PenultimateSize
+	}
+	return append(sizes, chunks.LastSize)
+}
+
+// reads a file and hashes each chunk where the rules would cut it, given the
+// size the file was listed with, returning the chunk sizes and their hashes.
+// The error is errFileChanged if the file is no longer that size.
+func hashChunks(rules Rules, newHash func() hash.Hash, filename string, size int64) ([]int64, [][]byte, error) {
+	sizes := chunkLengths(rules, size)
+	sums := make([][]byte, len(sizes))
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer fh.Close()
+	for i, size := range sizes {
+		h := newHash()
+		if _, err := io.CopyN(h, fh, size); err == io.EOF {
+			return nil, nil, errFileChanged
+		} else if err != nil {
+			return nil, nil, err
+		}
+		sums[i] = h.Sum(nil)
+	}
+	// anything after the last chunk was written since the listing
+	if n, _ := fh.Read(make([]byte, 1)); n != 0 {
+		return nil, nil, errFileChanged
+	}
+	return sizes, sums, nil
+}
+
+// returns an id for each chunk of a file as self encryption would store it,
+// from the chunk's hash and the hashes of the two chunks before it, which
+// its keys come from. A file stored in its datamap is a single piece, kept
+// by its hash alone.
+func encryptedChunkIDs(sums [][]byte) []string {
+	n := len(sums)
+	ids := make([]string, n)
+	for i, sum := range sums {
+		if n == 1 {
+			ids[i] = string(sum)
+			continue
+		}
+		ids[i] = string(sum) + string(sums[(i+n-1)%n]) + string(sums[(i+n-2)%n])
+	}
+	return ids
+}
+
+// reads a file and adds its chunks, safe to call from several scans at once
+func (m *dedupeModel) addFile(f file) {
+	if !f.info.Mode().IsRegular() {
+		return
+	}
+	sizes, sums, err := hashChunks(m.rules, m.newHash, f.path, f.info.Size())
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err == errFileChanged {
+		m.changed = m.changed + 1
+		return
+	}
+	if err != nil {
+		m.unread = m.unread + 1
+		return
+	}
+	for i, id := range encryptedChunkIDs(sums) {
+		m.plain.add(string(sums[i]), sizes[i])
+		m.encrypted.add(id, sizes[i])
+	}
+}
+
+func (m *dedupeModel) report(w io.Writer) {
+	fmt.Fprintf(w, "\nDeduplication (%v)\n", m.hashName)
+	fmt.Fprintln(w, "Chunks by  Chunks  Unique chunks  Duplicate chunks  GB saved  Saved")
+	rows := []struct {
+		name  string
+		total dedupeTotal
+	}{
+		{"Content", m.plain},
+		{"Content and the two chunks before (self encryption)", m.encrypted},
+	}
+	for _, row := range rows {
+		t := row.total
+		fmt.Fprintf(w, "%v  %v  %v  %v  %f  %.1f%%\n",
+			row.name, t.chunks, t.uniqueChunks, t.chunks-t.uniqueChunks,
+			float64(t.bytes-t.uniqueBytes)/float64(OneGb), percent(t.bytes-t.uniqueBytes, t.bytes))
+	}
+	fmt.Fprintln(w, "Files stored in their datamap count as one chunk. Datamaps of larger files aren't counted.")
+	if m.unread > 0 {
+		fmt.Fprintln(w, m.unread, "files couldn't be read and aren't counted")
+	}
+	if m.changed > 0 {
+		fmt.Fprintln(w, m.changed, "files changed size during the scan and aren't counted")
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+// Compares the space files take on disk with the space their chunks take on
+// the network, to explain why the network needs more than du reports.
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// copies of each chunk assumed to be kept by the network
+const networkCopies = 4
+
+// returns the bytes a file takes on disk. Where the filesystem doesn't say,
+// the size is rounded up to whole blocks.
+func diskBytes(info os.FileInfo, blockSize int64) int64 {
+	if allocated, ok := allocatedBytes(info); ok {
+		return allocated
+	}
+	size := info.Size()
+	if blockSize <= 0 || size%blockSize == 0 {
+		return size
+	}
+	return (size/blockSize + 1) * blockSize
+}
+
+// prints the space used on disk next to the space predicted on the network,
+// and what makes up the difference. Results without disk usage, such as
+// imported listings, aren't reported.
+func reportDiskSpace(w io.Writer, r *Result) {
+	if r.DiskBytes == 0 {
+		return
+	}
+	gb := func(bytes int64) float64 {
+		return float64(bytes) / float64(OneGb)
+	}
+	content := r.LargeBytes + r.SmallBytes
+	// the chunks needed if every chunk were full
+	fullChunks := content / r.Rules.ChunkSize
+	if content%r.Rules.ChunkSize != 0 {
+		fullChunks = fullChunks + 1
+	}
+	// content in datamaps isn't in chunks, so this can be slightly negative
+	extraChunks := r.TotalChunks - r.Files - fullChunks
+	if extraChunks < 0 {
+		extraChunks = 0
+	}
+	fmt.Fprintln(w, "\n"+tr("Disk and network space"))
+	fmt.Fprintf(w, tr("On disk: %f GB\n"), gb(r.DiskBytes))
+	// partly used blocks add to the disk, sparse files take from it
+	fmt.Fprintf(w, tr("File content: %f GB (%v vs on disk)\n"), gb(content), change(float64(r.DiskBytes), float64(content)))
+	fmt.Fprintf(w, tr("Datamaps: %f GB\n"), gb(r.NetworkBytes-content))
+	fmt.Fprintf(w, tr("On the network: %f GB (%v vs on disk)\n"), gb(r.NetworkBytes), change(float64(r.DiskBytes), float64(r.NetworkBytes)))
+	fmt.Fprintf(w, tr("With %v copies of each chunk: %f GB\n"), networkCopies, gb(r.NetworkBytes*networkCopies))
+	fmt.Fprintf(w, tr("Chunks: %v, of which %v are datamaps and %v more than if every chunk were full, mostly from the %v chunk minimum\n"),
+		r.TotalChunks, r.Files, extraChunks, r.Rules.MinChunks)
+}
+
+// returns the bytes of a file's content that are on disk, for -disk-usage.
+// Sparse files have less on disk than their size, and files that don't fill
+// their last block have more, which doesn't count as content.
+func usedBytes(info os.FileInfo) int64 {
+	size := info.Size()
+	if allocated, ok := allocatedBytes(info); ok && allocated < size {
+		return allocated
+	}
+	return size
+}
+
+// apparentModel counts the chunks by the apparent size of files, to compare
+// with the chunks by the size on disk that -disk-usage counts.
+type apparentModel struct {
+	mu     sync.Mutex
+	result *Result
+}
+
+func newApparentModel(rules Rules) *apparentModel {
+	r := NewResult()
+	r.Rules = rules
+	return &apparentModel{result: r}
+}
+
+// adds a file, safe to call from several scans at once
+func (m *apparentModel) addFile(f file) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.result.AddFile(f.info.Size())
+}
+
+// prints the totals by apparent size next to those by size on disk, and the
+// histogram by apparent size
+func (m *apparentModel) report(w io.Writer, onDisk *Result) {
+	a := m.result
+	fmt.Fprintln(w, "\nApparent size and size on disk")
+	fmt.Fprintln(w, "Size  Files  GB  Chunks  Large chunks  Small chunks")
+	for _, row := range []struct {
+		name string
+		r    *Result
+	}{{"Apparent", a}, {"On disk", onDisk}} {
+		fmt.Fprintf(w, "%v  %v  %f  %v  %v  %v\n", row.name, row.r.Files,
+			float64(row.r.LargeBytes+row.r.SmallBytes)/float64(OneGb), row.r.TotalChunks, row.r.LargeChunks, row.r.SmallChunks)
+	}
+	fmt.Fprintln(w, "\nChunk Size  Count, by apparent size")
+	chunkdist.WriteHistogram(w, a.Histogram)
+}
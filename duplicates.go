@@ -0,0 +1,73 @@
+package main
+
+// Finds files with identical content, by size and then by hash, and reports
+// what uploading each only once would save. Only files with the same size as
+// another are hashed, once the scan is done.
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// dupeModel records the files of each size to hash the ones that may be
+// duplicates.
+type dupeModel struct {
+	mu    sync.Mutex
+	rules Rules
+	paths map[int64][]string // files by size
+}
+
+func newDupeModel(rules Rules) *dupeModel {
+	return &dupeModel{rules: rules, paths: map[int64][]string{}}
+}
+
+// adds a file, safe to call from several scans at once. Empty files are left
+// out, since they are all identical but hold nothing.
+func (m *dupeModel) addFile(f file) {
+	size := f.info.Size()
+	if !f.info.Mode().IsRegular() || size == 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.paths[size] = append(m.paths[size], f.path)
+}
+
+// hashes the files that share a size and prints how many are copies of
+// another, and the chunks uploading each only once saves
+func (m *dupeModel) report(w io.Writer) {
+	sizes := []int64{}
+	for size, paths := range m.paths {
+		if len(paths) > 1 {
+			sizes = append(sizes, size)
+		}
+	}
+	var files, copies, bytes, chunks, unread int64
+	for _, paths := range m.paths {
+		files = files + int64(len(paths))
+	}
+	for _, size := range sizes {
+		seen := map[string]bool{}
+		for _, path := range m.paths[size] {
+			hash, err := hashFile(path)
+			if err != nil {
+				unread = unread + 1
+				continue
+			}
+			if seen[hash] {
+				copies = copies + 1
+				bytes = bytes + size
+				chunks = chunks + m.rules.ChunksForSize(size).Count + 1 // + 1 for datamap
+			}
+			seen[hash] = true
+		}
+	}
+	fmt.Fprintln(w, "\nDuplicate files")
+	fmt.Fprintf(w, "Copies of another file: %v of %v files (%.1f%%)\n", copies, files, percent(copies, files))
+	fmt.Fprintf(w, "Duplicate GB: %f\n", float64(bytes)/float64(OneGb))
+	fmt.Fprintln(w, "Chunks saved uploading each once:", chunks)
+	if unread > 0 {
+		fmt.Fprintln(w, unread, "files couldn't be read and aren't counted")
+	}
+}
@@ -0,0 +1,174 @@
+//go:build e2e
+
+package main
+
+// An end to end test of the chunking, run with make e2e. A fixture tree is
+// split into chunks where the rules cut each file, the chunks are put in a
+// local content-addressed store standing in for the network, and every file
+// is restored from its datamap and compared with the original.
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha3"
+	"encoding/hex"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+// a directory of chunks named by the hash of their content, so identical
+// chunks are stored once
+type mockChunkStore struct {
+	dir    string
+	puts   int64
+	stored int64
+}
+
+func (s *mockChunkStore) put(chunk []byte) (string, error) {
+	sum := sha3.Sum256(chunk)
+	name := hex.EncodeToString(sum[:])
+	s.puts = s.puts + 1
+	filename := filepath.Join(s.dir, name)
+	if _, err := os.Stat(filename); err == nil {
+		return name, nil
+	}
+	s.stored = s.stored + 1
+	return name, os.WriteFile(filename, chunk, 0600)
+}
+
+func (s *mockChunkStore) get(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, name))
+}
+
+// the chunks of a file in order, or its content for files small enough to
+// be kept in the datamap
+type mockDatamap struct {
+	chunks  []string
+	content []byte
+}
+
+// splits a file into chunks and stores them, returning its datamap
+func storeFile(store *mockChunkStore, rules Rules, filename string, size int64) (mockDatamap, error) {
+	var d mockDatamap
+	f, err := os.Open(filename)
+	if err != nil {
+		return d, err
+	}
+	defer f.Close()
+	if rules.ChunksForSize(size).Count == 0 {
+		d.content, err = io.ReadAll(f)
+		return d, err
+	}
+	for _, length := range chunkLengths(rules, size) {
+		chunk := make([]byte, length)
+		if _, err := io.ReadFull(f, chunk); err != nil {
+			return d, err
+		}
+		name, err := store.put(chunk)
+		if err != nil {
+			return d, err
+		}
+		d.chunks = append(d.chunks, name)
+	}
+	// nothing may be left after the last chunk
+	if n, _ := f.Read(make([]byte, 1)); n != 0 {
+		return d, io.ErrShortBuffer
+	}
+	return d, nil
+}
+
+// returns the content of a file from its datamap
+func restoreFile(store *mockChunkStore, d mockDatamap) ([]byte, error) {
+	if d.chunks == nil {
+		return d.content, nil
+	}
+	var content bytes.Buffer
+	for _, name := range d.chunks {
+		chunk, err := store.get(name)
+		if err != nil {
+			return nil, err
+		}
+		content.Write(chunk)
+	}
+	return content.Bytes(), nil
+}
+
+// creates files of random content with sizes around each boundary of the
+// rules, one of them copied so the store has duplicate chunks
+func makeFixtureTree(t *testing.T, root string, rules Rules) {
+	random := rand.New(rand.NewSource(1))
+	sizes := []int64{0, 1, rules.MinFileSize - 1, rules.MinFileSize, rules.MinFileSize + 1,
+		3*rules.MinFileSize - 1, rules.ChunkSize - 1, rules.ChunkSize, rules.ChunkSize + 1,
+		2*rules.ChunkSize + 5, 3*rules.ChunkSize - 1, 3 * rules.ChunkSize, 5*rules.ChunkSize + 7}
+	for i, size := range sizes {
+		dir := filepath.Join(root, "dir"+string(rune('a'+i%3)), "sub")
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		content := make([]byte, size)
+		random.Read(content)
+		name := filepath.Join(dir, "file"+string(rune('a'+i)))
+		if err := os.WriteFile(name, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if size == 3*rules.ChunkSize {
+			if err := os.WriteFile(filepath.Join(root, "copy"), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestEndToEnd(t *testing.T) {
+	rules := ruleSets[defaultRules]
+	exact := rules
+	exact.Exact = true
+	for name, rules := range map[string]Rules{"fixed": rules, "exact": exact} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			makeFixtureTree(t, root, rules)
+			store := &mockChunkStore{dir: t.TempDir()}
+			datamaps := map[string]mockDatamap{}
+			w := &walker{ctx: context.Background(), root: root}
+			rootFiles, names, project, ignore := w.readRoot(root)
+			add := func(f file) {
+				d, err := storeFile(store, rules, f.path, f.info.Size())
+				if err != nil {
+					t.Fatalf("storing %v: %v", f.path, err)
+				}
+				datamaps[f.path] = d
+			}
+			for _, f := range rootFiles {
+				add(f)
+			}
+			for _, name := range names {
+				w.walkTree(path.Join(root, name), project, ignore, add)
+			}
+			for filename, d := range datamaps {
+				restored, err := restoreFile(store, d)
+				if err != nil {
+					t.Fatalf("restoring %v: %v", filename, err)
+				}
+				original, err := os.ReadFile(filename)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(restored, original) {
+					t.Fatalf("%v restored as %v bytes that differ from its %v bytes", filename, len(restored), len(original))
+				}
+			}
+			// the scan counts a datamap for every file on top of its chunks
+			r := scan(context.Background(), root, scanOptions{rules: rules})
+			if expected := store.puts + int64(len(datamaps)); r.TotalChunks != expected {
+				t.Fatalf("scan counted %v chunks, stored %v chunks and %v datamaps", r.TotalChunks, store.puts, len(datamaps))
+			}
+			if store.stored >= store.puts {
+				t.Fatalf("the copied file's %v chunks weren't deduplicated", store.puts)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// adds a file as an example for the histogram buckets its chunks are in,
+// unless a bucket already has max examples. Files stored in the datamap are
+// examples of the smallest bucket.
+func (r *Result) addExample(filename string, size int64, max int, redact bool) {
+	if redact {
+		filename = redactPath(filename)
+	}
+	chunks := r.Rules.ChunksForSize(size)
+	width := r.Rules.BucketWidth()
+	keys := []int64{chunkdist.HistogramKeyFor(chunks.DatamapSize/OneKb, width)}
+	if chunks.Count > 0 {
+		keys = []int64{chunkdist.HistogramKeyFor(chunks.Size/OneKb, width)}
+		for _, size := range []int64{chunks.PenultimateSize, chunks.LastSize} {
+			key := chunkdist.HistogramKeyFor(size/OneKb, width)
+			if size != 0 && key != keys[0] && key != keys[len(keys)-1] {
+				keys = append(keys, key)
+			}
+		}
+	}
+	for _, key := range keys {
+		if len(r.Examples[key]) < max {
+			r.Examples[key] = append(r.Examples[key], filename)
+		}
+	}
+}
+
+// keeps at most max examples in each bucket, after merging results that
+// each have up to max
+func (r *Result) limitExamples(max int) {
+	for key, examples := range r.Examples {
+		if len(examples) > max {
+			r.Examples[key] = examples[:max]
+		}
+	}
+}
+
+// replaces a path with a hash of it, keeping the extension so the kind of
+// file is still known
+func redactPath(filename string) string {
+	sum := sha256.Sum256([]byte(filename))
+	return "redacted-" + hex.EncodeToString(sum[:6]) + filepath.Ext(filename)
+}
+
+// prints the example files for each bucket, if any were recorded
+func reportExamples(w io.Writer, r *Result) {
+	if len(r.Examples) == 0 {
+		return
+	}
+	keys := []int{}
+	for key := range r.Examples {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+	fmt.Fprintln(w, "\n"+tr("Chunk Size  Example file"))
+	for _, key := range keys {
+		for _, example := range r.Examples[int64(key)] {
+			fmt.Fprintf(w, "%8s  %v\n", chunkdist.BucketStart(int64(key)), example)
+		}
+	}
+}
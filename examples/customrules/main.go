@@ -0,0 +1,43 @@
+// Command customrules compares the chunks for a directory under the rules of
+// a network era with rules of its own, here 4 MB chunks and files under 1 KB
+// kept in their datamap, and with large videos modelled as re-encoded.
+//
+//	go run ./examples/customrules ~/Videos
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: customrules dir")
+	}
+	base := chunkdist.RuleSets[chunkdist.DefaultRules]
+	custom := base
+	custom.Name = "4mb-chunks"
+	custom.ChunkSize = 4 * chunkdist.OneMb
+	custom.MinFileSize = chunkdist.OneKb
+	reencoded := custom
+	reencoded.Name = "4mb-chunks+reencoded"
+	for _, rules := range []chunkdist.Rules{base, custom, reencoded} {
+		a := chunkdist.NewAnalyzer(rules)
+		if rules.Name == reencoded.Name {
+			a.Transform = chunkdist.SizeTransformerFunc(func(path string, size int64) int64 {
+				if strings.HasSuffix(strings.ToLower(path), ".mov") {
+					return size / 4 // re-encoded as h.265
+				}
+				return size
+			})
+		}
+		if err := a.WalkDir(os.Args[1]); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%v: %v chunks, %v large, %v small\n", rules.Name, a.TotalChunks, a.LargeChunks, a.SmallChunks)
+	}
+}
@@ -0,0 +1,23 @@
+// Command minimal prints the chunk distribution of a directory with the
+// default rules, the least code needed to use the chunkdist package.
+//
+//	go run ./examples/minimal ~/Documents
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("usage: minimal dir")
+	}
+	a := chunkdist.NewAnalyzer(chunkdist.RuleSets[chunkdist.DefaultRules])
+	if err := a.WalkDir(os.Args[1]); err != nil {
+		log.Fatal(err)
+	}
+	a.Report(os.Stdout)
+}
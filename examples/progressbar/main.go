@@ -0,0 +1,67 @@
+// Command progressbar shows how an uploader can embed the estimate in its
+// own terminal interface: the walk runs in one goroutine while the other
+// redraws a progress bar from the analyzer's Progress, then the totals are
+// printed. The bar needs the bytes expected, given on the command line here,
+// which an uploader would know from what it was asked to upload.
+//
+//	go run ./examples/progressbar ~/Pictures 20G
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// returns a size such as 20G in bytes
+func parseSize(s string) (int64, error) {
+	units := map[string]int64{"K": chunkdist.OneKb, "M": chunkdist.OneMb, "G": chunkdist.OneGb}
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	multiplier := int64(1)
+	if unit, exists := units[strings.ToUpper(s[len(s)-1:])]; exists {
+		multiplier = unit
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n * multiplier, err
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		log.Fatal("usage: progressbar dir expected_size")
+	}
+	expected, err := parseSize(os.Args[2])
+	if err != nil {
+		log.Fatal(err)
+	}
+	a := chunkdist.NewAnalyzer(chunkdist.RuleSets[chunkdist.DefaultRules])
+	a.Progress = chunkdist.NewProgress()
+	done := make(chan error)
+	go func() {
+		done <- a.WalkDir(os.Args[1])
+	}()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			s := a.Progress.Snapshot()
+			fmt.Printf("\r\033[K%v %v chunks\n", s.Bar(s.Bytes, 40), s.Chunks)
+			if err != nil {
+				log.Fatal(err)
+			}
+			a.Report(os.Stdout)
+			return
+		case <-ticker.C:
+			s := a.Progress.Snapshot()
+			fmt.Printf("\r\033[K%v %v chunks", s.Bar(expected, 40), s.Chunks)
+		}
+	}
+}
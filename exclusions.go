@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// how many directories to list in the exclusions report
+const exclusionsToReport = 10
+
+// the chunks and bytes that would be removed by excluding a directory
+type exclusion struct {
+	name   string
+	chunks int64
+	bytes  int64
+}
+
+// prints the totals that would remain if each of the largest top level
+// directories were excluded
+func reportExclusions(w io.Writer, r *Result) {
+	totalChunks := r.TotalChunks
+	totalBytes := r.LargeBytes + r.SmallBytes
+	exclusions := []exclusion{}
+	for name, dir := range r.Dirs {
+		exclusions = append(exclusions, exclusion{name, dir.Chunks, dir.Bytes})
+	}
+	sort.Slice(exclusions, func(i, j int) bool {
+		if exclusions[i].chunks == exclusions[j].chunks {
+			return exclusions[i].name < exclusions[j].name
+		}
+		return exclusions[i].chunks > exclusions[j].chunks
+	})
+	if len(exclusions) > exclusionsToReport {
+		exclusions = exclusions[:exclusionsToReport]
+	}
+	fmt.Fprintln(w, "\n"+tr("What if I excluded..."))
+	fmt.Fprintln(w, tr("Directory  Total chunks  Total GB"))
+	for _, e := range exclusions {
+		fmt.Fprintf(w, "%v  %v (-%v)  %f (-%f)\n",
+			e.name,
+			totalChunks-e.chunks, e.chunks,
+			float64(totalBytes-e.bytes)/float64(OneGb), float64(e.bytes)/float64(OneGb))
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+// Shows how one file is split into chunks under the chosen rules, to check
+// the model against a real upload or to see why a file costs the chunks it
+// does.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// prints each chunk of the given files, their datamap and the bytes sent
+func defineExplain(flags *flag.FlagSet) func() error {
+	rulesName := flags.String("rules", defaultRules, "chunking rules of a network era: "+strings.Join(ruleSetNames(), ", "))
+	chunkSize := flags.String("chunk-size", "", "override the chunk size of the rules, eg 512K or 4M")
+	minFileSize := flags.String("min-file-size", "", "override the size below which files are stored in the datamap, eg 1K")
+	exact := flags.Bool("exact", false, "split files exactly as self_encryption does")
+	return func() error {
+		if flags.NArg() == 0 {
+			return errors.New("usage: explain [-rules name] [-exact] file...")
+		}
+		rules, exists := ruleSets[*rulesName]
+		if !exists {
+			return fmt.Errorf("unknown rules %v, use one of %v", *rulesName, strings.Join(ruleSetNames(), ", "))
+		}
+		rules, err := customRules(rules, *chunkSize, *minFileSize, *exact)
+		if err != nil {
+			return err
+		}
+		for i, filename := range flags.Args() {
+			info, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return fmt.Errorf("%v is not a regular file", filename)
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			explainFile(os.Stdout, rules, filename, info.Size())
+		}
+		return nil
+	}
+}
+
+// prints how a file of the given size is chunked, one line for each run of
+// chunks of the same size
+func explainFile(w io.Writer, rules Rules, filename string, size int64) {
+	chunks := rules.ChunksForSize(size)
+	fmt.Fprintln(w, "File:", filename)
+	fmt.Fprintf(w, "Size: %v bytes\n", size)
+	fmt.Fprintln(w, "Rules:", rules.Name)
+	fmt.Fprintln(w, explainReason(rules, size, chunks))
+	if chunks.Count > 0 {
+		lengths := chunkLengths(rules, size)
+		width := rules.BucketWidth()
+		for start := 0; start < len(lengths); {
+			end := start
+			for end+1 < len(lengths) && lengths[end+1] == lengths[start] {
+				end = end + 1
+			}
+			bucket := chunkdist.BucketLabelFor(chunkdist.HistogramKeyFor(lengths[start]/OneKb, width), width)
+			if start == end {
+				fmt.Fprintf(w, "  Chunk %v: %v bytes (%v)\n", start+1, lengths[start], bucket)
+			} else {
+				fmt.Fprintf(w, "  Chunks %v-%v: %v bytes each (%v)\n", start+1, end+1, lengths[start], bucket)
+			}
+			start = end + 1
+		}
+	}
+	fmt.Fprintf(w, "Datamap: %v bytes\n", chunks.DatamapSize)
+	fmt.Fprintf(w, "Objects: %v (%v chunks + 1 datamap)\n", chunks.Count+1, chunks.Count)
+	fmt.Fprintf(w, "Network bytes: %v\n", chunks.Bytes()+chunks.DatamapSize)
+}
+
+// returns which of the rules decided how a file is split
+func explainReason(rules Rules, size int64, chunks chunkdist.Chunks) string {
+	switch {
+	case chunks.Count == 0:
+		return fmt.Sprintf("Smaller than the minimum file size of %v bytes, so the content is stored in the datamap", rules.MinFileSize)
+	case rules.Exact && size < rules.MinChunks*rules.ChunkSize:
+		return fmt.Sprintf("Smaller than %v full chunks, so split into %v equal chunks", rules.MinChunks, rules.MinChunks)
+	case !rules.Exact && size <= rules.ChunkSize:
+		return fmt.Sprintf("No larger than the chunk size of %v bytes, so split into %v equal chunks", rules.ChunkSize, rules.MinChunks)
+	case chunks.PenultimateSize != 0:
+		return fmt.Sprintf("Split into chunks of %v bytes, the last taking bytes from the one before so it isn't below the minimum chunk size of %v bytes",
+			rules.ChunkSize, rules.MinFileSize/rules.MinChunks)
+	default:
+		return fmt.Sprintf("Split into chunks of %v bytes, the last holding what's left", rules.ChunkSize)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+// Models the folder objects the network would need to describe the directory
+// tree. Each folder object holds a limited number of entries, so a huge flat
+// directory is split over many objects.
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// the upper bounds of the buckets of the entries per directory histogram
+var folderBuckets = []int64{10, 100, 1000, 10000, 100000}
+
+// counts the entries in each directory and the folder objects they need
+type folderModel struct {
+	mu         sync.Mutex
+	maxEntries int64
+	dirs       int64
+	objects    int64
+	largest    int64
+	histogram  map[int64]int64 // directories keyed by the bucket's upper bound, or 0 for the last
+}
+
+func newFolderModel(maxEntries int64) *folderModel {
+	return &folderModel{maxEntries: maxEntries, histogram: map[int64]int64{}}
+}
+
+// adds a directory with the given number of entries, safe to call from
+// several scans at once
+func (m *folderModel) addDir(entries int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dirs = m.dirs + 1
+	// an empty directory still needs an object
+	objects := (entries + m.maxEntries - 1) / m.maxEntries
+	if objects == 0 {
+		objects = 1
+	}
+	m.objects = m.objects + objects
+	if entries > m.largest {
+		m.largest = entries
+	}
+	var bucket int64
+	for _, max := range folderBuckets {
+		if entries < max {
+			bucket = max
+			break
+		}
+	}
+	m.histogram[bucket] = m.histogram[bucket] + 1
+}
+
+// prints the entries per directory histogram and the folder objects needed
+func (m *folderModel) report(w io.Writer) {
+	fmt.Fprintln(w, "\nEntries per directory  Directories")
+	var lower int64
+	for _, upper := range folderBuckets {
+		fmt.Fprintf(w, "%v-%v  %v\n", lower, upper-1, m.histogram[upper])
+		lower = upper
+	}
+	fmt.Fprintf(w, "%v+  %v\n", lower, m.histogram[0])
+	fmt.Fprintln(w, "Directories:", m.dirs)
+	fmt.Fprintln(w, "Most entries in a directory:", m.largest)
+	fmt.Fprintf(w, "Folder objects of up to %v entries: %v (%v more than one per directory)\n",
+		m.maxEntries, m.objects, m.objects-m.dirs)
+}
@@ -0,0 +1,145 @@
+package main
+
+// Models preparing large files of some formats before upload, such as
+// remuxing videos into segments or packing an album of FLAC tracks into one
+// archive, to show how many chunks there would be if a guide suggested it.
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	// assumed bitrate of videos, to turn minutes into bytes, which is about
+	// that of 1080p video from a phone or a streaming service
+	videoBitrate = 8 * 1000 * 1000 // bits per second
+	// tar stores each file after a 512 byte header, padded to whole blocks,
+	// and ends with two empty blocks
+	tarBlockSize = 512
+	tarEndSize   = 2 * tarBlockSize
+)
+
+var (
+	videoExtensions = []string{".mp4", ".mkv", ".mov", ".m4v", ".avi", ".webm"}
+	albumExtensions = []string{".flac"}
+)
+
+// the chunks of the files a strategy applies to, as they are and as they
+// would be if prepared
+type formatTotal struct {
+	files    int64
+	bytes    int64
+	chunks   int64
+	prepared int64
+}
+
+// formatModel counts the chunks of videos split into segments and of FLAC
+// albums packed into archives, compared with uploading them as they are.
+type formatModel struct {
+	mu             sync.Mutex
+	rules          Rules
+	segmentMinutes int64
+	videos         formatTotal
+	albums         map[string]*formatTotal // keyed by directory, prepared is the tar size
+	others         int64                   // chunks of every other file
+}
+
+func newFormatModel(rules Rules, segmentMinutes int64) *formatModel {
+	return &formatModel{
+		rules:          rules,
+		segmentMinutes: segmentMinutes,
+		albums:         map[string]*formatTotal{},
+	}
+}
+
+// the bytes in one segment of video
+func (m *formatModel) segmentBytes() int64 {
+	return m.segmentMinutes * 60 * videoBitrate / 8
+}
+
+// returns the chunks of a file of the given size, including its datamap
+func (m *formatModel) chunks(size int64) int64 {
+	return m.rules.ChunksForSize(size).Count + 1
+}
+
+// adds a file, safe to call from several scans at once
+func (m *formatModel) addFile(f file) {
+	size := f.info.Size()
+	extension := strings.ToLower(path.Ext(f.path))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case hasExtension(videoExtensions, extension):
+		m.videos.files = m.videos.files + 1
+		m.videos.bytes = m.videos.bytes + size
+		m.videos.chunks = m.videos.chunks + m.chunks(size)
+		segment := m.segmentBytes()
+		for remaining := size; ; remaining = remaining - segment {
+			if remaining <= segment {
+				m.videos.prepared = m.videos.prepared + m.chunks(remaining)
+				break
+			}
+			m.videos.prepared = m.videos.prepared + m.chunks(segment)
+		}
+	case hasExtension(albumExtensions, extension):
+		album := m.albums[path.Dir(f.path)]
+		if album == nil {
+			album = &formatTotal{prepared: tarEndSize}
+			m.albums[path.Dir(f.path)] = album
+		}
+		album.files = album.files + 1
+		album.bytes = album.bytes + size
+		album.chunks = album.chunks + m.chunks(size)
+		blocks := (size + tarBlockSize - 1) / tarBlockSize
+		album.prepared = album.prepared + tarBlockSize + blocks*tarBlockSize
+	default:
+		m.others = m.others + m.chunks(size)
+	}
+}
+
+func hasExtension(extensions []string, extension string) bool {
+	for _, e := range extensions {
+		if e == extension {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *formatModel) report(w io.Writer) {
+	albums := formatTotal{}
+	for _, album := range m.albums {
+		albums.files = albums.files + album.files
+		albums.bytes = albums.bytes + album.bytes
+		albums.chunks = albums.chunks + album.chunks
+		albums.prepared = albums.prepared + m.chunks(album.prepared)
+	}
+	fmt.Fprintln(w, "\nFormat-specific preparation")
+	fmt.Fprintln(w, "Strategy  Files  GB  Chunks now  Chunks if prepared  Change")
+	rows := []struct {
+		name  string
+		total formatTotal
+	}{
+		{fmt.Sprintf("Videos remuxed into %v minute segments", m.segmentMinutes), m.videos},
+		{fmt.Sprintf("FLAC albums as one tar each (%v albums)", len(m.albums)), albums},
+	}
+	now := m.others
+	prepared := m.others
+	for _, row := range rows {
+		fmt.Fprintf(w, "%v  %v  %f  %v  %v  %+.1f%%\n",
+			row.name,
+			row.total.files,
+			float64(row.total.bytes)/float64(OneGb),
+			row.total.chunks,
+			row.total.prepared,
+			percent(row.total.prepared-row.total.chunks, row.total.chunks))
+		now = now + row.total.chunks
+		prepared = prepared + row.total.prepared
+	}
+	fmt.Fprintf(w, "All files  %v chunks now, %v if prepared (%+.1f%%)\n",
+		now, prepared, percent(prepared-now, now))
+	fmt.Fprintf(w, "Segment sizes assume videos of %v Mbit/s\n", videoBitrate/1000/1000)
+}
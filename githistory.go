@@ -0,0 +1,149 @@
+package main
+
+// Estimates the chunks for uploading the full history of a git repository,
+// every version of every file, compared to only the latest snapshot. Blobs
+// are listed with the git command since reading packfiles needs zlib and
+// delta decoding. Identical content gives identical chunks, so each distinct
+// blob is counted once.
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// compares the chunks for the latest snapshot of a repository with those
+// for its full history
+func defineGitHistory(flags *flag.FlagSet) func() error {
+	rulesName := flags.String("rules", defaultRules, "chunking rules of a network era: "+strings.Join(ruleSetNames(), ", "))
+	return func() error {
+		rules, exists := ruleSets[*rulesName]
+		if !exists {
+			return fmt.Errorf("unknown rules %v, use one of %v", *rulesName, strings.Join(ruleSetNames(), ", "))
+		}
+		repo := "."
+		if flags.NArg() > 0 {
+			repo = flags.Arg(0)
+		}
+		if _, err := exec.LookPath("git"); err != nil {
+			return errors.New("git-history needs the git command")
+		}
+		latest, err := latestBlobs(repo)
+		if err != nil {
+			return err
+		}
+		all, err := historyBlobs(repo)
+		if err != nil {
+			return err
+		}
+		reportGitHistory(os.Stdout, rules, latest, all)
+		return nil
+	}
+}
+
+// returns the sizes of the distinct blobs in the HEAD commit, keyed by id
+func latestBlobs(repo string) (map[string]int64, error) {
+	out, err := exec.Command("git", "-C", repo, "ls-tree", "-r", "-l", "HEAD").Output()
+	if err != nil {
+		return nil, gitError(err)
+	}
+	blobs := map[string]int64{}
+	for _, line := range strings.Split(string(out), "\n") {
+		// <mode> SP <type> SP <object> SP <size> TAB <path>
+		fields := strings.Fields(strings.SplitN(line, "\t", 2)[0])
+		if len(fields) != 4 || fields[1] != "blob" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected git ls-tree output %q", line)
+		}
+		blobs[fields[2]] = size
+	}
+	return blobs, nil
+}
+
+// returns the sizes of the distinct blobs reachable from any ref, keyed by id
+func historyBlobs(repo string) (map[string]int64, error) {
+	revList := exec.Command("git", "-C", repo, "rev-list", "--objects", "--all")
+	catFile := exec.Command("git", "-C", repo, "cat-file", "--batch-check=%(objectname) %(objecttype) %(objectsize) %(rest)")
+	objects, err := revList.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	catFile.Stdin = objects
+	sizes, err := catFile.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := revList.Start(); err != nil {
+		return nil, err
+	}
+	if err := catFile.Start(); err != nil {
+		return nil, err
+	}
+	blobs := map[string]int64{}
+	scanner := bufio.NewScanner(sizes)
+	for scanner.Scan() {
+		// %(rest) is the path rev-list gave, which may contain spaces, and
+		// makes cat-file split the object name from it
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) < 3 || fields[1] != "blob" {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected git cat-file output %q", scanner.Text())
+		}
+		blobs[fields[0]] = size
+	}
+	if err := revList.Wait(); err != nil {
+		return nil, gitError(err)
+	}
+	if err := catFile.Wait(); err != nil {
+		return nil, gitError(err)
+	}
+	return blobs, scanner.Err()
+}
+
+// includes what git printed to stderr in an error from running it
+func gitError(err error) error {
+	if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("git: %v", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+// prints the chunks for the latest snapshot next to those for all history
+func reportGitHistory(w io.Writer, rules Rules, latest, all map[string]int64) {
+	results := []*Result{}
+	for _, blobs := range []map[string]int64{latest, all} {
+		r := NewResult()
+		r.Rules = rules
+		for _, size := range blobs {
+			r.AddFile(size)
+		}
+		results = append(results, r)
+	}
+	l, h := results[0], results[1]
+	fmt.Fprintln(w, "Rules:", rules.Name)
+	fmt.Fprintf(w, "%-22s %12s %12s %8s\n", "", "Latest", "History", "Change")
+	fmt.Fprintf(w, "%-22s %12v %12v %+7.1f%%\n", "Distinct files", l.Files, h.Files, percent(h.Files-l.Files, l.Files))
+	fmt.Fprintf(w, "%-22s %12.3f %12.3f %+7.1f%%\n", "GB",
+		float64(l.LargeBytes+l.SmallBytes)/float64(OneGb), float64(h.LargeBytes+h.SmallBytes)/float64(OneGb),
+		percent(h.LargeBytes+h.SmallBytes-l.LargeBytes-l.SmallBytes, l.LargeBytes+l.SmallBytes))
+	fmt.Fprintf(w, "%-22s %12v %12v %+7.1f%%\n", "Total chunks", l.TotalChunks, h.TotalChunks, percent(h.TotalChunks-l.TotalChunks, l.TotalChunks))
+	fmt.Fprintf(w, "%-22s %12v %12v %+7.1f%%\n", "Large chunks", l.LargeChunks, h.LargeChunks, percent(h.LargeChunks-l.LargeChunks, l.LargeChunks))
+	fmt.Fprintf(w, "%-22s %12v %12v %+7.1f%%\n", "Small chunks", l.SmallChunks, h.SmallChunks, percent(h.SmallChunks-l.SmallChunks, l.SmallChunks))
+	fmt.Fprintln(w, "\nHistory chunk sizes")
+	fmt.Fprintln(w, "Chunk Size  Count")
+	chunkdist.WriteHistogram(w, h.Histogram)
+}
@@ -0,0 +1,3 @@
+module github.com/iancoleman/chunk_distribution
+
+go 1.24
@@ -0,0 +1,162 @@
+package main
+
+// Projects the scanned data forward a few years, to size an upload plan for
+// data that keeps growing. Each category of file grows at its own rate, since
+// video and photo libraries grow faster than documents, and new files are
+// assumed to be like the ones already there, so a category's chunks grow
+// with its files.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	audioExtensions = []string{".mp3", ".m4a", ".aac", ".ogg", ".opus", ".wav", ".flac"}
+	photoExtensions = []string{".jpg", ".jpeg", ".png", ".heic", ".gif", ".webp", ".tif", ".tiff", ".raw", ".dng", ".cr2", ".nef", ".arw"}
+	docExtensions   = []string{".pdf", ".doc", ".docx", ".odt", ".rtf", ".txt", ".md", ".xls", ".xlsx", ".ods", ".ppt", ".pptx", ".odp"}
+)
+
+// the categories growth rates can be given for, and their rate relative to
+// the overall rate unless given
+var growthCategories = []struct {
+	name   string
+	factor float64
+}{
+	{"video", 2},
+	{"photos", 1.5},
+	{"audio", 1},
+	{"documents", 0.5},
+	{"other", 1},
+}
+
+// returns the growth category of a file
+func growthCategory(filename string) string {
+	extension := strings.ToLower(path.Ext(filename))
+	switch {
+	case hasExtension(videoExtensions, extension):
+		return "video"
+	case hasExtension(photoExtensions, extension):
+		return "photos"
+	case hasExtension(audioExtensions, extension):
+		return "audio"
+	case hasExtension(docExtensions, extension):
+		return "documents"
+	}
+	return "other"
+}
+
+// a category's yearly growth rate and the totals of its files now
+type growthTotal struct {
+	rate   float64 // growth per year, 0.2 for 20%
+	files  int64
+	chunks int64
+	bytes  int64 // network bytes of the chunks and datamaps
+}
+
+// growthModel projects the files, chunks and bytes of each category forward.
+type growthModel struct {
+	mu         sync.Mutex
+	rules      Rules
+	years      int
+	putCost    float64 // prices for projecting the cost, see costModel
+	gbCost     float64
+	categories map[string]*growthTotal
+}
+
+// parses a growth rate such as 20%/yr, 20% or 0.2
+func parseGrowthRate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "/yr"), "/year")
+	percentage := strings.HasSuffix(s, "%")
+	rate, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid growth rate %v, use eg 20%%/yr", s)
+	}
+	if percentage {
+		rate = rate / 100
+	}
+	return rate, nil
+}
+
+// returns a growth model from rates like "20%/yr,video=50%", an overall rate
+// followed by any rates for categories. Categories without a rate grow by
+// their default share of the overall rate.
+func newGrowthModel(rules Rules, rates string, years int) (*growthModel, error) {
+	if years < 1 {
+		return nil, errors.New("-years must be at least 1")
+	}
+	m := &growthModel{rules: rules, years: years, categories: map[string]*growthTotal{}}
+	parts := strings.Split(rates, ",")
+	overall, err := parseGrowthRate(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, c := range growthCategories {
+		m.categories[c.name] = &growthTotal{rate: overall * c.factor}
+		names = append(names, c.name)
+	}
+	for _, part := range parts[1:] {
+		name, rate, found := strings.Cut(part, "=")
+		c, exists := m.categories[strings.TrimSpace(name)]
+		if !found || !exists {
+			return nil, fmt.Errorf("invalid growth rate %v, use category=rate where category is one of %v",
+				part, strings.Join(names, ", "))
+		}
+		c.rate, err = parseGrowthRate(rate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// adds a file of the given size, safe to call from several scans at once
+func (m *growthModel) addFile(filename string, size int64) {
+	chunks := m.rules.ChunksForSize(size)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c := m.categories[growthCategory(filename)]
+	c.files = c.files + 1
+	c.chunks = c.chunks + chunks.Count + 1 // + 1 for datamap
+	c.bytes = c.bytes + chunks.Bytes() + chunks.DatamapSize
+}
+
+// prints each category's rate, then the projected totals for each year. With
+// prices, the cost is that of uploading all the data there is that year.
+func (m *growthModel) report(w io.Writer) {
+	fmt.Fprintln(w, "\nProjected growth")
+	fmt.Fprintln(w, "Category  Growth per year  Files  Chunks")
+	for _, c := range growthCategories {
+		t := m.categories[c.name]
+		fmt.Fprintf(w, "%v  %.1f%%  %v  %v\n", c.name, 100*t.rate, t.files, t.chunks)
+	}
+	priced := m.putCost > 0 || m.gbCost > 0
+	if priced {
+		fmt.Fprintln(w, "Year  Files  Chunks  Network GB  Cost")
+	} else {
+		fmt.Fprintln(w, "Year  Files  Chunks  Network GB")
+	}
+	for year := 0; year <= m.years; year++ {
+		var files, chunks, bytes float64
+		for _, t := range m.categories {
+			scale := math.Pow(1+t.rate, float64(year))
+			files = files + float64(t.files)*scale
+			chunks = chunks + float64(t.chunks)*scale
+			bytes = bytes + float64(t.bytes)*scale
+		}
+		gb := bytes / float64(OneGb)
+		if priced {
+			fmt.Fprintf(w, "%v  %.0f  %.0f  %f  %.4f\n", year, files, chunks, gb, chunks*m.putCost+gb*m.gbCost)
+		} else {
+			fmt.Fprintf(w, "%v  %.0f  %.0f  %f\n", year, files, chunks, gb)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+// A file with several hard links is found once for each link, but is stored
+// once on disk and would be uploaded once, so only its first link is
+// counted. Scans of several roots share the links seen, since a file can be
+// linked from more than one root.
+
+import "sync"
+
+// linkSet records the files with several hard links that have been counted.
+type linkSet struct {
+	mu     sync.Mutex
+	seen   map[string]bool // fileID of each file counted
+	filter *bloomFilter    // used instead of seen in a low memory scan, if set
+}
+
+func newLinkSet() *linkSet {
+	return &linkSet{seen: map[string]bool{}}
+}
+
+// returns a link set of a fixed size, which rarely takes a file's first link
+// for a second one
+func newLinkFilter() *linkSet {
+	return &linkSet{filter: newBloomFilter(lowMemoryBits)}
+}
+
+// returns true the first time it is given a link to a file, safe to call
+// from several scans at once
+func (s *linkSet) first(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.filter != nil {
+		return s.filter.add(id)
+	}
+	if s.seen[id] {
+		return false
+	}
+	s.seen[id] = true
+	return true
+}
@@ -0,0 +1,115 @@
+package main
+
+// Models the network being append-only: when a file is modified the new
+// chunks are uploaded but the old ones are never deleted, so storage grows
+// with every modification. Self encryption encrypts each chunk with keys
+// from the two chunks before it, so an edit changes the chunk it's in and
+// the two after, plus the datamap. Files of up to three chunks change
+// entirely.
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// chunks changed by an edit within a file, see above
+const chunksChangedPerEdit = 3
+
+// the size classes modification rates can be given for
+var sizeClasses = []string{"inline", "small", "large"}
+
+// a size class and its accumulated totals
+type historyClass struct {
+	files         int64
+	rate          float64 // modifications per file per month
+	chunksPerEdit int64   // total over files of chunks stored by one edit of each
+	bytesPerEdit  int64
+}
+
+// historyModel estimates the chunks that accumulate as files are modified.
+type historyModel struct {
+	rules  Rules
+	months int
+	// chunks for the current version of every file
+	currentChunks int64
+	classes       map[string]*historyClass
+}
+
+// returns a history model from rates like "small=2,large=0.1", in
+// modifications per file per month
+func newHistoryModel(rules Rules, rates string, months int) (*historyModel, error) {
+	m := &historyModel{rules: rules, months: months, classes: map[string]*historyClass{}}
+	for _, class := range sizeClasses {
+		m.classes[class] = &historyClass{}
+	}
+	for _, rate := range strings.Split(rates, ",") {
+		parts := strings.SplitN(rate, "=", 2)
+		class, exists := m.classes[strings.TrimSpace(parts[0])]
+		if len(parts) != 2 || !exists {
+			return nil, fmt.Errorf("invalid modification rate %v, use class=rate where class is one of %v",
+				rate, strings.Join(sizeClasses, ", "))
+		}
+		var err error
+		class.rate, err = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || class.rate < 0 {
+			return nil, fmt.Errorf("invalid modification rate %v", rate)
+		}
+	}
+	return m, nil
+}
+
+// returns the size class of a file
+func (m *historyModel) sizeClass(size int64) string {
+	if size < m.rules.MinFileSize {
+		return "inline"
+	}
+	if size <= m.rules.ChunkSize {
+		return "small"
+	}
+	return "large"
+}
+
+func (m *historyModel) addFile(size int64) {
+	class := m.classes[m.sizeClass(size)]
+	chunks := m.rules.ChunksForSize(size)
+	changed := chunks.Count
+	if changed > chunksChangedPerEdit {
+		changed = chunksChangedPerEdit
+	}
+	class.files = class.files + 1
+	m.currentChunks = m.currentChunks + chunks.Count + 1
+	class.chunksPerEdit = class.chunksPerEdit + changed + 1 // + 1 for datamap
+	bytes := chunks.Bytes() + chunks.DatamapSize
+	if changed < chunks.Count {
+		bytes = changed*chunks.Size + chunks.DatamapSize
+	}
+	class.bytesPerEdit = class.bytesPerEdit + bytes
+}
+
+func (m *historyModel) report(w io.Writer) {
+	fmt.Fprintf(w, "\nOld versions kept after %v months\n", m.months)
+	fmt.Fprintln(w, "Size class  Files  Edits/month  Chunks/month  GB/month")
+	var monthlyChunks, monthlyBytes float64
+	for _, name := range sizeClasses {
+		class := m.classes[name]
+		chunks := class.rate * float64(class.chunksPerEdit)
+		bytes := class.rate * float64(class.bytesPerEdit)
+		fmt.Fprintf(w, "%v  %v  %v  %.0f  %f\n", name, class.files, class.rate, chunks, bytes/float64(OneGb))
+		monthlyChunks = monthlyChunks + chunks
+		monthlyBytes = monthlyBytes + bytes
+	}
+	// doubling months up to the last
+	checkpoints := []int{}
+	for month := 1; month < m.months; month = month * 2 {
+		checkpoints = append(checkpoints, month)
+	}
+	checkpoints = append(checkpoints, m.months)
+	fmt.Fprintln(w, "\nMonth  Old version chunks  Old version GB  Growth")
+	for _, month := range checkpoints {
+		chunks := monthlyChunks * float64(month)
+		fmt.Fprintf(w, "%v  %.0f  %f  %+.1f%%\n", month, chunks,
+			monthlyBytes*float64(month)/float64(OneGb), 100*chunks/float64(m.currentChunks))
+	}
+}
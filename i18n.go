@@ -0,0 +1,194 @@
+package main
+
+// Translations of the report. What's translated is the report a scan prints
+// without any options, with its examples, exclusions, projects, mutable
+// data, partial downloads, disk space and usage sections, the headings of
+// the per-root and label reports, the lines saying what is being scanned,
+// and the usage line of -h. Everything else stays in English: the flag help,
+// the sections added by options such as -stats or -put-cost, the other
+// commands, and warning messages, which are saved with the result. Messages
+// are keyed by their English text, and every message passed to tr must be in
+// every catalog.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// the language of the report
+var language = "en"
+
+var catalogs = map[string]map[string]string{
+	"es": {
+		"Rules:":                                  "Reglas:",
+		"Chunk size:":                             "Tamaño de fragmento:",
+		"Total files:":                            "Archivos totales:",
+		"Files larger than %v: %v (%f GB)\n":      "Archivos de más de %v: %v (%f GB)\n",
+		"Files smaller than %v: %v (%f GB)\n":     "Archivos de menos de %v: %v (%f GB)\n",
+		"Total chunks:":                           "Fragmentos totales:",
+		"Large chunks:":                           "Fragmentos grandes:",
+		"Small chunks:":                           "Fragmentos pequeños:",
+		"Chunk Size  Count":                       "Tamaño de fragmento  Cantidad",
+		"Warnings":                                "Advertencias",
+		"Code  Subject  Message":                  "Código  Asunto  Mensaje",
+		"What if I excluded...":                   "¿Y si excluyera...?",
+		"Directory  Total chunks  Total GB":       "Directorio  Fragmentos totales  GB totales",
+		"Project  Chunks  GB":                     "Proyecto  Fragmentos  GB",
+		"Partial downloads, not counted above":    "Descargas parciales, no contadas arriba",
+		"Files:":                                  "Archivos:",
+		"Size when complete: %f GB\n":             "Tamaño al completarse: %f GB\n",
+		"Chunks when complete:":                   "Fragmentos al completarse:",
+		"Chunk Size  Example file":                "Tamaño de fragmento  Archivo de ejemplo",
+		"Things to look at":                       "Cosas a revisar",
+		"Gathering current user HomeDir stats":    "Recopilando estadísticas del directorio personal",
+		"Gathering stats for":                     "Recopilando estadísticas de",
+		"Usage:":                                  "Uso:",
+		"Scan usage":                              "Uso del escaneo",
+		"Gathering HomeDir stats for":             "Recopilando estadísticas del directorio personal de",
+		"Report for":                              "Informe de",
+		"Combined report":                         "Informe combinado",
+		"Data  Chunks  GB  Share of chunks":       "Datos  Fragmentos  GB  Parte de los fragmentos",
+		"Disk and network space":                  "Espacio en disco y en la red",
+		"On disk: %f GB\n":                        "En disco: %f GB\n",
+		"File content: %f GB (%v vs on disk)\n":   "Contenido de los archivos: %f GB (%v respecto al disco)\n",
+		"Datamaps: %f GB\n":                       "Mapas de datos: %f GB\n",
+		"On the network: %f GB (%v vs on disk)\n": "En la red: %f GB (%v respecto al disco)\n",
+		"With %v copies of each chunk: %f GB\n":   "Con %v copias de cada fragmento: %f GB\n",
+		"Chunks: %v, of which %v are datamaps and %v more than if every chunk were full, mostly from the %v chunk minimum\n": "Fragmentos: %v, de los que %v son mapas de datos y %v más que si todos los fragmentos estuvieran llenos, sobre todo por el mínimo de %v fragmentos\n",
+		"Wall time: %.1fs\n":       "Tiempo real: %.1fs\n",
+		"CPU time: %.1fs\n":        "Tiempo de CPU: %.1fs\n",
+		"Peak memory: %.1f MB\n":   "Memoria máxima: %.1f MB\n",
+		"Files per second: %.0f\n": "Archivos por segundo: %.0f\n",
+		"Directories read:":        "Directorios leídos:",
+	},
+	"de": {
+		"Rules:":                                  "Regeln:",
+		"Chunk size:":                             "Chunk-Größe:",
+		"Total files:":                            "Dateien gesamt:",
+		"Files larger than %v: %v (%f GB)\n":      "Dateien größer als %v: %v (%f GB)\n",
+		"Files smaller than %v: %v (%f GB)\n":     "Dateien kleiner als %v: %v (%f GB)\n",
+		"Total chunks:":                           "Chunks gesamt:",
+		"Large chunks:":                           "Große Chunks:",
+		"Small chunks:":                           "Kleine Chunks:",
+		"Chunk Size  Count":                       "Chunk-Größe  Anzahl",
+		"Warnings":                                "Warnungen",
+		"Code  Subject  Message":                  "Code  Betreff  Meldung",
+		"What if I excluded...":                   "Was wäre, wenn ich ausschließe...",
+		"Directory  Total chunks  Total GB":       "Verzeichnis  Chunks gesamt  GB gesamt",
+		"Project  Chunks  GB":                     "Projekt  Chunks  GB",
+		"Partial downloads, not counted above":    "Unvollständige Downloads, oben nicht gezählt",
+		"Files:":                                  "Dateien:",
+		"Size when complete: %f GB\n":             "Größe nach Abschluss: %f GB\n",
+		"Chunks when complete:":                   "Chunks nach Abschluss:",
+		"Chunk Size  Example file":                "Chunk-Größe  Beispieldatei",
+		"Things to look at":                       "Zu prüfen",
+		"Gathering current user HomeDir stats":    "Sammle Statistiken für das Home-Verzeichnis",
+		"Gathering stats for":                     "Sammle Statistiken für",
+		"Usage:":                                  "Verwendung:",
+		"Scan usage":                              "Ressourcen des Scans",
+		"Gathering HomeDir stats for":             "Sammle Statistiken des Home-Verzeichnisses von",
+		"Report for":                              "Bericht für",
+		"Combined report":                         "Gesamtbericht",
+		"Data  Chunks  GB  Share of chunks":       "Daten  Chunks  GB  Anteil der Chunks",
+		"Disk and network space":                  "Platz auf der Festplatte und im Netzwerk",
+		"On disk: %f GB\n":                        "Auf der Festplatte: %f GB\n",
+		"File content: %f GB (%v vs on disk)\n":   "Dateiinhalt: %f GB (%v gegenüber der Festplatte)\n",
+		"Datamaps: %f GB\n":                       "Datamaps: %f GB\n",
+		"On the network: %f GB (%v vs on disk)\n": "Im Netzwerk: %f GB (%v gegenüber der Festplatte)\n",
+		"With %v copies of each chunk: %f GB\n":   "Mit %v Kopien jedes Chunks: %f GB\n",
+		"Chunks: %v, of which %v are datamaps and %v more than if every chunk were full, mostly from the %v chunk minimum\n": "Chunks: %v, davon %v Datamaps und %v mehr als bei lauter vollen Chunks, meist durch das Minimum von %v Chunks\n",
+		"Wall time: %.1fs\n":       "Laufzeit: %.1fs\n",
+		"CPU time: %.1fs\n":        "CPU-Zeit: %.1fs\n",
+		"Peak memory: %.1f MB\n":   "Höchster Speicherverbrauch: %.1f MB\n",
+		"Files per second: %.0f\n": "Dateien pro Sekunde: %.0f\n",
+		"Directories read:":        "Gelesene Verzeichnisse:",
+	},
+	"zh": {
+		"Rules:":                                  "规则:",
+		"Chunk size:":                             "分块大小:",
+		"Total files:":                            "文件总数:",
+		"Files larger than %v: %v (%f GB)\n":      "大于 %v 的文件: %v (%f GB)\n",
+		"Files smaller than %v: %v (%f GB)\n":     "小于 %v 的文件: %v (%f GB)\n",
+		"Total chunks:":                           "分块总数:",
+		"Large chunks:":                           "大分块:",
+		"Small chunks:":                           "小分块:",
+		"Chunk Size  Count":                       "分块大小  数量",
+		"Warnings":                                "警告",
+		"Code  Subject  Message":                  "代码  对象  消息",
+		"What if I excluded...":                   "如果排除……",
+		"Directory  Total chunks  Total GB":       "目录  分块总数  总 GB",
+		"Project  Chunks  GB":                     "项目  分块  GB",
+		"Partial downloads, not counted above":    "未完成的下载，未计入上文",
+		"Files:":                                  "文件:",
+		"Size when complete: %f GB\n":             "完成后大小: %f GB\n",
+		"Chunks when complete:":                   "完成后分块数:",
+		"Chunk Size  Example file":                "分块大小  示例文件",
+		"Things to look at":                       "值得查看的问题",
+		"Gathering current user HomeDir stats":    "正在统计当前用户主目录",
+		"Gathering stats for":                     "正在统计",
+		"Usage:":                                  "用法:",
+		"Scan usage":                              "扫描资源使用",
+		"Gathering HomeDir stats for":             "正在统计主目录，用户",
+		"Report for":                              "报告:",
+		"Combined report":                         "合并报告",
+		"Data  Chunks  GB  Share of chunks":       "数据  分块  GB  分块占比",
+		"Disk and network space":                  "磁盘和网络空间",
+		"On disk: %f GB\n":                        "磁盘上: %f GB\n",
+		"File content: %f GB (%v vs on disk)\n":   "文件内容: %f GB (相对磁盘 %v)\n",
+		"Datamaps: %f GB\n":                       "数据映射: %f GB\n",
+		"On the network: %f GB (%v vs on disk)\n": "网络上: %f GB (相对磁盘 %v)\n",
+		"With %v copies of each chunk: %f GB\n":   "每个分块 %v 份副本: %f GB\n",
+		"Chunks: %v, of which %v are datamaps and %v more than if every chunk were full, mostly from the %v chunk minimum\n": "分块: %v，其中 %v 个是数据映射，比所有分块都填满时多 %v 个，主要来自最少 %v 个分块的规则\n",
+		"Wall time: %.1fs\n":       "实际用时: %.1fs\n",
+		"CPU time: %.1fs\n":        "CPU 时间: %.1fs\n",
+		"Peak memory: %.1f MB\n":   "内存峰值: %.1f MB\n",
+		"Files per second: %.0f\n": "每秒文件数: %.0f\n",
+		"Directories read:":        "已读取目录:",
+	},
+}
+
+// returns the translation of an English message in the current language
+func tr(message string) string {
+	if translated, exists := catalogs[language][message]; exists {
+		return translated
+	}
+	return message
+}
+
+// sets the language, which is en or one with a catalog
+func setLanguage(lang string) error {
+	if _, exists := catalogs[lang]; lang != "en" && !exists {
+		return fmt.Errorf("unknown language %v, use one of %v", lang, strings.Join(languageNames(), ", "))
+	}
+	language = lang
+	return nil
+}
+
+// returns the languages that can be used, sorted
+func languageNames() []string {
+	names := []string{"en"}
+	for name := range catalogs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns the value of a -lang flag in args, so the language is known
+// before the flags are parsed, for the usage line of -h
+func langFromArgs(args []string) string {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if name == arg || name == "" {
+			continue
+		}
+		if strings.HasPrefix(name, "lang=") {
+			return strings.TrimPrefix(name, "lang=")
+		}
+		if name == "lang" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return "en"
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// the files in a directory listing entries to leave out of a scan, read
+// with -ignore-files
+var ignoreFileNames = []string{".gitignore", ".chunkdistignore"}
+
+// one line of an ignore file
+type ignoreRule struct {
+	re      *regexp.Regexp // matched against the path relative to the directory of the file
+	negate  bool           // the line started with !, so matching entries are kept
+	dirOnly bool           // the line ended with /, so only directories match
+}
+
+// the rules of the ignore files in a directory, on top of those of the
+// directories above it
+type ignoreSet struct {
+	base   string
+	rules  []ignoreRule
+	parent *ignoreSet
+}
+
+// reads the ignore files in a directory, returning the parent set if there
+// are none
+func loadIgnoreFiles(dirname string, parent *ignoreSet) *ignoreSet {
+	rules := []ignoreRule{}
+	for _, name := range ignoreFileNames {
+		f, err := os.Open(path.Join(dirname, name))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			rule, ok := parseIgnoreLine(scanner.Text())
+			if ok {
+				rules = append(rules, rule)
+			}
+		}
+		f.Close()
+	}
+	if len(rules) == 0 {
+		return parent
+	}
+	return &ignoreSet{dirname, rules, parent}
+}
+
+// parses a line of an ignore file in the .gitignore format, returning false
+// for blank lines, comments and patterns that can't be read
+func parseIgnoreLine(line string) (ignoreRule, bool) {
+	rule := ignoreRule{}
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimSuffix(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+	// a pattern with a slash other than at the end is relative to the
+	// directory of the ignore file, otherwise it matches at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr := globToRegexp(line)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(.*/)?" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return rule, false
+	}
+	rule.re = re
+	return rule, true
+}
+
+// converts a glob with ** for any number of directories to a regular
+// expression
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i = i + 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			b.WriteString("(/.*)?")
+			i = i + 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i = i + 1
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i = i + end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// tells if a file or directory is ignored. The nearest ignore file with a
+// matching line decides, and within a file the last matching line wins, so
+// a ! line can bring back something ignored further up.
+func (s *ignoreSet) ignored(filename string, isDir bool) bool {
+	for ; s != nil; s = s.parent {
+		rel := strings.TrimPrefix(filename, s.base+"/")
+		for i := len(s.rules) - 1; i >= 0; i-- {
+			rule := s.rules[i]
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.re.MatchString(rel) {
+				return !rule.negate
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,347 @@
+package main
+
+// Imports file size listings made by other tools, so users who already have
+// an inventory of their files can get a chunk report without scanning again.
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the formats that can be imported
+const importFormats = "du, find, ncdu, rsync, windirstat, treesize"
+
+// a file in an imported listing
+type importEntry struct {
+	path string
+	size int64
+}
+
+// reports the distribution for a listing made by another tool
+func defineImport(flags *flag.FlagSet) func() error {
+	format := flags.String("format", "", "format of the listing: "+importFormats)
+	blockSize := flags.Int64("block-size", OneKb, "bytes per unit of du sizes, use 1 for du -ab")
+	rulesName := flags.String("rules", defaultRules, "chunking rules of a network era: "+strings.Join(ruleSetNames(), ", "))
+	chunkSize := flags.String("chunk-size", "", "override the chunk size of the rules, eg 512K or 4M")
+	minFileSize := flags.String("min-file-size", "", "override the size below which files are stored in the datamap, eg 1K")
+	exact := flags.Bool("exact", false, "split files exactly as self_encryption does, with its equal split below three full chunks and its minimum chunk size")
+	save := flags.String("save", "", "file to save the result to as json")
+	caseInsensitive := flags.Bool("case-insensitive", false, "count paths that differ only by case once, as on a case-insensitive filesystem, and report them")
+	return func() error {
+		if flags.NArg() != 1 {
+			return errors.New("usage: import -format " + importFormats + " listing.txt, or - for stdin")
+		}
+		rules, exists := ruleSets[*rulesName]
+		if !exists {
+			return fmt.Errorf("unknown rules %v, use one of %v", *rulesName, strings.Join(ruleSetNames(), ", "))
+		}
+		rules, err := customRules(rules, *chunkSize, *minFileSize, *exact)
+		if err != nil {
+			return err
+		}
+		f := os.Stdin
+		if flags.Arg(0) != "-" {
+			var err error
+			f, err = os.Open(flags.Arg(0))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+		}
+		var entries []importEntry
+		switch *format {
+		case "du":
+			entries, err = readSizeListing(f, *blockSize, "\t")
+		case "find":
+			entries, err = readSizeListing(f, 1, " ")
+		case "ncdu":
+			entries, err = readNcdu(f)
+		case "rsync":
+			entries, err = readRsync(f)
+		case "windirstat", "treesize":
+			entries, err = readSizeCSV(f)
+		default:
+			return fmt.Errorf("unknown format %v, use one of %v", *format, importFormats)
+		}
+		if err != nil {
+			return err
+		}
+		var collisions []caseCollision
+		if *caseInsensitive {
+			entries, collisions = foldCase(entries)
+		}
+		r := importResult(entries, rules)
+		r.Warnings = append(r.Warnings, overflowWarnings(r, flags.Arg(0))...)
+		r.Report(os.Stdout)
+		if *caseInsensitive {
+			reportCaseCollisions(os.Stdout, collisions)
+		}
+		if *save != "" {
+			machineID, err := localMachineID()
+			if err != nil {
+				return err
+			}
+			return saveResult(*save, MachineResult{MachineID: machineID, Scanned: time.Now(), Result: r}, "")
+		}
+		return nil
+	}
+}
+
+// returns the result for the imported files, with top level directories
+// relative to the deepest directory containing every file
+func importResult(entries []importEntry, rules Rules) *Result {
+	r := NewResult()
+	r.Rules = rules
+	root := commonDir(entries)
+	for _, e := range entries {
+		r.AddFile(e.size)
+		chunks := r.Rules.ChunksForSize(e.size).Count + 1 // + 1 for datamap
+		extension := r.Extensions[fileExtension(e.path)]
+		extension.Chunks = extension.Chunks + chunks
+		extension.Bytes = extension.Bytes + e.size
+		r.Extensions[fileExtension(e.path)] = extension
+		rel := relativeTo(root, e.path)
+		i := strings.Index(rel, "/")
+		if i < 0 {
+			continue
+		}
+		total := r.Dirs[rel[:i]]
+		total.Chunks = total.Chunks + chunks
+		total.Bytes = total.Bytes + e.size
+		r.Dirs[rel[:i]] = total
+	}
+	return r
+}
+
+// returns the deepest directory containing every entry, with the paths
+// cleaned so find's ./ prefix doesn't count as a directory
+func commonDir(entries []importEntry) string {
+	if len(entries) == 0 {
+		return ""
+	}
+	common := path.Dir(entries[0].path)
+	for _, e := range entries[1:] {
+		for common != "/" && common != "." && !strings.HasPrefix(path.Clean(e.path), common+"/") {
+			common = path.Dir(common)
+		}
+	}
+	return common
+}
+
+// returns the path of an entry relative to a directory from commonDir
+func relativeTo(root, name string) string {
+	name = path.Clean(name)
+	switch root {
+	case ".":
+		return name
+	case "/":
+		return strings.TrimPrefix(name, "/")
+	}
+	return strings.TrimPrefix(name, root+"/")
+}
+
+// reads the output of rsync --list-only, where each line is the
+// permissions, size, date, time and path. Only regular files are kept, and
+// lines that aren't files, like the module list of an rsync daemon, are
+// skipped. Sizes may have digit grouping commas but must not be
+// human readable (-h).
+func readRsync(r io.Reader) ([]importEntry, error) {
+	entries := []importEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*OneKb), OneMb)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if !strings.HasPrefix(text, "-") {
+			continue
+		}
+		// the path is whatever follows the fourth field, and may contain spaces
+		fields := strings.Fields(text)
+		if len(fields) < 5 {
+			return nil, fmt.Errorf("line %v: expected permissions, size, date, time and path", line)
+		}
+		size, err := strconv.ParseInt(strings.Replace(fields[1], ",", "", -1), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		rest := text
+		for _, field := range fields[:4] {
+			rest = strings.TrimLeft(rest[strings.Index(rest, field)+len(field):], " ")
+		}
+		entries = append(entries, importEntry{path.Clean("/" + rest), size})
+	}
+	return entries, scanner.Err()
+}
+
+// removes directories from a listing, which are the entries that are the
+// parent of another entry, since du lists directories with their total size
+func filesOnly(entries []importEntry) []importEntry {
+	parents := map[string]bool{}
+	for _, e := range entries {
+		parents[path.Dir(e.path)] = true
+	}
+	files := []importEntry{}
+	for _, e := range entries {
+		if !parents[e.path] {
+			files = append(files, e)
+		}
+	}
+	return files
+}
+
+// reads lines of a size followed by a path, as written by du -a and
+// find -printf '%s %p\n'
+func readSizeListing(r io.Reader, unit int64, sep string) ([]importEntry, error) {
+	entries := []importEntry{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*OneKb), OneMb)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimLeft(text, " "), sep, 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %v: expected a size and a path", line)
+		}
+		size, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %v: %v", line, err)
+		}
+		entries = append(entries, importEntry{path.Clean(parts[1]), size * unit})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return filesOnly(entries), nil
+}
+
+// reads an ncdu json export, as written by ncdu -o
+func readNcdu(r io.Reader) ([]importEntry, error) {
+	var export []json.RawMessage
+	if err := json.NewDecoder(r).Decode(&export); err != nil {
+		return nil, err
+	}
+	if len(export) < 4 {
+		return nil, errors.New("not an ncdu export")
+	}
+	entries := []importEntry{}
+	err := readNcduDir(export[3], "", &entries)
+	return entries, err
+}
+
+// reads an ncdu directory, which is an array of the directory info followed
+// by its files and subdirectories
+func readNcduDir(raw json.RawMessage, parent string, entries *[]importEntry) error {
+	type info struct {
+		Name     string `json:"name"`
+		Asize    int64  `json:"asize"`
+		Excluded string `json:"excluded"`
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil || len(items) == 0 {
+		return errors.New("invalid ncdu directory")
+	}
+	var dir info
+	if err := json.Unmarshal(items[0], &dir); err != nil {
+		return err
+	}
+	dirname := path.Join(parent, dir.Name)
+	for _, item := range items[1:] {
+		if strings.HasPrefix(strings.TrimSpace(string(item)), "[") {
+			if err := readNcduDir(item, dirname, entries); err != nil {
+				return err
+			}
+			continue
+		}
+		var f info
+		if err := json.Unmarshal(item, &f); err != nil {
+			return err
+		}
+		if f.Excluded == "" {
+			*entries = append(*entries, importEntry{path.Join(dirname, f.Name), f.Asize})
+		}
+	}
+	return nil
+}
+
+// reads a csv export with a path column and a size column, as written by
+// WinDirStat and TreeSize. Lines before the header row are skipped, since
+// TreeSize writes a summary there.
+func readSizeCSV(r io.Reader) ([]importEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	pathCol, sizeCol := -1, -1
+	entries := []importEntry{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if pathCol < 0 {
+			pathCol, sizeCol = csvColumns(record)
+			continue
+		}
+		if pathCol >= len(record) || sizeCol >= len(record) {
+			continue
+		}
+		size, err := parseListingSize(record[sizeCol])
+		if err != nil {
+			return nil, err
+		}
+		filename := strings.ReplaceAll(record[pathCol], "\\", "/")
+		filename = strings.TrimSuffix(filename, "/")
+		entries = append(entries, importEntry{filename, size})
+	}
+	if pathCol < 0 {
+		return nil, errors.New("no header row with path and size columns found")
+	}
+	return filesOnly(entries), nil
+}
+
+// returns the path and size columns of a header row, or -1 if it isn't one
+func csvColumns(header []string) (int, int) {
+	pathCol, sizeCol := -1, -1
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "full path", "path", "name":
+			if pathCol < 0 || name != "name" {
+				pathCol = i
+			}
+		case "size", "size (bytes)", "logical size":
+			if sizeCol < 0 {
+				sizeCol = i
+			}
+		}
+	}
+	if pathCol < 0 || sizeCol < 0 {
+		return -1, -1
+	}
+	return pathCol, sizeCol
+}
+
+// parses a size which may have thousands separators and a unit, eg
+// "1,234,567" or "1.2 MB"
+func parseListingSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "BYTES"), "BYTE")
+	s = strings.ReplaceAll(strings.ReplaceAll(s, ",", ""), " ", "")
+	if s == "" {
+		return 0, nil
+	}
+	return parseSize(s)
+}
@@ -0,0 +1,125 @@
+package main
+
+// Splits a shared machine's data by label, such as work and personal, so
+// each label can be uploaded under a different account. Each label is a list
+// of patterns, and a file takes the first label with a matching pattern.
+// Files matching none are the shared remainder.
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// the label of files matching no label
+const sharedLabel = "shared"
+
+// a label and the patterns of the files it covers
+type label struct {
+	name     string
+	patterns patternList
+}
+
+// labelList is a flag.Value of labels, each given as name=patterns, such as
+// work=Work,re:^Documents/clients/
+type labelList []label
+
+func (l *labelList) String() string {
+	if l == nil {
+		return ""
+	}
+	texts := []string{}
+	for _, lb := range *l {
+		texts = append(texts, lb.name+"="+lb.patterns.String())
+	}
+	return strings.Join(texts, " ")
+}
+
+func (l *labelList) Set(value string) error {
+	name, patterns, found := strings.Cut(value, "=")
+	if !found || name == "" || patterns == "" {
+		return errors.New("use name=patterns, eg work=Work,*.docx")
+	}
+	if name == sharedLabel {
+		return fmt.Errorf("%v is the label of files matching no other label", sharedLabel)
+	}
+	lb := label{name: name}
+	if err := lb.patterns.Set(patterns); err != nil {
+		return err
+	}
+	*l = append(*l, lb)
+	return nil
+}
+
+// labelModel keeps a result for each label.
+type labelModel struct {
+	mu      sync.Mutex
+	labels  labelList
+	results map[string]*Result
+}
+
+func newLabelModel(rules Rules, labels labelList) *labelModel {
+	m := &labelModel{labels: labels, results: map[string]*Result{}}
+	for _, name := range m.names() {
+		r := NewResult()
+		r.Rules = rules
+		m.results[name] = r
+	}
+	return m
+}
+
+// returns the labels in the order given, then the shared remainder
+func (m *labelModel) names() []string {
+	names := []string{}
+	for _, lb := range m.labels {
+		names = append(names, lb.name)
+	}
+	return append(names, sharedLabel)
+}
+
+// returns the label of a file given its path relative to the scanned
+// directory
+func (m *labelModel) label(rel string) string {
+	for _, lb := range m.labels {
+		if lb.patterns.matches(rel) {
+			return lb.name
+		}
+	}
+	return sharedLabel
+}
+
+// adds a file of the given size to a label, and to the total for its top
+// level directory, safe to call from several scans at once
+func (m *labelModel) addFile(name, rel string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.results[name]
+	r.AddFile(size)
+	if dir, _, found := strings.Cut(rel, "/"); found {
+		total := r.Dirs[dir]
+		total.Chunks = total.Chunks + r.Rules.ChunksForSize(size).Count + 1 // + 1 for datamap
+		total.Bytes = total.Bytes + size
+		r.Dirs[dir] = total
+	}
+}
+
+// prints a table of the labels, then the full report for each
+func (m *labelModel) report(w io.Writer) {
+	var chunks int64
+	for _, r := range m.results {
+		chunks = chunks + r.TotalChunks
+	}
+	fmt.Fprintln(w, "\nLabels")
+	fmt.Fprintln(w, "Label  Files  Chunks  GB  Share of chunks")
+	for _, name := range m.names() {
+		r := m.results[name]
+		fmt.Fprintf(w, "%v  %v  %v  %f  %.1f%%\n",
+			name, r.Files, r.TotalChunks, float64(r.LargeBytes+r.SmallBytes)/float64(OneGb), percent(r.TotalChunks, chunks))
+	}
+	for _, name := range m.names() {
+		fmt.Fprintln(w, "\n"+tr("Report for"), "label", name)
+		m.results[name].Report(w)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+// -low-memory suits small devices such as ARM NAS boxes with 512 MB, where a
+// scan of a large share can run out of memory. It keeps only fixed size
+// sketches where a scan would otherwise remember something for every
+// directory or hard linked file, caps the workers, and asks the garbage
+// collector to keep the heap under a limit. Options that remember every file
+// can't be used with it.
+
+import (
+	"hash/fnv"
+	"runtime/debug"
+)
+
+const (
+	// the heap size the garbage collector works to stay under
+	lowMemoryLimit = 256 * OneMb
+	// the most directories read at the same time, each holding its listing
+	lowMemoryWorkers = 2
+	// the size of the sketches, in counters and bits
+	lowMemoryCounters = 1 << 18
+	lowMemoryBits     = 1 << 24
+	// hashes per item in the sketches
+	sketchHashes = 4
+)
+
+// sets the runtime up for a low memory scan
+func startLowMemory() {
+	debug.SetMemoryLimit(lowMemoryLimit)
+}
+
+// returns the indexes of an item in a sketch of the given size, by double
+// hashing one fnv hash
+func sketchIndexes(item string, size uint64) [sketchHashes]uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+	var indexes [sketchHashes]uint64
+	for i := range indexes {
+		indexes[i] = (h1 + uint64(i)*h2) % size
+	}
+	return indexes
+}
+
+// countMinSketch counts items in a fixed amount of memory. Counts can be
+// too high, when other items share all of an item's counters, but never too
+// low.
+type countMinSketch struct {
+	counters []uint32
+}
+
+func newCountMinSketch(size int) *countMinSketch {
+	return &countMinSketch{counters: make([]uint32, size)}
+}
+
+// adds one to an item's count and returns its new estimated count
+func (s *countMinSketch) add(item string) int64 {
+	estimate := uint32(0)
+	for i, index := range sketchIndexes(item, uint64(len(s.counters))) {
+		if s.counters[index] < ^uint32(0) {
+			s.counters[index] = s.counters[index] + 1
+		}
+		if i == 0 || s.counters[index] < estimate {
+			estimate = s.counters[index]
+		}
+	}
+	return int64(estimate)
+}
+
+// bloomFilter records items in a fixed amount of memory. An item not added
+// can be reported as added, rarely, but an added item never is not.
+type bloomFilter struct {
+	bits []uint64
+}
+
+func newBloomFilter(bits int) *bloomFilter {
+	return &bloomFilter{bits: make([]uint64, bits/64)}
+}
+
+// adds an item, returning false if it may have been added before
+func (f *bloomFilter) add(item string) bool {
+	seen := true
+	for _, index := range sketchIndexes(item, uint64(len(f.bits))*64) {
+		word, bit := index/64, uint64(1)<<(index%64)
+		if f.bits[word]&bit == 0 {
+			seen = false
+			f.bits[word] = f.bits[word] | bit
+		}
+	}
+	return !seen
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// returns the anonymous id of this machine, creating it the first time.
+// Results carry this id instead of the hostname, so scans from the same
+// machine can be recognised without saying which machine it is.
+func localMachineID() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	filename := filepath.Join(dir, "chunk_distribution", "machine-id")
+	data, err := ioutil.ReadFile(filename)
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(random)
+	if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+		return "", err
+	}
+	return id, ioutil.WriteFile(filename, []byte(id+"\n"), 0600)
+}
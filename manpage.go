@@ -0,0 +1,95 @@
+package main
+
+// Generates the long help and the man page from the commands and their
+// flags, so both stay in step with the code.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// prints every command with its usage line and flags
+func writeHelpAll(w io.Writer) {
+	for i, c := range commands {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, c.usage())
+		fmt.Fprintln(w, "  "+c.summary)
+		flags := c.flags()
+		flags.SetOutput(w)
+		flags.PrintDefaults()
+	}
+}
+
+// writes the man page
+func defineMan(flags *flag.FlagSet) func() error {
+	output := flags.String("o", "", "file to write the man page to, or stdout if not set")
+	return func() error {
+		if flags.NArg() != 0 {
+			return errors.New("usage: man [-o chunk_distribution.1]")
+		}
+		if *output == "" {
+			writeManPage(os.Stdout)
+			return nil
+		}
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		writeManPage(f)
+		return f.Close()
+	}
+}
+
+// writes the man page in troff, with a section for each command
+func writeManPage(w io.Writer) {
+	fmt.Fprintf(w, ".TH CHUNK_DISTRIBUTION 1 \"\" \"chunk_distribution %v\" \"User Commands\"\n", version)
+	fmt.Fprintln(w, ".SH NAME")
+	fmt.Fprintln(w, `chunk_distribution \- report the chunk sizes for files uploaded to the SAFE network`)
+	fmt.Fprintln(w, ".SH SYNOPSIS")
+	for i, c := range commands {
+		if i > 0 {
+			fmt.Fprintln(w, ".br")
+		}
+		fmt.Fprintln(w, ".B chunk_distribution")
+		fmt.Fprintln(w, manEscape(strings.TrimPrefix(c.usage(), "chunk_distribution ")))
+	}
+	fmt.Fprintln(w, ".SH DESCRIPTION")
+	fmt.Fprintln(w, "Files are split into chunks before being uploaded. chunk_distribution")
+	fmt.Fprintln(w, "reports how many chunks of each size the files in a directory would")
+	fmt.Fprintln(w, "make, scanning the home directory if no directory is given.")
+	fmt.Fprintln(w, ".SH COMMANDS")
+	for _, c := range commands {
+		fmt.Fprintf(w, ".SS \"%v\"\n", manEscape(c.usage()))
+		fmt.Fprintln(w, manEscape(c.summary))
+		c.flags().VisitAll(func(f *flag.Flag) {
+			valueName, usage := flag.UnquoteUsage(f)
+			fmt.Fprintln(w, ".TP")
+			if valueName == "" {
+				fmt.Fprintf(w, ".B \\-%v\n", f.Name)
+			} else {
+				fmt.Fprintf(w, ".BI \\-%v \" %v\"\n", f.Name, valueName)
+			}
+			if f.DefValue != "" && f.DefValue != "false" && f.DefValue != "0" {
+				usage = fmt.Sprintf("%v (default %v)", usage, f.DefValue)
+			}
+			fmt.Fprintln(w, manEscape(usage))
+		})
+	}
+	fmt.Fprintln(w, ".SH SEE ALSO")
+	fmt.Fprintln(w, "https://github.com/iancoleman/chunk_distribution")
+}
+
+// escapes text for troff
+func manEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
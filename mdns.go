@@ -0,0 +1,267 @@
+package main
+
+// A minimal mDNS implementation, just enough for agents to advertise
+// themselves and for a collector to find them on the local network.
+// Agents answer PTR queries for the chunk_distribution service with a PTR
+// record for their instance and an SRV record for the port their results are
+// served on. The collector queries from an ephemeral port so responses are
+// sent back to it directly (legacy unicast, RFC 6762 section 6.7) and uses
+// the source address of each response as the address of the agent. A TXT
+// record tells the collector whether the agent serves its result over TLS.
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the mDNS service name agents advertise
+const mdnsService = "_chunkdist._tcp.local."
+
+var mdnsGroup = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+const (
+	dnsTypePTR = 12
+	dnsTypeTXT = 16
+	dnsTypeSRV = 33
+	dnsTypeANY = 255
+	dnsClassIN = 1
+	dnsTTL     = 120
+)
+
+// an agent found by mDNS
+type mdnsAgent struct {
+	instance string
+	addr     string
+	tls      bool
+}
+
+// a dns resource record
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	data  []byte
+}
+
+// appends a dns name to the message
+func appendName(msg []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		if len(label) > 63 {
+			label = label[:63]
+		}
+		msg = append(msg, byte(len(label)))
+		msg = append(msg, label...)
+	}
+	return append(msg, 0)
+}
+
+// reads a possibly compressed dns name starting at offset, returning the name
+// and the offset following it
+func readName(msg []byte, offset int) (string, int, error) {
+	labels := []string{}
+	end := -1
+	for jumps := 0; ; jumps++ {
+		if offset >= len(msg) || jumps > 64 {
+			return "", 0, errors.New("invalid dns name")
+		}
+		length := int(msg[offset])
+		switch {
+		case length == 0:
+			if end < 0 {
+				end = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", end, nil
+		case length&0xC0 == 0xC0:
+			if offset+1 >= len(msg) {
+				return "", 0, errors.New("invalid dns name pointer")
+			}
+			if end < 0 {
+				end = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:]) & 0x3FFF)
+		default:
+			if offset+1+length > len(msg) {
+				return "", 0, errors.New("invalid dns label")
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+length]))
+			offset = offset + 1 + length
+		}
+	}
+}
+
+// appends a resource record to the message
+func appendRecord(msg []byte, name string, rtype uint16, data []byte) []byte {
+	msg = appendName(msg, name)
+	msg = binary.BigEndian.AppendUint16(msg, rtype)
+	msg = binary.BigEndian.AppendUint16(msg, dnsClassIN)
+	msg = binary.BigEndian.AppendUint32(msg, dnsTTL)
+	msg = binary.BigEndian.AppendUint16(msg, uint16(len(data)))
+	return append(msg, data...)
+}
+
+// returns a query for agents
+func mdnsQuery(id uint16) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[4:], 1) // questions
+	msg = appendName(msg, mdnsService)
+	msg = binary.BigEndian.AppendUint16(msg, dnsTypePTR)
+	return binary.BigEndian.AppendUint16(msg, dnsClassIN)
+}
+
+// returns the response advertising an agent instance served on port
+func mdnsResponse(id uint16, instance string, port int, useTLS bool) []byte {
+	instanceName := instance + "." + mdnsService
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg[0:], id)
+	binary.BigEndian.PutUint16(msg[2:], 0x8400) // response, authoritative
+	binary.BigEndian.PutUint16(msg[6:], 1)      // answers
+	binary.BigEndian.PutUint16(msg[10:], 2)     // additional records
+	msg = appendRecord(msg, mdnsService, dnsTypePTR, appendName(nil, instanceName))
+	srv := make([]byte, 6)
+	binary.BigEndian.PutUint16(srv[4:], uint16(port))
+	srv = appendName(srv, instance+".local.")
+	msg = appendRecord(msg, instanceName, dnsTypeSRV, srv)
+	txt := "tls=0"
+	if useTLS {
+		txt = "tls=1"
+	}
+	return appendRecord(msg, instanceName, dnsTypeTXT, append([]byte{byte(len(txt))}, txt...))
+}
+
+// parses a dns message, returning the id, the question names and the
+// resource records
+func parseDNS(msg []byte) (uint16, []string, []dnsRecord, error) {
+	if len(msg) < 12 {
+		return 0, nil, nil, errors.New("dns message too short")
+	}
+	id := binary.BigEndian.Uint16(msg[0:])
+	qdcount := int(binary.BigEndian.Uint16(msg[4:]))
+	rrcount := int(binary.BigEndian.Uint16(msg[6:])) +
+		int(binary.BigEndian.Uint16(msg[8:])) +
+		int(binary.BigEndian.Uint16(msg[10:]))
+	offset := 12
+	questions := []string{}
+	for i := 0; i < qdcount; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if next+4 > len(msg) {
+			return 0, nil, nil, errors.New("dns question too short")
+		}
+		qtype := binary.BigEndian.Uint16(msg[next:])
+		if qtype == dnsTypePTR || qtype == dnsTypeANY {
+			questions = append(questions, name)
+		}
+		offset = next + 4
+	}
+	records := []dnsRecord{}
+	for i := 0; i < rrcount; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if next+10 > len(msg) {
+			return 0, nil, nil, errors.New("dns record too short")
+		}
+		rtype := binary.BigEndian.Uint16(msg[next:])
+		length := int(binary.BigEndian.Uint16(msg[next+8:]))
+		start := next + 10
+		if start+length > len(msg) {
+			return 0, nil, nil, errors.New("dns record data too short")
+		}
+		records = append(records, dnsRecord{name, rtype, msg[start : start+length]})
+		offset = start + length
+	}
+	return id, questions, records, nil
+}
+
+// answers mDNS queries for agents until the connection fails
+func mdnsAdvertise(instance string, port int, useTLS bool) error {
+	conn, err := net.ListenMulticastUDP("udp4", nil, mdnsGroup)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+		id, questions, _, err := parseDNS(buf[:n])
+		if err != nil {
+			continue
+		}
+		for _, q := range questions {
+			if strings.EqualFold(q, mdnsService) {
+				response := mdnsResponse(id, instance, port, useTLS)
+				if from.Port == mdnsGroup.Port {
+					conn.WriteToUDP(response, mdnsGroup)
+				} else {
+					conn.WriteToUDP(response, from)
+				}
+				break
+			}
+		}
+	}
+}
+
+// queries the local network for agents, collecting responses until the
+// timeout
+func mdnsDiscover(timeout time.Duration) ([]mdnsAgent, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	id := uint16(time.Now().UnixNano())
+	if _, err := conn.WriteToUDP(mdnsQuery(id), mdnsGroup); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	agents := []mdnsAgent{}
+	found := map[string]bool{}
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return agents, nil
+			}
+			return agents, err
+		}
+		msg := buf[:n]
+		_, _, records, err := parseDNS(msg)
+		if err != nil {
+			continue
+		}
+		suffix := "." + mdnsService
+		useTLS := map[string]bool{}
+		for _, r := range records {
+			if r.rtype == dnsTypeTXT && string(r.data) == "\x05tls=1" {
+				useTLS[strings.ToLower(r.name)] = true
+			}
+		}
+		for _, r := range records {
+			if r.rtype != dnsTypeSRV || len(r.data) < 6 || !strings.HasSuffix(strings.ToLower(r.name), suffix) {
+				continue
+			}
+			port := int(binary.BigEndian.Uint16(r.data[4:]))
+			addr := net.JoinHostPort(from.IP.String(), strconv.Itoa(port))
+			if found[addr] {
+				continue
+			}
+			found[addr] = true
+			instance := r.name[:len(r.name)-len(suffix)]
+			agents = append(agents, mdnsAgent{instance, addr, useTLS[strings.ToLower(r.name)]})
+		}
+	}
+}
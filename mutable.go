@@ -0,0 +1,41 @@
+package main
+
+// Data that changes often, such as databases, mailboxes and logs, is better
+// stored in mutable data structures than as immutable chunks, which would
+// upload a new copy for every change. Files matching a pattern are counted
+// as mutable and the rest as static.
+
+import (
+	"fmt"
+	"io"
+)
+
+// the patterns of mutable files used by default
+const defaultMutablePatterns = "*.log,*.db,*.sqlite,*.sqlite3,*.pst,*.ost,*.mbox,*.vmdk,*.qcow2,.git"
+
+// the names of the classes of data
+const (
+	mutableClass = "mutable"
+	staticClass  = "static"
+)
+
+// returns the class of a file given its path relative to the scanned
+// directory
+func dataClass(rel string, patterns patternList) string {
+	if patterns.matches(rel) {
+		return mutableClass
+	}
+	return staticClass
+}
+
+// prints the chunks and bytes of mutable and static data
+func reportMutable(w io.Writer, r *Result) {
+	if len(r.Classes) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\n"+tr("Data  Chunks  GB  Share of chunks"))
+	for _, class := range []string{mutableClass, staticClass} {
+		total := r.Classes[class]
+		fmt.Fprintf(w, "%v  %v  %f  %.1f%%\n", class, total.Chunks, float64(total.Bytes)/float64(OneGb), percent(total.Chunks, r.TotalChunks))
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+// Models the naming objects needed to publish each top level directory
+// under its own public name with the name resolution service (NRS). Each
+// public name needs an NRS map pointing at a files container, and the files
+// container lists every file in the folder, so it is stored as chunks like
+// any other file.
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+)
+
+// the typical size in bytes of a files container entry for one file, its
+// path, metadata and the address of its datamap
+const containerEntryBytes = 256
+
+// the naming objects for one published folder
+type namedFolder struct {
+	files   int64
+	chunks  int64 // content chunks
+	objects int64 // naming objects, the NRS map and the files container chunks
+}
+
+// counts the naming objects for each top level directory
+type namingModel struct {
+	mu      sync.Mutex
+	rules   Rules
+	folders map[string]namedFolder
+}
+
+func newNamingModel(rules Rules) *namingModel {
+	return &namingModel{rules: rules, folders: map[string]namedFolder{}}
+}
+
+// adds a top level directory of a root, safe to call from several scans at
+// once
+func (m *namingModel) addFolder(root, name string, files, chunks int64) {
+	container := m.rules.ChunksForSize(files*containerEntryBytes).Count + 1 // + 1 for datamap
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.folders[path.Join(root, name)] = namedFolder{
+		files:   files,
+		chunks:  chunks,
+		objects: 1 + container, // 1 for the NRS map
+	}
+}
+
+// prints the content chunks and naming objects for each folder
+func (m *namingModel) report(w io.Writer) {
+	names := []string{}
+	for name := range m.folders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var chunks, objects int64
+	fmt.Fprintln(w, "\nPublic name  Files  Content chunks  Naming objects")
+	for _, name := range names {
+		f := m.folders[name]
+		fmt.Fprintf(w, "%v  %v  %v  %v\n", name, f.files, f.chunks, f.objects)
+		chunks = chunks + f.chunks
+		objects = objects + f.objects
+	}
+	fmt.Fprintf(w, "Naming objects: %v, adding %.2f%% to the content chunks\n", objects, percent(objects, chunks))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// NetworkStats is the published chunk distribution of the whole network.
+type NetworkStats struct {
+	Source      string          `json:"source"`       // who published the stats, eg a network explorer
+	Published   time.Time       `json:"published"`    // when the stats were gathered
+	TotalChunks int64           `json:"total_chunks"` // how many chunks the network stores
+	Histogram   map[int64]int64 `json:"histogram"`    // chunk counts keyed by size in KB
+}
+
+// a bucket where a result differs from the network by more than this
+// factor is reported as atypical
+const atypicalFactor = 2.0
+
+// buckets holding less than this percent of the chunks of both the result
+// and the network are too small to call atypical
+const atypicalMinPercent = 1.0
+
+// compares a saved result to published network statistics
+func defineNetwork(flags *flag.FlagSet) func() error {
+	resultFile := flags.String("result", "", "saved result to compare")
+	statsSource := flags.String("stats", "", "file or url of published network chunk statistics as json")
+	return func() error {
+		if *resultFile == "" || *statsSource == "" {
+			return errors.New("network requires -result and -stats")
+		}
+		m, err := loadResult(*resultFile)
+		if err != nil {
+			return err
+		}
+		stats, err := loadNetworkStats(*statsSource)
+		if err != nil {
+			return err
+		}
+		reportNetwork(os.Stdout, m.Result, stats)
+		return nil
+	}
+}
+
+// reads network statistics from a file, or fetches them if source is a url
+func loadNetworkStats(source string) (NetworkStats, error) {
+	var stats NetworkStats
+	var err error
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		client := &http.Client{Timeout: time.Minute}
+		err = getJSON(client, source, &stats)
+	} else {
+		var data []byte
+		data, err = ioutil.ReadFile(source)
+		if err == nil {
+			err = json.Unmarshal(data, &stats)
+		}
+	}
+	if err != nil {
+		return stats, err
+	}
+	if len(stats.Histogram) == 0 {
+		return stats, errors.New("no histogram in network statistics")
+	}
+	if stats.TotalChunks == 0 {
+		for _, count := range stats.Histogram {
+			stats.TotalChunks = stats.TotalChunks + count
+		}
+	}
+	return stats, nil
+}
+
+// prints the result's chunk distribution next to the network's, marking
+// sizes where the result is atypical
+func reportNetwork(w io.Writer, r *Result, stats NetworkStats) {
+	fmt.Fprintln(w, "Network statistics from", stats.Source)
+	if !stats.Published.IsZero() {
+		fmt.Fprintln(w, "Published:", stats.Published.Format("2006-01-02"))
+	}
+	fmt.Fprintln(w, "Network chunks:", stats.TotalChunks)
+	fmt.Fprintf(w, "Your share: %.6f%%\n", percent(r.TotalChunks, stats.TotalChunks))
+	// network stats may use finer buckets than results
+	network := map[int64]int64{}
+	width := chunkdist.HistogramWidth(r.Histogram)
+	for size, count := range stats.Histogram {
+		key := chunkdist.HistogramKeyFor(size, width)
+		network[key] = network[key] + count
+	}
+	keys := []int{}
+	for key := range r.Histogram {
+		keys = append(keys, int(key))
+	}
+	for key := range network {
+		if _, exists := r.Histogram[key]; !exists {
+			keys = append(keys, int(key))
+		}
+	}
+	sort.Ints(keys)
+	fmt.Fprintf(w, "\n%-22s %8s %9s\n", "Chunk Size", "You", "Network")
+	atypical := []string{}
+	for _, key := range keys {
+		k := int64(key)
+		label := chunkdist.BucketStart(k)
+		yours := percent(r.Histogram[k], r.TotalChunks)
+		theirs := percent(network[k], stats.TotalChunks)
+		fmt.Fprintf(w, "%-22s %7.1f%% %8.1f%%\n", label, yours, theirs)
+		if math.Max(yours, theirs) < atypicalMinPercent {
+			continue
+		}
+		if theirs == 0 || yours/theirs > atypicalFactor {
+			atypical = append(atypical, fmt.Sprintf("%v: more chunks than typical", label))
+		} else if yours/theirs < 1/atypicalFactor {
+			atypical = append(atypical, fmt.Sprintf("%v: fewer chunks than typical", label))
+		}
+	}
+	if len(atypical) == 0 {
+		fmt.Fprintln(w, "\nYour chunk sizes are typical of the network")
+		return
+	}
+	fmt.Fprintln(w, "\nAtypical chunk sizes")
+	for _, line := range atypical {
+		fmt.Fprintln(w, strings.TrimSpace(line))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// a step in a plan to reduce the chunks of a result
+type optimizeStep struct {
+	action string // bundle or exclude
+	entry  exclusion
+	after  int64 // chunks for the directory after the step
+}
+
+// proposes bundles and exclusions that bring a result under a chunk budget
+func defineOptimize(flags *flag.FlagSet) func() error {
+	saved := flags.String("result", "", "saved result to optimize instead of scanning")
+	target := flags.Int64("target-chunks", 0, "the most chunks to upload")
+	return func() error {
+		if *target <= 0 {
+			return errors.New("optimize requires -target-chunks")
+		}
+		r, err := queryResult(*saved)
+		if err != nil {
+			return err
+		}
+		reportOptimize(os.Stdout, r, planOptimize(r, *target))
+		return nil
+	}
+}
+
+// greedily plans how to get under the target. Bundling a top level directory
+// into one tar keeps its data but chunks it as one file, so directories are
+// bundled first, most chunks saved first. If that isn't enough directories
+// are excluded, those with the fewest bytes per chunk first so the least
+// data is lost.
+func planOptimize(r *Result, target int64) []optimizeStep {
+	entries := topLevel(r)
+	total := r.TotalChunks
+	steps := []optimizeStep{}
+	bundled := func(e exclusion) int64 {
+		return r.Rules.ChunksForSize(e.bytes).Count + 1 // + 1 for datamap
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		si := entries[i].chunks - bundled(entries[i])
+		sj := entries[j].chunks - bundled(entries[j])
+		if si != sj {
+			return si > sj
+		}
+		return entries[i].name < entries[j].name
+	})
+	original := map[string]int64{}
+	for i, e := range entries {
+		if total <= target {
+			return steps
+		}
+		original[e.name] = e.chunks
+		after := bundled(e)
+		if after >= e.chunks {
+			continue
+		}
+		steps = append(steps, optimizeStep{"bundle", e, after})
+		total = total - e.chunks + after
+		entries[i].chunks = after
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		di := float64(entries[i].bytes) / float64(entries[i].chunks)
+		dj := float64(entries[j].bytes) / float64(entries[j].chunks)
+		if di != dj {
+			return di < dj
+		}
+		return entries[i].name < entries[j].name
+	})
+	for _, e := range entries {
+		if total <= target {
+			break
+		}
+		total = total - e.chunks
+		// a directory being excluded doesn't need bundling first
+		if chunks, exists := original[e.name]; exists {
+			e.chunks = chunks
+			kept := []optimizeStep{}
+			for _, s := range steps {
+				if s.entry.name != e.name {
+					kept = append(kept, s)
+				}
+			}
+			steps = kept
+		}
+		steps = append(steps, optimizeStep{"exclude", e, 0})
+	}
+	return steps
+}
+
+// prints the plan and the chunks and bytes that remain after it
+func reportOptimize(w io.Writer, r *Result, steps []optimizeStep) {
+	chunks := r.TotalChunks
+	bytes := r.LargeBytes + r.SmallBytes
+	fmt.Fprintf(w, "\nCurrent: %v chunks, %f GB\n", chunks, float64(bytes)/float64(OneGb))
+	if len(steps) == 0 {
+		fmt.Fprintln(w, "Already within the target")
+		return
+	}
+	fmt.Fprintln(w, "\nAction  Directory  Chunks before  Chunks after")
+	for _, s := range steps {
+		fmt.Fprintf(w, "%v  %v  %v  %v\n", s.action, s.entry.name, s.entry.chunks, s.after)
+		chunks = chunks - s.entry.chunks + s.after
+		if s.action == "exclude" {
+			bytes = bytes - s.entry.bytes
+		}
+	}
+	fmt.Fprintf(w, "\nAfter: %v chunks, %f GB\n", chunks, float64(bytes)/float64(OneGb))
+	fmt.Fprintln(w, "Bundles are tar files of the whole directory; tar headers add a little to each.")
+}
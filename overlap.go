@@ -0,0 +1,189 @@
+package main
+
+// Estimates how many chunks two directory trees share, such as the homes of
+// two family members uploading to the same account. Each file is read and
+// hashed chunk by chunk where the rules would cut it, as -dedupe does, and
+// chunks are compared by their content and the two chunks before them, since
+// self encryption derives each chunk's keys from those. Files that differ only
+// in part, such as a log with a line appended, still share the chunks in
+// between. Chunks are hashed with SHA3-256, as the network uses.
+
+import (
+	"context"
+	"crypto/sha3"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// a tree being compared
+type overlapTree struct {
+	root     string
+	files    []file
+	chunks   int64                   // chunks of every file, datamaps included
+	ids      map[string]overlapChunk // chunks that were read, by id
+	datamaps map[string]bool         // datamaps of the files that were read
+	unread   int64                   // files that couldn't be read or changed size
+}
+
+// a distinct chunk in a tree
+type overlapChunk struct {
+	size  int64
+	count int64 // copies of the chunk in the tree
+}
+
+// adds a chunk of the tree
+func (t *overlapTree) add(id string, size int64) {
+	t.ids[id] = overlapChunk{size, t.ids[id].count + 1}
+}
+
+// reports the chunks shared by two directory trees, walking and chunking them
+// as a scan would with the same flags
+func defineCompareOverlap(flags *flag.FlagSet) func() error {
+	rulesName := flags.String("rules", defaultRules, "chunking rules of a network era: "+strings.Join(ruleSetNames(), ", "))
+	chunkSize := flags.String("chunk-size", "", "override the chunk size of the rules, eg 512K or 4M")
+	minFileSize := flags.String("min-file-size", "", "override the size below which files are stored in the datamap, eg 1K")
+	exact := flags.Bool("exact", false, "split files exactly as self_encryption does")
+	exclude := patternList{}
+	include := patternList{}
+	flags.Var(&include, "include", "only compare files matching a pattern, see -exclude, can be repeated")
+	flags.Var(&exclude, "exclude", "skip files and directories matching a glob, or a regular expression after re:, matched against the name or path, can be repeated")
+	ignoreFiles := flags.Bool("ignore-files", false, "skip files and directories listed in .gitignore and .chunkdistignore files")
+	followSymlinks := flags.Bool("follow-symlinks", false, "compare what symbolic links point to instead of the links, skipping loops")
+	skipSymlinks := flags.Bool("skip-symlinks", false, "leave symbolic links out")
+	oneFileSystem := flags.Bool("one-file-system", false, "don't walk directories on other filesystems than each tree's root, like du -x")
+	return func() error {
+		if flags.NArg() != 2 {
+			return errors.New("usage: compare-overlap [-rules name] [-exclude pattern] rootA rootB")
+		}
+		rules, exists := ruleSets[*rulesName]
+		if !exists {
+			return fmt.Errorf("unknown rules %v, use one of %v", *rulesName, strings.Join(ruleSetNames(), ", "))
+		}
+		rules, err := customRules(rules, *chunkSize, *minFileSize, *exact)
+		if err != nil {
+			return err
+		}
+		if *followSymlinks && *skipSymlinks {
+			return errors.New("use only one of -follow-symlinks and -skip-symlinks")
+		}
+		symlinks := ""
+		if *followSymlinks {
+			symlinks = symlinksFollow
+		}
+		if *skipSymlinks {
+			symlinks = symlinksSkip
+		}
+		trees := []*overlapTree{}
+		for _, root := range flags.Args() {
+			if _, err := os.Stat(root); err != nil {
+				return err
+			}
+			root = path.Clean(root)
+			t := &overlapTree{root: root, ids: map[string]overlapChunk{}, datamaps: map[string]bool{}}
+			w := &walker{
+				ctx:         context.Background(),
+				root:        root,
+				exclude:     exclude,
+				include:     include,
+				ignoreFiles: *ignoreFiles,
+				symlinks:    symlinks,
+				xdev:        *oneFileSystem,
+			}
+			rootFiles, dirs := w.walkRoot(root)
+			t.files = rootFiles
+			for _, dirFiles := range dirs {
+				t.files = append(t.files, dirFiles...)
+			}
+			trees = append(trees, t)
+		}
+		fmt.Println("Hashing the chunks of each file")
+		for _, t := range trees {
+			hashOverlapTree(rules, t)
+		}
+		reportOverlap(os.Stdout, rules, trees[0], trees[1])
+		return nil
+	}
+}
+
+// reads every file in a tree and adds its chunks and datamap
+func hashOverlapTree(rules Rules, t *overlapTree) {
+	for _, f := range t.files {
+		if !f.info.Mode().IsRegular() {
+			continue
+		}
+		t.chunks = t.chunks + rules.ChunksForSize(f.info.Size()).Count + 1 // + 1 for datamap
+		sizes, sums, err := hashChunks(rules, dedupeHashes["sha3"], f.path, f.info.Size())
+		if err != nil {
+			fmt.Println("Skipping", f.path, err)
+			t.unread = t.unread + 1
+			continue
+		}
+		ids := encryptedChunkIDs(sums)
+		if len(ids) == 1 {
+			// stored in the datamap, which is the only chunk
+			t.add("datamap:"+ids[0], sizes[0])
+			t.datamaps[ids[0]] = true
+			continue
+		}
+		for i, id := range ids {
+			t.add(id, sizes[i])
+		}
+		// the datamap lists the chunk hashes, so it is shared only by files
+		// with identical content
+		datamap := strings.Join(ids, "")
+		t.add("datamap:"+datamap, 0)
+		t.datamaps[datamap] = true
+	}
+}
+
+// returns the hex SHA3-256 hash of a file's content
+func hashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha3.New256()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// prints the chunks in each tree, the chunks they share, and the chunks
+// left to upload for the second tree once the first is uploaded
+func reportOverlap(w io.Writer, rules Rules, a, b *overlapTree) {
+	// chunks already uploaded for the first tree are skipped for every copy
+	// of them in the second
+	var sharedFiles, sharedChunks, sharedBytes, skippedChunks int64
+	for id, chunk := range a.ids {
+		if other, exists := b.ids[id]; exists {
+			sharedChunks = sharedChunks + 1
+			sharedBytes = sharedBytes + chunk.size
+			skippedChunks = skippedChunks + other.count
+		}
+	}
+	for datamap := range a.datamaps {
+		if b.datamaps[datamap] {
+			sharedFiles = sharedFiles + 1
+		}
+	}
+	fmt.Fprintln(w, "Rules:", rules.Name)
+	fmt.Fprintln(w, "\nTree  Chunks")
+	fmt.Fprintf(w, "%v  %v\n", a.root, a.chunks)
+	fmt.Fprintf(w, "%v  %v\n", b.root, b.chunks)
+	fmt.Fprintln(w, "\nShared distinct files:", sharedFiles)
+	fmt.Fprintf(w, "Shared: %v chunks, %f GB\n", sharedChunks, float64(sharedBytes)/float64(OneGb))
+	fmt.Fprintf(w, "Chunks to upload %v after %v: %v (%.1f%% saved)\n",
+		b.root, a.root, b.chunks-skippedChunks, percent(skippedChunks, b.chunks))
+	fmt.Fprintln(w, "Datamaps count as chunks, with the bytes of the files stored in them.")
+	if a.unread+b.unread > 0 {
+		fmt.Fprintln(w, a.unread+b.unread, "files couldn't be read and share no chunks")
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// how partial downloads are counted
+const (
+	partialInclude  = "include"  // counted like any other file
+	partialExclude  = "exclude"  // not counted at all
+	partialSeparate = "separate" // counted in their own totals, not the result
+)
+
+var partialModes = []string{partialInclude, partialExclude, partialSeparate}
+
+// extensions browsers and download managers give files while downloading
+var partialExtensions = []string{
+	".part",        // firefox, wget, curl
+	".partial",     // edge, ie
+	".crdownload",  // chrome
+	".download",    // safari
+	".opdownload",  // opera
+	".!qb",         // qbittorrent
+	".!ut",         // utorrent
+	".bc!",         // bitcomet
+	".incomplete",  // transmission, nzbget
+	".fdmdownload", // free download manager
+}
+
+// files smaller than this are not treated as sparse
+const minSparseSize = 10 * OneMb
+
+// returns true if the file looks like a download still in progress, either
+// by its extension or by being a sparse file like those torrent clients
+// allocate before the pieces arrive
+func isPartial(f file) bool {
+	ext := strings.ToLower(filepath.Ext(f.path))
+	for _, partial := range partialExtensions {
+		if ext == partial {
+			return true
+		}
+	}
+	return isSparse(f)
+}
+
+// returns true if less than half of a large file is allocated on disk
+func isSparse(f file) bool {
+	size := f.info.Size()
+	if size < minSparseSize {
+		return false
+	}
+	allocated, ok := allocatedBytes(f.info)
+	return ok && allocated < size/2
+}
+
+// prints the totals for partial downloads, if any were counted separately
+func reportPartial(w io.Writer, r *Result) {
+	if r.PartialFiles == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\n"+tr("Partial downloads, not counted above"))
+	fmt.Fprintln(w, tr("Files:"), r.PartialFiles)
+	fmt.Fprintf(w, tr("Size when complete: %f GB\n"), float64(r.PartialBytes)/float64(OneGb))
+	fmt.Fprintln(w, tr("Chunks when complete:"), r.PartialChunks)
+}
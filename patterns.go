@@ -0,0 +1,83 @@
+package main
+
+// Patterns choose files by their path relative to the scanned directory, for
+// excluding files and classifying them. A pattern is a glob, as in
+// path.Match, which matches if it matches the whole path or the name of the
+// file or of any directory above it, or a regular expression after re:,
+// which matches anywhere in the path.
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// a single pattern, either a glob or a regular expression
+type pattern struct {
+	text string
+	glob string
+	re   *regexp.Regexp
+}
+
+// patternList is a flag.Value of patterns, which can be given several times
+// or comma separated. A regular expression may itself hold commas, such as
+// in {1,2}, so it runs to the end of the value and comes last in a list.
+type patternList []pattern
+
+func (p *patternList) String() string {
+	if p == nil {
+		return ""
+	}
+	texts := []string{}
+	for _, pt := range *p {
+		texts = append(texts, pt.text)
+	}
+	return strings.Join(texts, ",")
+}
+
+func (p *patternList) Set(value string) error {
+	for value != "" {
+		if expr, isRegexp := strings.CutPrefix(value, "re:"); isRegexp {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return fmt.Errorf("invalid regular expression %v: %v", expr, err)
+			}
+			*p = append(*p, pattern{text: value, re: re})
+			return nil
+		}
+		text, rest, _ := strings.Cut(value, ",")
+		value = rest
+		if text == "" {
+			continue
+		}
+		if _, err := path.Match(text, ""); err != nil {
+			return err
+		}
+		*p = append(*p, pattern{text: text, glob: text})
+	}
+	return nil
+}
+
+// returns whether any of the patterns match a path relative to the scanned
+// directory, using forward slashes
+func (p patternList) matches(rel string) bool {
+	names := strings.Split(rel, "/")
+	for _, pt := range p {
+		if pt.re != nil {
+			if pt.re.MatchString(rel) {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pt.glob, rel); matched {
+			return true
+		}
+		for _, name := range names {
+			if matched, _ := path.Match(pt.glob, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
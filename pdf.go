@@ -0,0 +1,114 @@
+package main
+
+// Writes a one page pdf summary of a result, with the headline numbers and
+// a chart of the histogram. The pdf is written directly, using only the
+// standard Helvetica font and filled rectangles, so it needs no library.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// A4 in points
+const (
+	pdfPageWidth  = 595
+	pdfPageHeight = 842
+	pdfMargin     = 50
+)
+
+func init() {
+	chunkdist.RegisterRenderer("pdf", resultRenderer(writePDF))
+}
+
+// writes the summary page
+func writePDF(w io.Writer, r *Result) error {
+	var c bytes.Buffer
+	y := pdfPageHeight - pdfMargin
+	text := func(size, x, y int, s string) {
+		fmt.Fprintf(&c, "BT /F1 %v Tf %v %v Td (%v) Tj ET\n", size, x, y, pdfEscape(s))
+	}
+	text(20, pdfMargin, y, "Chunk distribution")
+	y = y - 30
+	lines := []string{
+		"Rules: " + r.Rules.Name,
+		fmt.Sprintf("Total files: %v", r.Files),
+		fmt.Sprintf("Files larger than %v: %v (%.2f GB)", r.Rules.ChunkSizeLabel(), r.LargeFiles, float64(r.LargeBytes)/float64(OneGb)),
+		fmt.Sprintf("Files smaller than %v: %v (%.2f GB)", r.Rules.ChunkSizeLabel(), r.SmallFiles, float64(r.SmallBytes)/float64(OneGb)),
+		fmt.Sprintf("Total chunks: %v", r.TotalChunks),
+		fmt.Sprintf("Large chunks: %v (%.1f%%)", r.LargeChunks, percent(r.LargeChunks, r.TotalChunks)),
+		fmt.Sprintf("Small chunks: %v (%.1f%%)", r.SmallChunks, percent(r.SmallChunks, r.TotalChunks)),
+	}
+	if u := r.Usage; u != nil {
+		lines = append(lines, fmt.Sprintf("Scanned in %.1fs, %.0f files per second, %.1f MB peak memory",
+			u.WallSeconds, u.FilesPerSecond, float64(u.PeakRSSBytes)/float64(OneMb)))
+	}
+	if len(r.Warnings) > 0 {
+		lines = append(lines, fmt.Sprintf("Warnings: %v, see the text report", len(r.Warnings)))
+	}
+	for _, line := range lines {
+		text(12, pdfMargin, y, line)
+		y = y - 18
+	}
+	// histogram chart, a bar for each chunk size scaled to the largest count
+	y = y - 20
+	text(14, pdfMargin, y, "Chunks by size")
+	y = y - 26
+	keys := []int{}
+	var most int64
+	for key, count := range r.Histogram {
+		keys = append(keys, int(key))
+		if count > most {
+			most = count
+		}
+	}
+	sort.Ints(keys)
+	barX := pdfMargin + 80
+	barWidth := pdfPageWidth - 2*pdfMargin - 160
+	bucketWidth := chunkdist.HistogramWidth(r.Histogram)
+	for _, key := range keys {
+		count := r.Histogram[int64(key)]
+		text(10, pdfMargin, y, chunkdist.BucketLabelFor(int64(key), bucketWidth))
+		width := 0.0
+		if most > 0 {
+			width = float64(barWidth) * float64(count) / float64(most)
+		}
+		if width > 0 {
+			fmt.Fprintf(&c, "0.2 0.4 0.8 rg %v %v %.1f 12 re f 0 g\n", barX, y-2, width)
+		}
+		text(10, barX+int(width)+6, y, fmt.Sprint(count))
+		y = y - 20
+	}
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %v %v] /Resources << /Font << /F1 4 0 R >> >> /Contents 5 0 R >>",
+			pdfPageWidth, pdfPageHeight),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		fmt.Sprintf("<< /Length %v >>\nstream\n%vendstream", c.Len(), c.String()),
+	}
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := []int{}
+	for i, object := range objects {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%v 0 obj\n%v\nendobj\n", i+1, object)
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %v\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %v /Root 1 0 R >>\nstartxref\n%v\n%%%%EOF\n", len(objects)+1, xref)
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// escapes text for a pdf string
+func pdfEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(s)
+}
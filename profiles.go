@@ -0,0 +1,192 @@
+package main
+
+// The collector can also scan directories itself, such as the shares of a
+// NAS, using named scan profiles. Each profile has its own roots, filters and
+// rules, is scanned on its own interval, and keeps its own history of
+// results.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how often a profile is scanned if it doesn't say
+const defaultProfileInterval = 24 * time.Hour
+
+// how many results are kept for each profile, oldest dropped first
+const maxProfileHistory = 100
+
+// profile names are used in urls
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// a named scan configuration, as read from a profiles file
+type scanProfile struct {
+	Name     string   `json:"name"`
+	Roots    []string `json:"roots"`
+	Rules    string   `json:"rules,omitempty"`
+	Exclude  []string `json:"exclude,omitempty"`
+	Include  []string `json:"include,omitempty"`
+	Interval string   `json:"interval,omitempty"` // time between scans, eg 6h
+}
+
+// a profile ready to be scanned
+type profileRunner struct {
+	profile  scanProfile
+	opts     scanOptions
+	interval time.Duration
+	mu       sync.Mutex // guards history
+	history  []MachineResult
+}
+
+// reads a json array of scan profiles, checking each one
+func loadProfiles(filename string) ([]*profileRunner, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	profiles := []scanProfile{}
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	runners := []*profileRunner{}
+	names := map[string]bool{}
+	for _, p := range profiles {
+		if !profileNamePattern.MatchString(p.Name) {
+			return nil, fmt.Errorf("profile name %q must be letters, digits, - or _", p.Name)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("profile %v is defined twice", p.Name)
+		}
+		names[p.Name] = true
+		if len(p.Roots) == 0 {
+			return nil, fmt.Errorf("profile %v has no roots", p.Name)
+		}
+		if p.Rules == "" {
+			p.Rules = defaultRules
+		}
+		rules, exists := ruleSets[p.Rules]
+		if !exists {
+			return nil, fmt.Errorf("profile %v: unknown rules %v", p.Name, p.Rules)
+		}
+		opts := scanOptions{rules: rules}
+		for _, pattern := range p.Exclude {
+			if err := opts.exclude.Set(pattern); err != nil {
+				return nil, fmt.Errorf("profile %v: %v", p.Name, err)
+			}
+		}
+		for _, pattern := range p.Include {
+			if err := opts.include.Set(pattern); err != nil {
+				return nil, fmt.Errorf("profile %v: %v", p.Name, err)
+			}
+		}
+		interval := defaultProfileInterval
+		if p.Interval != "" {
+			interval, err = time.ParseDuration(p.Interval)
+			if err != nil || interval <= 0 {
+				return nil, fmt.Errorf("profile %v: interval must be a duration such as 6h", p.Name)
+			}
+		}
+		runners = append(runners, &profileRunner{profile: p, opts: opts, interval: interval})
+	}
+	return runners, nil
+}
+
+// scans the profile's roots on its interval, forever
+func (p *profileRunner) run(machineID string) {
+	for {
+		fmt.Println("Scanning profile", p.profile.Name)
+		// each scan counts hard links afresh
+		opts := p.opts
+		opts.links = newLinkSet()
+		start := time.Now()
+		scans := scanRoots(p.profile.Roots, opts, 0, nil)
+		m := MachineResult{
+			MachineID: machineID,
+			Scanned:   start,
+			Result:    combineRoots(scans, p.opts.rules),
+		}
+		p.mu.Lock()
+		p.history = append(p.history, m)
+		if len(p.history) > maxProfileHistory {
+			p.history = p.history[len(p.history)-maxProfileHistory:]
+		}
+		p.mu.Unlock()
+		time.Sleep(p.interval)
+	}
+}
+
+// returns the results of the profile, oldest first
+func (p *profileRunner) results() []MachineResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]MachineResult{}, p.history...)
+}
+
+// serves the profiles: a json list at /profiles, the latest report for each
+// at /profiles/{name} and its history as json at /profiles/{name}/history
+func serveProfiles(mux *http.ServeMux, runners []*profileRunner) {
+	byName := map[string]*profileRunner{}
+	for _, p := range runners {
+		byName[p.profile.Name] = p
+	}
+	type profileSummary struct {
+		scanProfile
+		Scans   int        `json:"scans"`
+		Scanned *time.Time `json:"scanned,omitempty"` // when the latest scan finished
+	}
+	mux.HandleFunc("/profiles", func(w http.ResponseWriter, r *http.Request) {
+		summaries := []profileSummary{}
+		for _, p := range runners {
+			results := p.results()
+			s := profileSummary{scanProfile: p.profile, Scans: len(results)}
+			if len(results) > 0 {
+				s.Scanned = &results[len(results)-1].Scanned
+			}
+			summaries = append(summaries, s)
+		}
+		sort.Slice(summaries, func(i, j int) bool { return summaries[i].Name < summaries[j].Name })
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(summaries)
+	})
+	mux.HandleFunc("/profiles/", func(w http.ResponseWriter, r *http.Request) {
+		name, history := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/profiles/"), "/history")
+		p, exists := byName[name]
+		if !exists {
+			http.NotFound(w, r)
+			return
+		}
+		results := p.results()
+		if history {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(results)
+			return
+		}
+		if len(results) == 0 {
+			http.Error(w, "not scanned yet", http.StatusServiceUnavailable)
+			return
+		}
+		latest := results[len(results)-1]
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "Profile", name, "scanned", latest.Scanned.Format(time.RFC3339))
+		latest.Result.Report(w)
+	})
+}
+
+// starts scanning each profile in the background
+func startProfiles(runners []*profileRunner) error {
+	machineID, err := localMachineID()
+	if err != nil {
+		return err
+	}
+	for _, p := range runners {
+		go p.run(machineID)
+	}
+	return nil
+}
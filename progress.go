@@ -0,0 +1,82 @@
+package main
+
+// Shows a progress line on stderr during a scan, so a scan of a large disk
+// isn't silent for minutes. The line is only shown on a terminal. Agents
+// serving their result also stream the progress over a WebSocket.
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// how often the progress line is refreshed
+const progressInterval = 500 * time.Millisecond
+
+// counts the files seen by scans, which may be running at the same time
+type progressMeter struct {
+	progress *chunkdist.Progress
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// returns a progress meter that only counts, without showing a line
+func newProgressMeter() *progressMeter {
+	return &progressMeter{
+		progress: chunkdist.NewProgress(),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// returns a progress meter refreshing its line on stderr until stopped, or
+// nil if stderr isn't a terminal
+func startProgress() *progressMeter {
+	info, err := os.Stderr.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	p := newProgressMeter()
+	go p.run()
+	return p
+}
+
+// counts a file and its chunks, safe to call on a nil meter
+func (p *progressMeter) add(f file, chunks int64) {
+	if p == nil {
+		return
+	}
+	p.progress.Add(f.path, f.info.Size(), chunks)
+}
+
+// returns the progress so far
+func (p *progressMeter) snapshot() chunkdist.ProgressSnapshot {
+	return p.progress.Snapshot()
+}
+
+func (p *progressMeter) run() {
+	defer close(p.stopped)
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			// clear the line so the report starts on an empty one
+			fmt.Fprint(os.Stderr, "\r\033[K")
+			return
+		case <-ticker.C:
+			fmt.Fprint(os.Stderr, "\r\033[K"+p.snapshot().String())
+		}
+	}
+}
+
+// stops refreshing the line and clears it, safe to call on a nil meter
+func (p *progressMeter) stop() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	<-p.stopped
+}
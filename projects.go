@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// the project markers used by default
+const defaultProjectMarkers = ".git,package.json,Cargo.toml,go.mod,pyproject.toml"
+
+// the name used for files that aren't part of any project
+const noProjectName = "(no project)"
+
+// prints the chunks and bytes for each project, largest first
+func reportProjects(w io.Writer, r *Result) {
+	if len(r.Projects) == 0 {
+		return
+	}
+	projects := []exclusion{}
+	for name, project := range r.Projects {
+		projects = append(projects, exclusion{name, project.Chunks, project.Bytes})
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		if projects[i].chunks == projects[j].chunks {
+			return projects[i].name < projects[j].name
+		}
+		return projects[i].chunks > projects[j].chunks
+	})
+	fmt.Fprintln(w, "\n"+tr("Project  Chunks  GB"))
+	for _, p := range projects {
+		fmt.Fprintf(w, "%v  %v  %f\n", p.name, p.chunks, float64(p.bytes)/float64(OneGb))
+	}
+}
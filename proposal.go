@@ -0,0 +1,159 @@
+package main
+
+// Generates a markdown document comparing two chunking strategies on the
+// same files, ready to post to the forum when proposing a change to the
+// chunking rules.
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// the widest bar in a proposal chart
+const proposalBarWidth = 40
+
+// strategyModel chunks every file with one set of rules.
+type strategyModel struct {
+	result      *Result
+	storedBytes int64 // bytes stored on the network including datamaps
+}
+
+func newStrategyModel(rules Rules) *strategyModel {
+	r := NewResult()
+	r.Rules = rules
+	return &strategyModel{result: r}
+}
+
+func (m *strategyModel) addFile(size int64) {
+	m.result.AddFile(size)
+	chunks := m.result.Rules.ChunksForSize(size)
+	m.storedBytes = m.storedBytes + chunks.Bytes() + chunks.DatamapSize
+}
+
+// the strategies are reported together by writeProposal
+func (m *strategyModel) report(w io.Writer) {}
+
+// writes a markdown comparison of two rule sets on the same files
+func defineReportProposal(flags *flag.FlagSet) func() error {
+	strategies := flags.String("strategies", "", "two rule sets to compare: "+strings.Join(ruleSetNames(), ", "))
+	output := flags.String("o", "", "file to write the markdown to, or stdout if not set")
+	return func() error {
+		names := strings.Split(*strategies, ",")
+		if len(names) != 2 {
+			return errors.New("-strategies needs two rule sets, eg safe-2018,autonomi-2024")
+		}
+		models := []*strategyModel{}
+		for _, name := range names {
+			rules, exists := ruleSets[name]
+			if !exists {
+				return fmt.Errorf("unknown rules %v, use one of %v", name, strings.Join(ruleSetNames(), ", "))
+			}
+			models = append(models, newStrategyModel(rules))
+		}
+		roots := flags.Args()
+		if len(roots) == 0 {
+			home, err := homeDir()
+			if err != nil {
+				return err
+			}
+			roots = []string{home}
+		}
+		scans := scanRoots(roots, scanOptions{links: newLinkSet()}, 0, []fileModel{models[0], models[1]})
+		for _, s := range scans {
+			if s.err != nil {
+				return fmt.Errorf("scanning %v: %v", s.root, s.err)
+			}
+		}
+		w := io.Writer(os.Stdout)
+		if *output != "" {
+			f, err := os.Create(*output)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		writeProposal(w, time.Now(), models[0], models[1])
+		return nil
+	}
+}
+
+// writes the markdown comparing strategy a, the current rules, to strategy
+// b, the proposed rules
+func writeProposal(w io.Writer, now time.Time, a, b *strategyModel) {
+	ra, rb := a.result, b.result
+	fileBytes := ra.LargeBytes + ra.SmallBytes
+	fmt.Fprintf(w, "# Chunking proposal: %v to %v\n\n", ra.Rules.Name, rb.Rules.Name)
+	fmt.Fprintf(w, "Measured on %v files totalling %.2f GB, %v.\n\n",
+		ra.Files, float64(fileBytes)/float64(OneGb), now.Format("2006-01-02"))
+	fmt.Fprintln(w, "## Rules")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "| | %v | %v |\n", ra.Rules.Name, rb.Rules.Name)
+	fmt.Fprintln(w, "|---|---:|---:|")
+	fmt.Fprintf(w, "| Chunk size | %v KB | %v KB |\n", ra.Rules.ChunkSize/OneKb, rb.Rules.ChunkSize/OneKb)
+	fmt.Fprintf(w, "| Minimum chunks | %v | %v |\n", ra.Rules.MinChunks, rb.Rules.MinChunks)
+	fmt.Fprintf(w, "| Minimum file size | %v bytes | %v bytes |\n", ra.Rules.MinFileSize, rb.Rules.MinFileSize)
+	fmt.Fprintf(w, "| Datamap size | %v bytes | %v bytes |\n", ra.Rules.DatamapSize, rb.Rules.DatamapSize)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "## Comparison")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "| | %v | %v | Change |\n", ra.Rules.Name, rb.Rules.Name)
+	fmt.Fprintln(w, "|---|---:|---:|---:|")
+	row := func(label string, x, y int64) {
+		fmt.Fprintf(w, "| %v | %v | %v | %v |\n", label, x, y, change(float64(x), float64(y)))
+	}
+	row("Total chunks", ra.TotalChunks, rb.TotalChunks)
+	row("Large chunks", ra.LargeChunks, rb.LargeChunks)
+	row("Small chunks", ra.SmallChunks, rb.SmallChunks)
+	row("Stored bytes", a.storedBytes, b.storedBytes)
+	perFileA, perFileB := average(ra.TotalChunks, ra.Files), average(rb.TotalChunks, rb.Files)
+	fmt.Fprintf(w, "| Chunks per file | %.2f | %.2f | %v |\n", perFileA, perFileB, change(perFileA, perFileB))
+	// overhead is what's stored beyond the bytes of the files themselves
+	overheadA := percent(a.storedBytes-fileBytes, fileBytes)
+	overheadB := percent(b.storedBytes-fileBytes, fileBytes)
+	fmt.Fprintf(w, "| Storage overhead | %.2f%% | %.2f%% | %+.2f points |\n", overheadA, overheadB, overheadB-overheadA)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "## Chunk sizes")
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "| Chunk size | %v | %v |\n", ra.Rules.Name, rb.Rules.Name)
+	fmt.Fprintln(w, "|---|---:|---:|")
+	keys := []int{}
+	for key := range ra.Histogram {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		k := int64(key)
+		fmt.Fprintf(w, "| %v+ KB | %v | %v |\n", key, ra.Histogram[k], rb.Histogram[k])
+	}
+	for _, r := range []*Result{ra, rb} {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "### %v\n\n", r.Rules.Name)
+		fmt.Fprintln(w, "```")
+		writeChart(w, keys, r)
+		fmt.Fprintln(w, "```")
+	}
+}
+
+// returns the relative change from x to y as a percentage
+func change(x, y float64) string {
+	if x == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%+.1f%%", 100*(y-x)/x)
+}
+
+// writes a bar chart of the share of chunks in each size
+func writeChart(w io.Writer, keys []int, r *Result) {
+	for _, key := range keys {
+		share := percent(r.Histogram[int64(key)], r.TotalChunks)
+		bar := strings.Repeat("█", int(share/100*proposalBarWidth+0.5))
+		fmt.Fprintf(w, "%4v+ KB %-*s %5.1f%%\n", key, proposalBarWidth, bar, share)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// the name used for files directly in the scanned directory
+const rootFilesName = "(files in root)"
+
+// reports which top level directories fit within a chunk or byte budget
+func defineQueryFits(flags *flag.FlagSet) func() error {
+	saved := flags.String("result", "", "saved result to query instead of scanning")
+	chunks := flags.Int64("chunks", 0, "budget in chunks")
+	bytesBudget := flags.String("bytes", "", "budget in bytes, eg 500G or 2T")
+	return func() error {
+		if (*chunks > 0) == (*bytesBudget != "") {
+			return errors.New("query fits requires one of -chunks or -bytes")
+		}
+		byBytes := *bytesBudget != ""
+		budget := *chunks
+		if byBytes {
+			var err error
+			budget, err = parseSize(*bytesBudget)
+			if err != nil {
+				return err
+			}
+		}
+		r, err := queryResult(*saved)
+		if err != nil {
+			return err
+		}
+		reportFits(os.Stdout, r, budget, byBytes)
+		return nil
+	}
+}
+
+// returns the saved result, or scans the home directory if there isn't one
+func queryResult(saved string) (*Result, error) {
+	if saved != "" {
+		m, err := loadResult(saved)
+		return m.Result, err
+	}
+	home, err := homeDir()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Gathering current user HomeDir stats")
+	return scan(context.Background(), home, scanOptions{links: newLinkSet()}), nil
+}
+
+// returns the top level directories of a result that contain files, with the
+// files directly in the scanned directory as an extra entry
+func topLevel(r *Result) []exclusion {
+	entries := []exclusion{}
+	rootChunks := r.TotalChunks
+	rootBytes := r.LargeBytes + r.SmallBytes
+	for name, dir := range r.Dirs {
+		if dir.Chunks > 0 {
+			entries = append(entries, exclusion{name, dir.Chunks, dir.Bytes})
+		}
+		rootChunks = rootChunks - dir.Chunks
+		rootBytes = rootBytes - dir.Bytes
+	}
+	if rootChunks > 0 {
+		entries = append(entries, exclusion{rootFilesName, rootChunks, rootBytes})
+	}
+	return entries
+}
+
+// greedily packs top level directories into the budget. For a chunk budget
+// the directories with the most bytes per chunk go first, so the most data is
+// uploaded for the chunks. For a byte budget the largest directories go first.
+func packFits(entries []exclusion, budget int64, byBytes bool) ([]exclusion, []exclusion) {
+	weight := func(e exclusion) int64 {
+		if byBytes {
+			return e.bytes
+		}
+		return e.chunks
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if byBytes {
+			if a.bytes != b.bytes {
+				return a.bytes > b.bytes
+			}
+		} else {
+			da := float64(a.bytes) / float64(a.chunks)
+			db := float64(b.bytes) / float64(b.chunks)
+			if da != db {
+				return da > db
+			}
+		}
+		return a.name < b.name
+	})
+	fits := []exclusion{}
+	rest := []exclusion{}
+	used := int64(0)
+	for _, e := range entries {
+		if used+weight(e) <= budget {
+			fits = append(fits, e)
+			used = used + weight(e)
+		} else {
+			rest = append(rest, e)
+		}
+	}
+	return fits, rest
+}
+
+// prints which top level directories fit within the budget
+func reportFits(w io.Writer, r *Result, budget int64, byBytes bool) {
+	fits, rest := packFits(topLevel(r), budget, byBytes)
+	if byBytes {
+		fmt.Fprintf(w, "\nBudget: %v bytes (%f GB)\n", budget, float64(budget)/float64(OneGb))
+	} else {
+		fmt.Fprintf(w, "\nBudget: %v chunks\n", budget)
+	}
+	printEntries := func(title string, entries []exclusion) {
+		var chunks, bytes int64
+		fmt.Fprintln(w, "\n"+title)
+		fmt.Fprintln(w, "Directory  Chunks  GB")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%v  %v  %f\n", e.name, e.chunks, float64(e.bytes)/float64(OneGb))
+			chunks = chunks + e.chunks
+			bytes = bytes + e.bytes
+		}
+		fmt.Fprintf(w, "Total  %v  %f\n", chunks, float64(bytes)/float64(OneGb))
+	}
+	printEntries("Fits", fits)
+	printEntries("Does not fit", rest)
+}
+
+// parses a size in bytes with an optional K, M, G or T suffix, eg 512K or
+// 1.5T
+func parseSize(s string) (int64, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	multiplier := int64(1)
+	for i, suffix := range []string{"K", "M", "G", "T"} {
+		if strings.HasSuffix(s, suffix) {
+			s = strings.TrimSuffix(s, suffix)
+			for j := 0; j <= i; j++ {
+				multiplier = multiplier * OneKb
+			}
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %v", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
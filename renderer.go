@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// resultRenderer renders a Result, since the formats of this tool write more
+// than the totals, for registering with chunkdist.RegisterRenderer.
+type resultRenderer func(w io.Writer, r *Result) error
+
+// Render calls f with the result, or returns an error for totals alone.
+func (f resultRenderer) Render(w io.Writer, report chunkdist.Reportable) error {
+	r, ok := report.(*Result)
+	if !ok {
+		return errors.New("this format renders a chunk_distribution result, not totals alone")
+	}
+	return f(w, r)
+}
+
+func init() {
+	chunkdist.RegisterRenderer("text", resultRenderer(func(w io.Writer, r *Result) error {
+		r.Report(w)
+		return nil
+	}))
+	chunkdist.RegisterRenderer("json", resultRenderer(func(w io.Writer, r *Result) error {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(r)
+	}))
+	chunkdist.RegisterRenderer("csv", resultRenderer(func(w io.Writer, r *Result) error {
+		return writeResultCSV(w, MachineResult{Result: r})
+	}))
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// Result is the chunk distribution of a set of files, with totals for
+// directories, projects and file types.
+type Result struct {
+	chunkdist.Totals
+	Dirs       map[string]DirTotal `json:"dirs"`                 // totals for each top level directory
+	Projects   map[string]DirTotal `json:"projects,omitempty"`   // totals for each project directory
+	Extensions map[string]DirTotal `json:"extensions,omitempty"` // totals for each file extension
+	Classes    map[string]DirTotal `json:"classes,omitempty"`    // totals for mutable and static data
+	Examples   map[int64][]string  `json:"examples,omitempty"`   // example files for each histogram bucket
+	Warnings   []Warning           `json:"warnings,omitempty"`
+	Anomalies  []Warning           `json:"anomalies,omitempty"` // things worth a closer look
+	ReadRate   float64             `json:"read_rate,omitempty"` // measured read speed in bytes per second
+	// bytes allocated on disk for the files, including filesystem blocks
+	// only partly used
+	DiskBytes int64 `json:"disk_bytes,omitempty"`
+	// partial downloads counted separately, not included in the totals above
+	PartialFiles  int64 `json:"partial_files,omitempty"`
+	PartialBytes  int64 `json:"partial_bytes,omitempty"`
+	PartialChunks int64 `json:"partial_chunks,omitempty"`
+	// the time and memory the scan used, set only for a scan of this machine
+	Usage *ScanUsage `json:"usage,omitempty"`
+}
+
+// Warning is something about a result that may make it inaccurate.
+type Warning struct {
+	Code    string `json:"code"`    // the kind of warning, eg rules_mismatch
+	Subject string `json:"subject"` // what the warning is about
+	Message string `json:"message"`
+}
+
+// DirTotal is the number of chunks and bytes for the files in a directory.
+type DirTotal struct {
+	Chunks int64 `json:"chunks"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// NewResult returns an empty Result.
+func NewResult() *Result {
+	return &Result{
+		Totals:     *chunkdist.NewTotals(ruleSets[defaultRules]),
+		Dirs:       map[string]DirTotal{},
+		Projects:   map[string]DirTotal{},
+		Extensions: map[string]DirTotal{},
+		Classes:    map[string]DirTotal{},
+		Examples:   map[int64][]string{},
+	}
+}
+
+// returns a Builder of results using the rules, for adding files to a result
+// from many goroutines
+func newResultBuilder(rules Rules) *chunkdist.Builder[*Result] {
+	return chunkdist.NewBuilderOf(func() *Result {
+		r := NewResult()
+		r.Rules = rules
+		return r
+	})
+}
+
+// Merge adds the totals from another result to this one.
+func (r *Result) Merge(other *Result) {
+	r.Totals.Merge(&other.Totals)
+	r.PartialFiles = r.PartialFiles + other.PartialFiles
+	r.PartialBytes = r.PartialBytes + other.PartialBytes
+	r.PartialChunks = r.PartialChunks + other.PartialChunks
+	r.addDiskBytes(other.DiskBytes)
+	r.Warnings = append(r.Warnings, other.Warnings...)
+	r.Anomalies = append(r.Anomalies, other.Anomalies...)
+	for key, examples := range other.Examples {
+		r.Examples[key] = append(r.Examples[key], examples...)
+	}
+	for name, project := range other.Projects {
+		total := r.Projects[name]
+		total.Chunks = total.Chunks + project.Chunks
+		total.Bytes = total.Bytes + project.Bytes
+		r.Projects[name] = total
+	}
+	for name, extension := range other.Extensions {
+		total := r.Extensions[name]
+		total.Chunks = total.Chunks + extension.Chunks
+		total.Bytes = total.Bytes + extension.Bytes
+		r.Extensions[name] = total
+	}
+	for name, class := range other.Classes {
+		total := r.Classes[name]
+		total.Chunks = total.Chunks + class.Chunks
+		total.Bytes = total.Bytes + class.Bytes
+		r.Classes[name] = total
+	}
+	for name, dir := range other.Dirs {
+		total := r.Dirs[name]
+		total.Chunks = total.Chunks + dir.Chunks
+		total.Bytes = total.Bytes + dir.Bytes
+		r.Dirs[name] = total
+	}
+}
+
+// adds to the disk bytes, which can overflow on their own since sparse files
+// take less space than their size
+func (r *Result) addDiskBytes(bytes int64) {
+	var ok bool
+	r.DiskBytes, ok = chunkdist.SaturatingAdd(r.DiskBytes, bytes)
+	if !ok {
+		r.Overflow = true
+	}
+}
+
+// returns a warning for a result whose totals overflowed, if they did
+func overflowWarnings(r *Result, subject string) []Warning {
+	if !r.Overflow {
+		return nil
+	}
+	return []Warning{{
+		Code:    "overflow",
+		Subject: subject,
+		Message: "a total was too large to count, such as from a file with a corrupt size, and is capped at the largest 64 bit integer",
+	}}
+}
+
+// Report prints out the details of the result.
+func (r *Result) Report(w io.Writer) {
+	// stats
+	fmt.Fprintln(w, tr("Rules:"), r.Rules.Name)
+	if r.Rules.ChunkSize != OneMb {
+		fmt.Fprintln(w, tr("Chunk size:"), chunkdist.SizeLabel(r.Rules.ChunkSize/OneKb))
+	}
+	fmt.Fprintln(w, tr("Total files:"), r.Files)
+	fmt.Fprintf(w, tr("Files larger than %v: %v (%f GB)\n"), r.Rules.ChunkSizeLabel(), r.LargeFiles, float64(r.LargeBytes)/float64(OneGb))
+	fmt.Fprintf(w, tr("Files smaller than %v: %v (%f GB)\n"), r.Rules.ChunkSizeLabel(), r.SmallFiles, float64(r.SmallBytes)/float64(OneGb))
+	fmt.Fprintln(w, tr("Total chunks:"), r.TotalChunks)
+	fmt.Fprintln(w, tr("Large chunks:"), r.LargeChunks)
+	fmt.Fprintln(w, tr("Small chunks:"), r.SmallChunks)
+	// histogram
+	fmt.Fprintln(w, "\n"+tr("Chunk Size  Count"))
+	chunkdist.WriteHistogram(w, r.Histogram)
+	reportExamples(w, r)
+	reportExclusions(w, r)
+	reportProjects(w, r)
+	reportMutable(w, r)
+	reportPartial(w, r)
+	reportDiskSpace(w, r)
+	reportAnomalies(w, r.Anomalies)
+	reportWarnings(w, r.Warnings)
+	reportUsage(w, r.Usage)
+}
+
+// prints the warnings, if there are any
+func reportWarnings(w io.Writer, warnings []Warning) {
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\n"+tr("Warnings"))
+	fmt.Fprintln(w, tr("Code  Subject  Message"))
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "%v  %v  %v\n", warning.Code, warning.Subject, warning.Message)
+	}
+}
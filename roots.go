@@ -0,0 +1,159 @@
+package main
+
+// Several roots are scanned at the same time, each independently of the
+// others, so a slow or hanging network mount doesn't hold up the results for
+// local disks.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
+)
+
+// the outcome of scanning one root
+type rootScan struct {
+	root    string
+	result  *Result
+	err     error
+	elapsed time.Duration
+}
+
+// a model shared by concurrent scans, which ignores files from a scan once it
+// has been cancelled
+type sharedModel struct {
+	mu  *sync.Mutex
+	ctx context.Context
+	m   fileModel
+}
+
+func (s sharedModel) addFile(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() == nil {
+		s.m.addFile(size)
+	}
+}
+
+func (s sharedModel) report(w io.Writer) {
+	s.m.report(w)
+}
+
+// how long to wait for a timed out scan to return its partial result
+const partialScanWait = 5 * time.Second
+
+// scans each root concurrently. A root that can't be scanned, or that takes
+// longer than the timeout, is reported as an error without affecting the
+// other roots. A timeout of zero waits for every root. Scanning the largest
+// directories first keeps the directories scanned before a timeout.
+func scanRoots(roots []string, opts scanOptions, timeout time.Duration, models []fileModel) []rootScan {
+	scans := make([]rootScan, len(roots))
+	var modelsMu sync.Mutex
+	var wg sync.WaitGroup
+	for i, root := range roots {
+		wg.Add(1)
+		go func(i int, root string) {
+			defer wg.Done()
+			ctx := context.Background()
+			cancel := func() {}
+			if timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+			}
+			defer cancel()
+			shared := []fileModel{}
+			for _, m := range models {
+				shared = append(shared, sharedModel{&modelsMu, ctx, m})
+			}
+			start := time.Now()
+			done := make(chan rootScan, 1)
+			go func() {
+				if _, err := statTimeout(root, opts.opTimeout); err != nil {
+					done <- rootScan{root: root, err: err}
+					return
+				}
+				done <- rootScan{root: root, result: scan(ctx, root, opts, shared...)}
+			}()
+			select {
+			case s := <-done:
+				if s.err == nil && ctx.Err() != nil && !opts.largestFirst {
+					s.result = nil
+					s.err = fmt.Errorf("timed out after %v", timeout)
+				}
+				s.elapsed = time.Since(start)
+				scans[i] = s
+			case <-ctx.Done():
+				s := rootScan{
+					root: root,
+					err:  fmt.Errorf("timed out after %v", timeout),
+				}
+				if opts.largestFirst {
+					// the scan stops at the next directory, keeping the
+					// directories it finished
+					select {
+					case partial := <-done:
+						s = partial
+					case <-time.After(partialScanWait):
+					}
+				}
+				s.elapsed = time.Since(start)
+				scans[i] = s
+			}
+		}(i, root)
+	}
+	wg.Wait()
+	// wait for any model update that started before a timeout to finish,
+	// later updates from timed out roots are ignored
+	modelsMu.Lock()
+	modelsMu.Unlock()
+	return scans
+}
+
+// returns the combined result of the roots that were scanned. When there is
+// more than one root, top level directories are named by their full path.
+func combineRoots(scans []rootScan, rules Rules) *Result {
+	combined := NewResult()
+	combined.Rules = rules
+	for _, s := range scans {
+		if s.result == nil {
+			continue
+		}
+		r := s.result
+		if len(scans) > 1 {
+			dirs := map[string]DirTotal{}
+			for name, dir := range r.Dirs {
+				dirs[path.Join(s.root, name)] = dir
+			}
+			r.Dirs = dirs
+		}
+		combined.Merge(r)
+		if len(scans) == 1 {
+			combined.ReadRate = r.ReadRate
+		}
+	}
+	for _, s := range scans {
+		if s.err != nil {
+			combined.Warnings = append(combined.Warnings, Warning{
+				Code:    "root_not_scanned",
+				Subject: s.root,
+				Message: s.err.Error(),
+			})
+		}
+	}
+	return combined
+}
+
+// prints the outcome and timing of scanning each root
+func reportRoots(w io.Writer, scans []rootScan) {
+	fmt.Fprintln(w, "\nRoot  Files  Chunks  Time")
+	for _, s := range scans {
+		elapsed := s.elapsed.Round(time.Millisecond)
+		if s.err != nil {
+			fmt.Fprintf(w, "%v  error: %v  %v\n", s.root, s.err, elapsed)
+			continue
+		}
+		fmt.Fprintf(w, "%v  %v  %v  %v\n", s.root, s.result.Files, s.result.TotalChunks, elapsed)
+	}
+	fmt.Fprintln(w)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// the chunking rules, which are defined by the chunkdist package
+type Rules = chunkdist.Rules
+
+const defaultRules = chunkdist.DefaultRules
+
+var ruleSets = chunkdist.RuleSets
+
+// the rule set used by each released version of the network
+var networkVersions = map[string]string{
+	"alpha-1":  "safe-2018",
+	"alpha-2":  "safe-2018",
+	"fleming":  "safe-fleming",
+	"autonomi": "autonomi-2024",
+}
+
+// returns the names of the network versions
+func networkVersionNames() []string {
+	names := []string{}
+	for name := range networkVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns warnings for each parameter of the rules that differs from the
+// rules of a network version
+func checkNetworkVersion(rules Rules, networkVersion string) []Warning {
+	expected := ruleSets[networkVersions[networkVersion]]
+	warnings := []Warning{}
+	mismatch := func(param string, got, want int64) {
+		if got != want {
+			warnings = append(warnings, Warning{
+				Code:    "rules_mismatch",
+				Subject: param,
+				Message: fmt.Sprintf("rules %v use %v but network %v uses %v", rules.Name, got, networkVersion, want),
+			})
+		}
+	}
+	mismatch("chunk_size", rules.ChunkSize, expected.ChunkSize)
+	mismatch("min_chunks", rules.MinChunks, expected.MinChunks)
+	mismatch("min_file_size", rules.MinFileSize, expected.MinFileSize)
+	mismatch("datamap_size", rules.DatamapSize, expected.DatamapSize)
+	return warnings
+}
+
+// returns the names of the rule sets
+func ruleSetNames() []string {
+	names := []string{}
+	for name := range ruleSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// returns the rules with a different chunk size or minimum file size, given
+// as sizes such as 512K or 4M, and split exactly as self_encryption does if
+// exact is set, or the rules unchanged if none are given. The name records
+// each change, so a saved result can't be mistaken for one made with the
+// standard rules.
+func customRules(rules Rules, chunkSize, minFileSize string, exact bool) (Rules, error) {
+	if chunkSize != "" {
+		size, err := parseSize(chunkSize)
+		if err != nil {
+			return rules, err
+		}
+		if size < rules.MinChunks {
+			return rules, errors.New("the chunk size must be at least the minimum number of chunks in bytes")
+		}
+		rules.ChunkSize = size
+		rules.Name = rules.Name + "+chunk_size=" + chunkSize
+	}
+	if minFileSize != "" {
+		size, err := parseSize(minFileSize)
+		if err != nil {
+			return rules, err
+		}
+		// smaller files would be split into chunks of no bytes
+		if size < rules.MinChunks {
+			return rules, errors.New("the min file size must be at least the minimum number of chunks in bytes")
+		}
+		rules.MinFileSize = size
+		rules.Name = rules.Name + "+min_file_size=" + minFileSize
+	}
+	if exact {
+		rules.Exact = true
+		rules.Name = rules.Name + "+exact"
+	}
+	return rules, nil
+}
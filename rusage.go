@@ -0,0 +1,25 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// returns the user and system time the process has used, and its peak
+// resident memory in bytes
+func processUsage() (time.Duration, int64, bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0, false
+	}
+	cpu := time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	// ru_maxrss is in bytes on macOS but KB elsewhere
+	peak := int64(usage.Maxrss)
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		peak = peak * OneKb
+	}
+	return cpu, peak, true
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+import "time"
+
+// process usage isn't read here, so only the wall time is reported
+func processUsage() (time.Duration, int64, bool) {
+	return 0, 0, false
+}
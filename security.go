@@ -0,0 +1,225 @@
+package main
+
+// Network facing modes can require a shared token and use TLS, since scan
+// results reveal the structure of the filesystem.
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// the environment variable used for the token when -token is not set, so it
+// does not appear in the process list
+const tokenEnv = "CHUNK_DISTRIBUTION_TOKEN"
+
+// how long self-signed certificates are valid for
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// security settings shared by all network facing modes
+type security struct {
+	token        string
+	certFile     string
+	keyFile      string
+	selfSigned   bool
+	caFile       string
+	fingerprints string
+}
+
+// adds the security flags to a command
+func addSecurityFlags(flags *flag.FlagSet) *security {
+	s := &security{}
+	flags.StringVar(&s.token, "token", os.Getenv(tokenEnv), "shared token required by servers and sent by clients, defaults to $"+tokenEnv)
+	flags.StringVar(&s.certFile, "tls-cert", "", "TLS certificate file to serve with")
+	flags.StringVar(&s.keyFile, "tls-key", "", "TLS key file to serve with")
+	flags.BoolVar(&s.selfSigned, "tls-self-signed", false, "create a self-signed certificate at -tls-cert and -tls-key if they do not exist")
+	flags.StringVar(&s.caFile, "tls-ca", "", "CA certificate file to trust when connecting to servers")
+	flags.StringVar(&s.fingerprints, "tls-fingerprint", "", "comma separated SHA-256 fingerprints of server certificates to trust, eg for self-signed certificates")
+	return s
+}
+
+// returns the tls config for servers, or nil if tls is not enabled
+func (s *security) serverTLS() (*tls.Config, error) {
+	if s.certFile == "" && s.keyFile == "" {
+		if s.selfSigned {
+			return nil, errors.New("-tls-self-signed requires -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if s.certFile == "" || s.keyFile == "" {
+		return nil, errors.New("-tls-cert and -tls-key must be used together")
+	}
+	if s.selfSigned {
+		if _, err := os.Stat(s.certFile); os.IsNotExist(err) {
+			if err := writeSelfSigned(s.certFile, s.keyFile); err != nil {
+				return nil, err
+			}
+		}
+	}
+	cert, err := tls.LoadX509KeyPair(s.certFile, s.keyFile)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("TLS certificate fingerprint", fingerprint(cert.Certificate[0]))
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// listens on addr, using tls if it is enabled
+func (s *security) listen(addr string) (net.Listener, bool, error) {
+	config, err := s.serverTLS()
+	if err != nil {
+		return nil, false, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, false, err
+	}
+	if config == nil {
+		return listener, false, nil
+	}
+	return tls.NewListener(listener, config), true, nil
+}
+
+// returns the http client for connecting to servers
+func (s *security) client() (*http.Client, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.caFile != "" {
+		pem, err := ioutil.ReadFile(s.caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", s.caFile)
+		}
+		config.RootCAs = pool
+	}
+	if s.fingerprints != "" {
+		trusted := map[string]bool{}
+		for _, f := range strings.Split(s.fingerprints, ",") {
+			trusted[normalizeFingerprint(f)] = true
+		}
+		// the pinned fingerprint replaces chain and hostname verification,
+		// which self-signed certificates and discovered addresses would fail
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(certs [][]byte, _ [][]*x509.Certificate) error {
+			if len(certs) > 0 && trusted[fingerprint(certs[0])] {
+				return nil
+			}
+			return errors.New("server certificate fingerprint is not trusted")
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport, Timeout: time.Minute}, nil
+}
+
+// tells if connections to servers use TLS, which is decided by the client's
+// own settings for trusting servers and never by what a server advertises
+func (s *security) clientTLS() bool {
+	return s.caFile != "" || s.fingerprints != ""
+}
+
+// adds the token to a request. The token is never sent over plain http,
+// where anyone on the network could read it.
+func (s *security) authorize(req *http.Request) error {
+	if s.token == "" {
+		return nil
+	}
+	if req.URL.Scheme != "https" {
+		return fmt.Errorf("not sending the token to %v over plain http, use https with -tls-ca or -tls-fingerprint", req.URL.Host)
+	}
+	req.Header.Set("Authorization", "Bearer "+s.token)
+	return nil
+}
+
+// wraps a handler so requests without the token are rejected. The token is
+// accepted as a bearer token or as a basic auth password, for browsers.
+func (s *security) requireToken(h http.Handler) http.Handler {
+	if s.token == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if _, password, ok := r.BasicAuth(); ok {
+			token = password
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="chunk_distribution"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// returns the SHA-256 fingerprint of a DER encoded certificate
+func fingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:])
+}
+
+// returns a fingerprint in lowercase hex without separators
+func normalizeFingerprint(f string) string {
+	f = strings.ToLower(strings.TrimSpace(f))
+	return strings.NewReplacer(":", "", " ", "").Replace(f)
+}
+
+// creates a self-signed certificate and key
+func writeSelfSigned(certFile, keyFile string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "chunk_distribution " + hostname},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{hostname, hostname + ".local", "localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Created self-signed certificate", certFile)
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
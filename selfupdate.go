@@ -0,0 +1,233 @@
+package main
+
+// self-update replaces the running binary with the latest release from
+// GitHub. The release must include a SHA256SUMS file listing the binary,
+// which is checked before anything is replaced, and SHA256SUMS must have a
+// valid ed25519 signature in SHA256SUMS.sig from the release public key the
+// binary was built with. Builds without a key can check for a release but
+// don't install one, since a checksum from the same place as the binary
+// proves nothing about who made it.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the version of this build
+const version = "v0.1.0"
+
+const latestReleaseURL = "https://api.github.com/repos/iancoleman/chunk_distribution/releases/latest"
+
+// releasePublicKey is the base64 ed25519 key that signs SHA256SUMS, set at
+// build time with -ldflags "-X main.releasePublicKey=..."
+var releasePublicKey = ""
+
+// a GitHub release
+type release struct {
+	TagName string `json:"tag_name"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// returns the url of the named release asset
+func (r release) asset(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %v has no %v", r.TagName, name)
+}
+
+// updates this binary to the latest release
+func defineSelfUpdate(flags *flag.FlagSet) func() error {
+	check := flags.Bool("check", false, "only check if there is a newer release")
+	return func() error {
+		client := &http.Client{Timeout: 5 * time.Minute}
+		var latest release
+		if err := getJSON(client, latestReleaseURL, &latest); err != nil {
+			return err
+		}
+		if !newerVersion(latest.TagName, version) {
+			fmt.Println("Already up to date")
+			return nil
+		}
+		fmt.Println("New version available:", latest.TagName)
+		if *check {
+			return nil
+		}
+		if releasePublicKey == "" {
+			return errors.New("this build has no release key to verify an update with, download the release from GitHub instead")
+		}
+		name := "chunk_distribution_" + runtime.GOOS + "_" + runtime.GOARCH
+		if runtime.GOOS == "windows" {
+			name = name + ".exe"
+		}
+		sums, err := download(client, latest, "SHA256SUMS")
+		if err != nil {
+			return err
+		}
+		sig, err := download(client, latest, "SHA256SUMS.sig")
+		if err != nil {
+			return err
+		}
+		if err := verifySignature(sums, sig); err != nil {
+			return err
+		}
+		expected, err := checksumFor(sums, name)
+		if err != nil {
+			return err
+		}
+		binary, err := download(client, latest, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(binary)
+		if hex.EncodeToString(sum[:]) != expected {
+			return fmt.Errorf("checksum of %v does not match SHA256SUMS", name)
+		}
+		if err := replaceExecutable(binary); err != nil {
+			return err
+		}
+		fmt.Println("Updated to", latest.TagName)
+		return nil
+	}
+}
+
+// decodes the json response from url into v
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responded with %v", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// downloads a release asset
+func download(client *http.Client, r release, name string) ([]byte, error) {
+	url, err := r.asset(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %v responded with %v", name, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// checks the base64 ed25519 signature of the checksums
+func verifySignature(sums, sig []byte) error {
+	key, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid release public key in this build")
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), sums, signature) {
+		return errors.New("SHA256SUMS signature is not valid")
+	}
+	return nil
+}
+
+// returns the checksum for the named file from a SHA256SUMS file
+func checksumFor(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("SHA256SUMS has no checksum for %v", name)
+}
+
+// returns true if version a is newer than version b, comparing the dot
+// separated numbers of versions like v1.2.3
+func newerVersion(a, b string) bool {
+	pa := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	pb := strings.Split(strings.TrimPrefix(b, "v"), ".")
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var na, nb int
+		if i < len(pa) {
+			na, _ = strconv.Atoi(pa[i])
+		}
+		if i < len(pb) {
+			nb, _ = strconv.Atoi(pb[i])
+		}
+		if na != nb {
+			return na > nb
+		}
+	}
+	return false
+}
+
+// replaces the running executable with binary. The new binary is written
+// next to the old one and renamed over it, so a failure part way through
+// leaves the old binary in place.
+func replaceExecutable(binary []byte) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	exe, err = filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(exe), ".chunk_distribution-update-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, bytes.NewReader(binary)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+	// windows can't replace a running executable, but can rename it
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), exe); err != nil {
+		os.Rename(old, exe)
+		return err
+	}
+	os.Remove(old)
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+// Compares rules for small files, those no larger than a chunk, which make up
+// most files but little of the data. Splitting each into three chunks, as self
+// encryption does, costs four objects a file, where storing the content in
+// the datamap costs one. Larger files are chunked by the chosen rules under
+// every rule, so only the small files make the difference.
+
+import (
+	"fmt"
+	"io"
+)
+
+// the totals under one rule for small files
+type smallRuleTotal struct {
+	name         string
+	rules        Rules // chunks the small files
+	chunks       int64 // objects for every file, including datamaps
+	smallObjects int64 // objects for the small files
+	bytes        int64 // network bytes of the chunks and datamaps
+}
+
+// smallRulesModel counts the chunks under each rule for small files.
+type smallRulesModel struct {
+	rules      Rules
+	smallFiles int64
+	totals     []*smallRuleTotal
+}
+
+// returns a model comparing the chosen rules with three rules for small
+// files: at least the minimum number of chunks, the content in the datamap,
+// and one chunk
+func newSmallRulesModel(rules Rules) *smallRulesModel {
+	simple := rules
+	simple.Exact = false
+	minChunks := simple
+	// any file that can be split into the minimum number of chunks is
+	minChunks.MinFileSize = simple.MinChunks
+	inline := simple
+	inline.MinFileSize = simple.ChunkSize + 1
+	single := simple
+	single.MinChunks = 1
+	single.MinFileSize = 1
+	return &smallRulesModel{
+		rules: rules,
+		totals: []*smallRuleTotal{
+			{name: "Chosen rules (" + rules.Name + ")", rules: rules},
+			{name: fmt.Sprintf("%v chunk minimum", rules.MinChunks), rules: minChunks},
+			{name: "Inline in datamap", rules: inline},
+			{name: "Single chunk", rules: single},
+		},
+	}
+}
+
+func (m *smallRulesModel) addFile(size int64) {
+	small := size <= m.rules.ChunkSize
+	if small {
+		m.smallFiles = m.smallFiles + 1
+	}
+	for _, t := range m.totals {
+		rules := m.rules
+		if small {
+			rules = t.rules
+		}
+		chunks := rules.ChunksForSize(size)
+		objects := chunks.Count + 1 // + 1 for datamap
+		t.chunks = t.chunks + objects
+		t.bytes = t.bytes + chunks.Bytes() + chunks.DatamapSize
+		if small {
+			t.smallObjects = t.smallObjects + objects
+		}
+	}
+}
+
+// prints the chunks under each rule, with the change from the chosen rules
+// and the overhead of each small file over the one object it needs at least
+func (m *smallRulesModel) report(w io.Writer) {
+	fmt.Fprintln(w, "\nSmall file rules")
+	fmt.Fprintln(w, "Small files (no larger than a chunk):", m.smallFiles)
+	fmt.Fprintln(w, "Rule  Chunks  Change  Small file objects  Objects per small file  Overhead  Network GB")
+	chosen := m.totals[0].chunks
+	for _, t := range m.totals {
+		perFile := 0.0
+		if m.smallFiles > 0 {
+			perFile = float64(t.smallObjects) / float64(m.smallFiles)
+		}
+		fmt.Fprintf(w, "%v  %v  %+.1f%%  %v  %.2f  %v  %f\n",
+			t.name,
+			t.chunks,
+			percent(t.chunks-chosen, chosen),
+			t.smallObjects,
+			perFile,
+			t.smallObjects-m.smallFiles,
+			float64(t.bytes)/float64(OneGb))
+	}
+}
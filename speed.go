@@ -0,0 +1,127 @@
+package main
+
+// Measures how fast each root can be read, since reading from a local SSD or
+// from a USB disk changes how long an upload takes as much as the network
+// does. Reads are sampled from a few large files found during the scan, so
+// files already in the page cache can make a disk look faster than it is.
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+)
+
+const (
+	// how many files to sample reads from
+	readSampleFiles = 16
+	// the most bytes read from each sampled file
+	readSampleBytes = 8 * OneMb
+	// files smaller than this are not sampled, since opening them costs
+	// more than reading them
+	readSampleMinSize = OneMb
+)
+
+// chooses a random sample of the large files seen during a scan
+type readSampler struct {
+	seen  int
+	paths []string
+	rand  *rand.Rand
+}
+
+func newReadSampler() *readSampler {
+	return &readSampler{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// considers a file for the sample, keeping a uniform random sample of all
+// files considered
+func (s *readSampler) add(f file) {
+	if f.info.Size() < readSampleMinSize {
+		return
+	}
+	s.seen = s.seen + 1
+	if len(s.paths) < readSampleFiles {
+		s.paths = append(s.paths, f.path)
+		return
+	}
+	if i := s.rand.Intn(s.seen); i < readSampleFiles {
+		s.paths[i] = f.path
+	}
+}
+
+// reads the sampled files, returning the read rate in bytes per second, or
+// zero if nothing could be read
+func (s *readSampler) measure() float64 {
+	var total int64
+	var elapsed time.Duration
+	buf := make([]byte, OneMb)
+	for _, filename := range s.paths {
+		f, err := os.Open(filename)
+		if err != nil {
+			continue
+		}
+		start := time.Now()
+		n, _ := io.CopyBuffer(io.Discard, io.LimitReader(f, readSampleBytes), buf)
+		elapsed = elapsed + time.Since(start)
+		total = total + n
+		f.Close()
+	}
+	if total == 0 || elapsed <= 0 {
+		return 0
+	}
+	return float64(total) / elapsed.Seconds()
+}
+
+// returns the kind of storage a read rate in bytes per second suggests
+func storageClass(rate float64) string {
+	switch {
+	case rate >= 1000*OneMb:
+		return "NVMe SSD"
+	case rate >= 300*OneMb:
+		return "SSD"
+	case rate >= 60*OneMb:
+		return "HDD"
+	}
+	return "slow (USB or network)"
+}
+
+// returns how long it takes to transfer bytes at rate bytes per second
+func transferTime(bytes int64, rate float64) time.Duration {
+	return time.Duration(float64(bytes) / rate * float64(time.Second))
+}
+
+// prints the read rate of each root and the time to upload it, which is
+// limited by the slower of reading and uploading. An upload speed of zero
+// means only the read time is estimated.
+func reportUploadTime(w io.Writer, scans []rootScan, uploadSpeed float64) {
+	fmt.Fprintln(w, "\nUpload time")
+	if uploadSpeed > 0 {
+		fmt.Fprintf(w, "Upload speed: %.1f MB/s\n", uploadSpeed/OneMb)
+	}
+	fmt.Fprintln(w, "Root  Read speed  Storage  Time")
+	var total time.Duration
+	for _, s := range scans {
+		if s.result == nil {
+			continue
+		}
+		rate := s.result.ReadRate
+		if uploadSpeed > 0 && (rate == 0 || uploadSpeed < rate) {
+			rate = uploadSpeed
+		}
+		readSpeed := "unknown"
+		class := "unknown"
+		if s.result.ReadRate > 0 {
+			readSpeed = fmt.Sprintf("%.1f MB/s", s.result.ReadRate/OneMb)
+			class = storageClass(s.result.ReadRate)
+		}
+		estimate := "unknown"
+		if rate > 0 {
+			t := transferTime(s.result.LargeBytes+s.result.SmallBytes, rate)
+			total = total + t
+			estimate = t.Round(time.Second).String()
+		}
+		fmt.Fprintf(w, "%v  %v  %v  %v\n", s.root, readSpeed, class, estimate)
+	}
+	fmt.Fprintln(w, "Total time:", total.Round(time.Second))
+}
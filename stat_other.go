@@ -0,0 +1,32 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// allocated bytes aren't available here, so sparse files can't be detected
+func allocatedBytes(info os.FileInfo) (int64, bool) {
+	return 0, false
+}
+
+// hard links can't be identified here
+func fileID(info os.FileInfo) (string, bool) {
+	return "", false
+}
+
+// every file counts as having one link here
+func linkCount(info os.FileInfo) int64 {
+	return 1
+}
+
+// filesystems can't be told apart here, so -one-file-system has no effect
+func fileDevice(info os.FileInfo) (string, bool) {
+	return "", false
+}
+
+// file owners can't be read here, so scans can't be limited to one user
+const canReadOwners = false
+
+func fileOwner(info os.FileInfo) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,60 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// returns the bytes allocated on disk for a file, which is less than its
+// size for sparse files
+func allocatedBytes(info os.FileInfo) (int64, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	// st_blocks is always in 512 byte units
+	return int64(stat.Blocks) * 512, true
+}
+
+// returns an id that's the same for every hard link to a file
+func fileID(info os.FileInfo) (string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%v:%v", stat.Dev, stat.Ino), true
+}
+
+// returns the number of hard links to a file
+func linkCount(info os.FileInfo) int64 {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1
+	}
+	return int64(stat.Nlink)
+}
+
+// returns an id for the filesystem a file is on
+func fileDevice(info os.FileInfo) (string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(stat.Dev), true
+}
+
+// file owners can be read here
+const canReadOwners = true
+
+// returns the user id of the owner of a file
+func fileOwner(info os.FileInfo) (string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatUint(uint64(stat.Uid), 10), true
+}
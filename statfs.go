@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// returns the block size of the filesystem holding a directory
+func fsBlockSize(dirname string) (int64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dirname, &stat); err != nil {
+		return 0, false
+	}
+	return int64(stat.Bsize), true
+}
+
+// returns the bytes in use on the filesystem holding a directory
+func fsUsedBytes(dirname string) (int64, bool) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dirname, &stat); err != nil {
+		return 0, false
+	}
+	return int64(stat.Blocks-stat.Bfree) * int64(stat.Bsize), true
+}
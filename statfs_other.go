@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+
+package main
+
+// the block size isn't available here, so file sizes are counted as is
+func fsBlockSize(dirname string) (int64, bool) {
+	return 0, false
+}
+
+// the space used isn't available here, so coverage can't be checked
+func fsUsedBytes(dirname string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,128 @@
+package main
+
+// Summarises the sizes of files and of chunks with statistics such as the
+// median and percentiles, to describe the distribution in a few numbers
+// rather than a histogram. Sizes are counted exactly, by how many files have
+// each size, and chunk sizes are worked out from the file sizes when
+// reporting. Chunks include datamaps, as in the histogram.
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+)
+
+// the percentiles reported, besides the median
+var statPercentiles = []float64{90, 95, 99}
+
+// sizeStats are the statistics of a set of sizes in bytes.
+type sizeStats struct {
+	count       int64
+	min, max    int64
+	mean        float64
+	stddev      float64
+	median      int64
+	percentiles []int64 // for each of statPercentiles
+}
+
+// returns the statistics of sizes given as the count of each size.
+// Percentiles are nearest rank, so they are always one of the sizes.
+func summarizeSizes(counts map[int64]int64) sizeStats {
+	var s sizeStats
+	sizes := []int64{}
+	var sum float64
+	for size, count := range counts {
+		sizes = append(sizes, size)
+		s.count = s.count + count
+		sum = sum + float64(size)*float64(count)
+	}
+	if s.count == 0 {
+		s.percentiles = make([]int64, len(statPercentiles))
+		return s
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	s.min, s.max = sizes[0], sizes[len(sizes)-1]
+	s.mean = sum / float64(s.count)
+	var squares float64
+	for size, count := range counts {
+		d := float64(size) - s.mean
+		squares = squares + d*d*float64(count)
+	}
+	s.stddev = math.Sqrt(squares / float64(s.count))
+	// the size at each rank, found in one pass over the sorted sizes
+	percentile := func(p float64) int64 {
+		rank := int64(math.Ceil(p / 100 * float64(s.count)))
+		var seen int64
+		for _, size := range sizes {
+			seen = seen + counts[size]
+			if seen >= rank {
+				return size
+			}
+		}
+		return s.max
+	}
+	s.median = percentile(50)
+	for _, p := range statPercentiles {
+		s.percentiles = append(s.percentiles, percentile(p))
+	}
+	return s
+}
+
+// statsModel counts files by size to summarise file and chunk sizes.
+type statsModel struct {
+	mu    sync.Mutex
+	rules Rules
+	files map[int64]int64 // the number of files of each size
+}
+
+func newStatsModel(rules Rules) *statsModel {
+	return &statsModel{rules: rules, files: map[int64]int64{}}
+}
+
+// adds a file, safe to call from several scans at once
+func (m *statsModel) addFile(size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.files[size] = m.files[size] + 1
+}
+
+// returns the number of chunks of each size for the files counted
+func (m *statsModel) chunkSizes() map[int64]int64 {
+	chunks := map[int64]int64{}
+	for size, files := range m.files {
+		c := m.rules.ChunksForSize(size)
+		chunks[c.DatamapSize] = chunks[c.DatamapSize] + files
+		if c.Count == 0 {
+			continue
+		}
+		middle := c.Count - 1
+		if c.PenultimateSize != 0 {
+			middle = middle - 1
+			chunks[c.PenultimateSize] = chunks[c.PenultimateSize] + files
+		}
+		if middle > 0 {
+			chunks[c.Size] = chunks[c.Size] + middle*files
+		}
+		chunks[c.LastSize] = chunks[c.LastSize] + files
+	}
+	return chunks
+}
+
+// prints the statistics of file sizes and chunk sizes side by side, in bytes
+func (m *statsModel) report(w io.Writer) {
+	files := summarizeSizes(m.files)
+	chunks := summarizeSizes(m.chunkSizes())
+	fmt.Fprintln(w, "\nSize statistics (bytes)")
+	fmt.Fprintln(w, "Statistic  Files  Chunks")
+	fmt.Fprintf(w, "Count  %v  %v\n", files.count, chunks.count)
+	fmt.Fprintf(w, "Min  %v  %v\n", files.min, chunks.min)
+	fmt.Fprintf(w, "Max  %v  %v\n", files.max, chunks.max)
+	fmt.Fprintf(w, "Mean  %.0f  %.0f\n", files.mean, chunks.mean)
+	fmt.Fprintf(w, "Median  %v  %v\n", files.median, chunks.median)
+	for i, p := range statPercentiles {
+		fmt.Fprintf(w, "P%v  %v  %v\n", p, files.percentiles[i], chunks.percentiles[i])
+	}
+	fmt.Fprintf(w, "Standard deviation  %.0f  %.0f\n", files.stddev, chunks.stddev)
+}
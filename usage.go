@@ -0,0 +1,52 @@
+package main
+
+// Records what a scan cost, so results shared between users also say how
+// long the scan took and how much memory it needed, which helps tune defaults
+// such as -workers.
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// ScanUsage is the time and memory a scan used.
+type ScanUsage struct {
+	WallSeconds    float64 `json:"wall_seconds"`
+	CPUSeconds     float64 `json:"cpu_seconds,omitempty"`    // user and system time, where it can be read
+	PeakRSSBytes   int64   `json:"peak_rss_bytes,omitempty"` // largest resident memory, where it can be read
+	FilesPerSecond float64 `json:"files_per_second"`
+	Dirs           int64   `json:"dirs"` // directories read, each a directory listing call
+}
+
+// returns the usage of a scan that started at start and found files in dirs
+// directories. The CPU time and peak memory are for the whole process.
+func measureUsage(start time.Time, files, dirs int64) *ScanUsage {
+	wall := time.Since(start).Seconds()
+	u := &ScanUsage{WallSeconds: wall, Dirs: dirs}
+	if wall > 0 {
+		u.FilesPerSecond = float64(files) / wall
+	}
+	if cpu, peak, ok := processUsage(); ok {
+		u.CPUSeconds = cpu.Seconds()
+		u.PeakRSSBytes = peak
+	}
+	return u
+}
+
+// prints the usage of the scan, if it was recorded
+func reportUsage(w io.Writer, u *ScanUsage) {
+	if u == nil {
+		return
+	}
+	fmt.Fprintln(w, "\n"+tr("Scan usage"))
+	fmt.Fprintf(w, tr("Wall time: %.1fs\n"), u.WallSeconds)
+	if u.CPUSeconds > 0 {
+		fmt.Fprintf(w, tr("CPU time: %.1fs\n"), u.CPUSeconds)
+	}
+	if u.PeakRSSBytes > 0 {
+		fmt.Fprintf(w, tr("Peak memory: %.1f MB\n"), float64(u.PeakRSSBytes)/float64(OneMb))
+	}
+	fmt.Fprintf(w, tr("Files per second: %.0f\n"), u.FilesPerSecond)
+	fmt.Fprintln(w, tr("Directories read:"), u.Dirs)
+}
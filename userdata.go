@@ -0,0 +1,39 @@
+package main
+
+// Patterns for -user-data-only, which leaves out the operating system,
+// installed applications and caches, so distributions shared between users
+// compare their personal data only. Directories at the top of a whole disk
+// are matched by regular expressions anchored to the scanned directory, and
+// those inside a home directory by name.
+
+// Each value is given to patternList.Set, so a regular expression is a value
+// of its own.
+var userDataExcludes = map[string][]string{
+	"linux": {
+		`re:^/?(bin|boot|dev|etc|lib|lib32|lib64|libx32|opt|proc|root|run|sbin|snap|srv|sys|tmp|usr|var)(/|$)`,
+		`.cache,.local/share/Trash,.local/lib,.npm,.cargo,.rustup,.gradle,.m2,.var,snap,.steam,.wine,node_modules`,
+	},
+	"darwin": {
+		`re:^/?(Applications|Library|System|bin|cores|dev|opt|private|sbin|usr|var|Volumes)(/|$)`,
+		`Library,Applications,.Trash,.cache,.npm,.cargo,.rustup,.gradle,.m2,node_modules`,
+	},
+	"windows": {
+		`Windows,Program Files,Program Files (x86),ProgramData,$Recycle.Bin,$WINDOWS.~BT,System Volume Information,` +
+			`pagefile.sys,hiberfil.sys,swapfile.sys,AppData,node_modules`,
+	},
+}
+
+// returns the patterns of operating system and application files for the
+// platform, falling back to the linux patterns for other unixes
+func userDataPatterns(goos string) patternList {
+	patterns, exists := userDataExcludes[goos]
+	if !exists {
+		patterns = userDataExcludes["linux"]
+	}
+	p := patternList{}
+	for _, value := range patterns {
+		// the patterns are fixed, so they always parse
+		p.Set(value)
+	}
+	return p
+}
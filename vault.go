@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// reports the sizes of the chunks actually stored by a vault, each chunk
+// being a file in the vault's chunk store directory
+func defineVault(flags *flag.FlagSet) func() error {
+	resultFile := flags.String("result", "", "saved result to compare the stored chunks to")
+	return func() error {
+		if flags.NArg() != 1 {
+			return errors.New("usage: chunk_distribution vault [-result result.json] chunk_store_dir")
+		}
+		var predicted *Result
+		if *resultFile != "" {
+			m, err := loadResult(*resultFile)
+			if err != nil {
+				return err
+			}
+			predicted = m.Result
+		}
+		store := flags.Arg(0)
+		if _, err := os.Stat(store); err != nil {
+			return err
+		}
+		stored := vaultChunks(store)
+		reportVault(os.Stdout, store, stored, predicted)
+		return nil
+	}
+}
+
+// returns the stored chunks in a chunk store, as a result where files are
+// the chunk files and the histogram is of their sizes
+func vaultChunks(store string) *Result {
+	r := NewResult()
+	add := func(files []file) {
+		for _, f := range files {
+			size := f.info.Size()
+			r.Files = r.Files + 1
+			r.TotalChunks = r.TotalChunks + 1
+			if size >= r.Rules.ChunkSize {
+				r.LargeChunks = r.LargeChunks + 1
+				r.LargeBytes = r.LargeBytes + size
+			} else {
+				r.SmallChunks = r.SmallChunks + 1
+				r.SmallBytes = r.SmallBytes + size
+			}
+			r.Histogram = chunkdist.AddToHistogram(r.Histogram, size/OneKb, 1)
+		}
+	}
+	w := &walker{ctx: context.Background()}
+	files, dirs := w.walkRoot(store)
+	add(files)
+	for _, dirFiles := range dirs {
+		add(dirFiles)
+	}
+	r.Warnings = w.warnings
+	return r
+}
+
+// prints the stored chunks, and how they compare to the predicted chunks
+// if a result is given
+func reportVault(w io.Writer, store string, stored, predicted *Result) {
+	fmt.Fprintln(w, "Chunk store:", store)
+	fmt.Fprintln(w, "Stored chunks:", stored.TotalChunks)
+	fmt.Fprintf(w, "Stored: %f GB\n", float64(stored.LargeBytes+stored.SmallBytes)/float64(OneGb))
+	fmt.Fprintf(w, "Average chunk size: %.1f KB\n", average(stored.LargeBytes+stored.SmallBytes, stored.TotalChunks*OneKb))
+	fmt.Fprintln(w, "Large chunks:", stored.LargeChunks)
+	fmt.Fprintln(w, "Small chunks:", stored.SmallChunks)
+	fmt.Fprintln(w, "\nChunk Size  Count")
+	chunkdist.WriteHistogram(w, stored.Histogram)
+	if predicted != nil {
+		// a vault stores chunks from many uploaders, so only the shape of
+		// the distribution is comparable, not the counts
+		fmt.Fprintf(w, "\n%-22s %8s %9s\n", "Chunk Size", "Stored", "Predicted")
+		fmt.Fprintf(w, "%-22s %7.1f%% %8.1f%%\n", "Large chunks",
+			percent(stored.LargeChunks, stored.TotalChunks), percent(predicted.LargeChunks, predicted.TotalChunks))
+		keys := []int{}
+		for key := range stored.Histogram {
+			keys = append(keys, int(key))
+		}
+		sort.Ints(keys)
+		for _, key := range keys {
+			k := int64(key)
+			fmt.Fprintf(w, "%-22s %7.1f%% %8.1f%%\n", chunkdist.BucketStart(k),
+				percent(stored.Histogram[k], stored.TotalChunks), percent(predicted.Histogram[k], predicted.TotalChunks))
+		}
+	}
+	reportWarnings(w, stored.Warnings)
+}
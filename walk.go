@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errOpTimeout = errors.New("timed out")
+	// a file's size differs from the size it was listed with
+	errFileChanged = errors.New("changed size during the scan")
+)
+
+// ways of handling symbolic links
+const (
+	symlinksCount  = ""       // count the link itself, as the directory lists it
+	symlinksSkip   = "skip"   // leave links out
+	symlinksFollow = "follow" // count what the link points to
+)
+
+// walker finds the files in a directory, recording warnings about the
+// directories it had to skip
+type walker struct {
+	ctx            context.Context
+	opTimeout      time.Duration
+	projectMarkers []string            // names of files or directories that mark a project
+	readDelay      time.Duration       // added to each directory read, to simulate slow storage
+	onDir          func(entries int64) // called with the number of entries in each directory read
+	workers        int                 // directories read at the same time, one at a time if less than 2
+	root           string              // the directory being scanned, which exclude patterns are relative to
+	exclude        patternList         // files and directories to skip
+	include        patternList         // the only files to find, or every file if empty
+	ignoreFiles    bool                // skip entries matched by .gitignore and .chunkdistignore files
+	symlinks       string              // how symbolic links are handled, one of the symlinks constants
+	xdev           bool                // skip directories on other filesystems than the root, like du -x
+	rootDevice     string              // the filesystem of the root, set by readRoot
+	maxDepth       int                 // levels of directories to read, the root being the first, or every level if zero
+	tooDeep        int64               // directories left out for being below maxDepth
+	special        int64               // sockets, devices, FIFOs and other special files left out
+	changed        int64               // entries deleted between being listed and read
+	mu             sync.Mutex          // guards warnings, visited, tooDeep, special and changed
+	warnings       []Warning
+	visited        map[string]bool // ids of the directories walked when following links
+}
+
+// returns dirname if it is a project, going by the entries in it, or else the
+// project it is part of
+func (w *walker) projectFor(dirname string, files []os.FileInfo, project string) string {
+	for _, info := range files {
+		for _, marker := range w.projectMarkers {
+			if info.Name() == marker {
+				return dirname
+			}
+		}
+	}
+	return project
+}
+
+// reads a directory like readDir, leaving out excluded and ignored entries,
+// and files that aren't included if only some are. It returns the ignore
+// rules that apply in the directory, given those of its parent, to pass on
+// to its subdirectories. A directory that can't be read, such as one the user
+// has no permission for, is skipped with a warning.
+func (w *walker) readEntries(dirname string, parent *ignoreSet) ([]os.FileInfo, *ignoreSet, error) {
+	files, err := w.readDir(dirname)
+	if os.IsNotExist(err) && dirname != w.root {
+		// the directory was deleted after its parent was listed
+		w.mu.Lock()
+		w.changed = w.changed + 1
+		w.mu.Unlock()
+	} else if err != nil && err != errOpTimeout && w.ctx.Err() == nil {
+		w.mu.Lock()
+		w.warnings = append(w.warnings, Warning{
+			Code:    "read_error",
+			Subject: dirname,
+			Message: "skipped, " + err.Error(),
+		})
+		w.mu.Unlock()
+	}
+	if w.symlinks != symlinksCount {
+		files = w.resolveSymlinks(dirname, files)
+	}
+	ignore := parent
+	if w.ignoreFiles {
+		ignore = loadIgnoreFiles(dirname, parent)
+	}
+	if len(w.exclude) == 0 && len(w.include) == 0 && ignore == nil {
+		return files, ignore, err
+	}
+	included := []os.FileInfo{}
+	for _, info := range files {
+		filename := path.Join(dirname, info.Name())
+		rel := w.rel(filename)
+		if w.exclude.matches(rel) || ignore.ignored(filename, info.IsDir()) {
+			continue
+		}
+		if len(w.include) > 0 && !info.IsDir() && !w.include.matches(rel) {
+			continue
+		}
+		included = append(included, info)
+	}
+	return included, ignore, err
+}
+
+// returns the path of a file relative to the root, which exclude patterns are
+// matched against. Paths below the root are clean, as made by path.Join, so
+// the root is cleaned to match them, whether given as ., ./dir or dir/.
+func (w *walker) rel(filename string) string {
+	root := path.Clean(w.root)
+	if filename == root {
+		return ""
+	}
+	if root == "." {
+		return filename
+	}
+	return strings.TrimPrefix(filename, strings.TrimSuffix(root, "/")+"/")
+}
+
+// returns how many directories below the root a directory is, 0 for the root
+func (w *walker) depth(dirname string) int {
+	rel := w.rel(dirname)
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// returns the files directly in a directory, and the files in each of its
+// top level subdirectories, for commands that need every file at once
+func (w *walker) walkRoot(dirname string) ([]file, map[string][]file) {
+	rootFiles, names, project, ignore := w.readRoot(dirname)
+	dirs := map[string][]file{}
+	for _, name := range names {
+		dirFiles := []file{}
+		w.walkTree(path.Join(dirname, name), project, ignore, func(f file) {
+			dirFiles = append(dirFiles, f)
+		})
+		dirs[name] = dirFiles
+	}
+	return rootFiles, dirs
+}
+
+// returns the files directly in a directory, the names of its
+// subdirectories, the project the directory is part of and the ignore rules
+// for its subdirectories
+func (w *walker) readRoot(dirname string) ([]file, []string, string, *ignoreSet) {
+	rootFiles := []file{}
+	names := []string{}
+	files, ignore, err := w.readEntries(dirname, nil)
+	if err == nil && w.onDir != nil {
+		w.onDir(int64(len(files)))
+	}
+	if info, err := os.Stat(dirname); err == nil {
+		w.rootDevice, _ = fileDevice(info)
+		w.enter(dirname, info)
+	}
+	project := w.projectFor(dirname, files, "")
+	for _, info := range files {
+		if info.IsDir() {
+			if w.enter(path.Join(dirname, info.Name()), info) {
+				names = append(names, info.Name())
+			}
+		} else if !w.skipSpecial(info) {
+			rootFiles = append(rootFiles, file{path.Join(dirname, info.Name()), info, project})
+		}
+	}
+	return rootFiles, names, project, ignore
+}
+
+// how many levels below a directory are read to estimate its size
+const estimateDepth = 3
+
+// sorts the subdirectories of a root by their estimated size, largest first,
+// and returns the estimates. Only the first few levels of each are read, which
+// is much quicker than walking a deep tree and enough to find the big ones.
+func (w *walker) largestFirst(dirname string, names []string, ignore *ignoreSet) map[string]int64 {
+	estimates := map[string]int64{}
+	for _, name := range names {
+		estimates[name] = w.estimateBytes(path.Join(dirname, name), estimateDepth, ignore)
+	}
+	sort.SliceStable(names, func(i, j int) bool {
+		return estimates[names[i]] > estimates[names[j]]
+	})
+	return estimates
+}
+
+// returns the bytes in the files of a directory, down to the given depth
+func (w *walker) estimateBytes(dirname string, depth int, ignore *ignoreSet) int64 {
+	if depth <= 0 || w.ctx.Err() != nil {
+		return 0
+	}
+	files, ignore, _ := w.readEntries(dirname, ignore)
+	var bytes int64
+	for _, info := range files {
+		if info.IsDir() {
+			bytes = bytes + w.estimateBytes(path.Join(dirname, info.Name()), depth-1, ignore)
+		} else {
+			bytes = bytes + info.Size()
+		}
+	}
+	return bytes
+}
+
+// calls fn with each file in a directory and its subdirectories, reading
+// directories in parallel if the walker has several workers. fn is never
+// called by more than one goroutine at a time.
+func (w *walker) walkTree(dirname string, project string, ignore *ignoreSet, fn func(file)) {
+	if w.workers > 1 {
+		var mu sync.Mutex // the workers take turns to call fn
+		w.walkDirParallel(dirname, project, ignore, func(_ int, f file) {
+			mu.Lock()
+			defer mu.Unlock()
+			fn(f)
+		})
+		return
+	}
+	w.walkDir(dirname, project, ignore, fn)
+}
+
+// calls fn with each file in a directory like walkTree, and the number of the
+// worker that found it, from 0 to one less than the workers. A worker calls
+// fn for one file at a time, so fn can add to a total for each worker without
+// locking, and the totals be merged once the walk ends.
+func (w *walker) walkTreeWorkers(dirname string, project string, ignore *ignoreSet, fn func(worker int, f file)) {
+	if w.workers > 1 {
+		w.walkDirParallel(dirname, project, ignore, fn)
+		return
+	}
+	w.walkDir(dirname, project, ignore, func(f file) {
+		fn(0, f)
+	})
+}
+
+// calls fn with each file in a directory like walkDir, but with a pool of
+// workers reading subdirectories at the same time, each calling fn with its
+// own number. A subdirectory is read by the worker that found it when the
+// others are all busy, so the pool never waits on itself.
+func (w *walker) walkDirParallel(dirname string, project string, ignore *ignoreSet, fn func(worker int, f file)) {
+	var wg sync.WaitGroup
+	// the numbers of the idle workers, the calling goroutine being worker 0
+	idle := make(chan int, w.workers-1)
+	for worker := 1; worker < w.workers; worker++ {
+		idle <- worker
+	}
+	var visit func(worker int, dirname string, project string, ignore *ignoreSet)
+	visit = func(worker int, dirname string, project string, ignore *ignoreSet) {
+		if w.ctx.Err() != nil {
+			return
+		}
+		files, ignore, err := w.readEntries(dirname, ignore)
+		if err == nil && w.onDir != nil {
+			w.onDir(int64(len(files)))
+		}
+		project = w.projectFor(dirname, files, project)
+		for _, info := range files {
+			filename := path.Join(dirname, info.Name())
+			if !info.IsDir() {
+				if w.skipSpecial(info) {
+					continue
+				}
+				fn(worker, file{filename, info, project})
+				continue
+			}
+			if !w.enter(filename, info) {
+				continue
+			}
+			select {
+			case other := <-idle:
+				wg.Add(1)
+				go func(other int, filename string, project string) {
+					defer wg.Done()
+					defer func() { idle <- other }()
+					visit(other, filename, project, ignore)
+				}(other, filename, project)
+			default:
+				visit(worker, filename, project, ignore)
+			}
+		}
+	}
+	visit(0, dirname, project, ignore)
+	wg.Wait()
+}
+
+// calls fn with each file in a directory, including files in subdirectories,
+// as they are found, stopping early if the context is cancelled. Only the
+// directories being read are held in memory, however many files there are.
+// Files are part of the nearest project above them, which is the given
+// project unless a directory below it is a project.
+func (w *walker) walkDir(dirname string, project string, ignore *ignoreSet, fn func(file)) {
+	if w.ctx.Err() != nil {
+		return
+	}
+	files, ignore, err := w.readEntries(dirname, ignore)
+	if err == nil && w.onDir != nil {
+		w.onDir(int64(len(files)))
+	}
+	project = w.projectFor(dirname, files, project)
+	for _, info := range files {
+		filename := path.Join(dirname, info.Name())
+		if info.IsDir() {
+			if w.enter(filename, info) {
+				w.walkDir(filename, project, ignore, fn)
+			}
+		} else if !w.skipSpecial(info) {
+			fn(file{filename, info, project})
+		}
+	}
+}
+
+// tells if an entry is a special file, such as a socket, device or FIFO,
+// counting it to report how many were left out. Special files hold no data
+// to upload, and a device's size can be that of the whole disk. Symbolic
+// links aren't special, since the link itself is counted unless links are
+// followed or skipped.
+func (w *walker) skipSpecial(info os.FileInfo) bool {
+	mode := info.Mode()
+	if mode.IsRegular() || mode.IsDir() || mode&os.ModeSymlink != 0 {
+		return false
+	}
+	w.mu.Lock()
+	w.special = w.special + 1
+	w.mu.Unlock()
+	return true
+}
+
+// replaces symbolic links in a directory listing with what they point to, or
+// leaves them out if they are skipped. A broken link is skipped with a
+// warning.
+func (w *walker) resolveSymlinks(dirname string, files []os.FileInfo) []os.FileInfo {
+	resolved := []os.FileInfo{}
+	for _, info := range files {
+		if info.Mode()&os.ModeSymlink == 0 {
+			resolved = append(resolved, info)
+			continue
+		}
+		if w.symlinks == symlinksSkip {
+			continue
+		}
+		filename := path.Join(dirname, info.Name())
+		// the info from Stat is named after the link, not its target
+		target, err := statTimeout(filename, w.opTimeout)
+		if err != nil {
+			w.mu.Lock()
+			w.warnings = append(w.warnings, Warning{
+				Code:    "broken_symlink",
+				Subject: filename,
+				Message: "skipped, " + err.Error(),
+			})
+			w.mu.Unlock()
+			continue
+		}
+		resolved = append(resolved, target)
+	}
+	return resolved
+}
+
+// tells if a directory should be walked. Directories below the maximum depth
+// are counted and skipped. A directory on another filesystem
+// than the root is skipped with a warning when the walker stays on one
+// filesystem. When links are followed, a directory reached a second time,
+// through a link to it or to a directory above it, is skipped with a warning
+// so a loop of links can't be walked forever and no directory is counted
+// twice.
+func (w *walker) enter(dirname string, info os.FileInfo) bool {
+	if w.maxDepth > 0 && w.depth(dirname) >= w.maxDepth {
+		w.mu.Lock()
+		w.tooDeep = w.tooDeep + 1
+		w.mu.Unlock()
+		return false
+	}
+	if w.xdev {
+		device, ok := fileDevice(info)
+		if ok && w.rootDevice != "" && device != w.rootDevice {
+			w.mu.Lock()
+			w.warnings = append(w.warnings, Warning{
+				Code:    "other_filesystem",
+				Subject: dirname,
+				Message: "skipped, the directory is on another filesystem",
+			})
+			w.mu.Unlock()
+			return false
+		}
+	}
+	if w.symlinks != symlinksFollow {
+		return true
+	}
+	id, ok := fileID(info)
+	if !ok {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.visited == nil {
+		w.visited = map[string]bool{}
+	}
+	if w.visited[id] {
+		w.warnings = append(w.warnings, Warning{
+			Code:    "symlink_loop",
+			Subject: dirname,
+			Message: "skipped, the directory was already scanned through another path",
+		})
+		return false
+	}
+	w.visited[id] = true
+	return true
+}
+
+// reads a directory, giving up if it takes longer than the op timeout. A
+// directory that times out is skipped with a warning.
+func (w *walker) readDir(dirname string) ([]os.FileInfo, error) {
+	if w.readDelay > 0 {
+		time.Sleep(w.readDelay)
+	}
+	if w.opTimeout <= 0 {
+		return w.listDir(dirname)
+	}
+	type readResult struct {
+		files []os.FileInfo
+		err   error
+	}
+	// a hung filesystem call can't be interrupted, so it is left running
+	done := make(chan readResult, 1)
+	go func() {
+		files, err := w.listDir(dirname)
+		done <- readResult{files, err}
+	}()
+	timer := time.NewTimer(w.opTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.files, r.err
+	case <-w.ctx.Done():
+		return nil, w.ctx.Err()
+	case <-timer.C:
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.warnings = append(w.warnings, Warning{
+			Code:    "op_timeout",
+			Subject: dirname,
+			Message: "skipped, reading the directory took longer than " + w.opTimeout.String(),
+		})
+		return nil, errOpTimeout
+	}
+}
+
+// lists a directory like ioutil.ReadDir, statting each entry once. An entry
+// deleted between listing the directory and statting it is counted as
+// changed and left out, rather than silently dropped. The info of every
+// entry is taken at this point, and the rest of the scan uses it, so a file
+// that changes size later is counted at the size it was listed with.
+func (w *walker) listDir(dirname string) ([]os.FileInfo, error) {
+	entries, err := os.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if os.IsNotExist(err) {
+			w.mu.Lock()
+			w.changed = w.changed + 1
+			w.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			w.mu.Lock()
+			w.warnings = append(w.warnings, Warning{
+				Code:    "read_error",
+				Subject: path.Join(dirname, entry.Name()),
+				Message: "skipped, " + err.Error(),
+			})
+			w.mu.Unlock()
+			continue
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+// stats a file, giving up if it takes longer than the timeout
+func statTimeout(filename string, timeout time.Duration) (os.FileInfo, error) {
+	if timeout <= 0 {
+		return os.Stat(filename)
+	}
+	type statResult struct {
+		info os.FileInfo
+		err  error
+	}
+	done := make(chan statResult, 1)
+	go func() {
+		info, err := os.Stat(filename)
+		done <- statResult{info, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.info, r.err
+	case <-timer.C:
+		return nil, errOpTimeout
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+// A minimal WebSocket server, enough to push text messages to a browser
+// (RFC 6455). Messages from the client are never read, except that the
+// connection closing ends the stream.
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// the GUID the handshake hashes with the client's key
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// completes the WebSocket handshake, returning the connection to send
+// messages on
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (net.Conn, *bufio.ReadWriter, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return nil, nil, errors.New("not a websocket request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, nil, errors.New("missing Sec-WebSocket-Key")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can't be upgraded")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, rw, nil
+}
+
+// writes a text message as a single unmasked frame, as servers send them
+func writeWebSocketText(rw *bufio.ReadWriter, message []byte) error {
+	return writeWebSocketFrame(rw, 0x1, message)
+}
+
+// writes a close frame
+func writeWebSocketClose(rw *bufio.ReadWriter) error {
+	return writeWebSocketFrame(rw, 0x8, nil)
+}
+
+func writeWebSocketFrame(rw *bufio.ReadWriter, opcode byte, payload []byte) error {
+	header := []byte{0x80 | opcode} // final frame
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = append(header, 126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, 127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	rw.Write(header)
+	rw.Write(payload)
+	return rw.Flush()
+}
@@ -0,0 +1,199 @@
+package main
+
+// Writes a result as an Excel workbook. The format is a zip of xml parts,
+// written here directly with the minimum parts Excel and LibreOffice need,
+// using inline strings so there's no shared string table.
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/iancoleman/chunk_distribution/chunkdist"
+)
+
+// a worksheet as rows of cells, each cell a string or a number
+type xlsxSheet struct {
+	name string
+	rows [][]interface{}
+}
+
+func init() {
+	chunkdist.RegisterRenderer("xlsx", resultRenderer(writeXLSX))
+}
+
+// writes a workbook with summary, histogram, directory and extension sheets
+func writeXLSX(w io.Writer, r *Result) error {
+	summary := xlsxSheet{name: "Summary", rows: [][]interface{}{
+		{"Rules", r.Rules.Name},
+		{"Files", r.Files},
+		{"Large files", r.LargeFiles},
+		{"Small files", r.SmallFiles},
+		{"Large bytes", r.LargeBytes},
+		{"Small bytes", r.SmallBytes},
+		{"Total chunks", r.TotalChunks},
+		{"Large chunks", r.LargeChunks},
+		{"Small chunks", r.SmallChunks},
+	}}
+	if u := r.Usage; u != nil {
+		summary.rows = append(summary.rows,
+			[]interface{}{"Wall seconds", u.WallSeconds},
+			[]interface{}{"CPU seconds", u.CPUSeconds},
+			[]interface{}{"Peak RSS bytes", u.PeakRSSBytes},
+			[]interface{}{"Files per second", u.FilesPerSecond},
+			[]interface{}{"Directories read", u.Dirs})
+	}
+	for _, warning := range r.Warnings {
+		summary.rows = append(summary.rows, []interface{}{"Warning", warning.Code, warning.Subject, warning.Message})
+	}
+	histogram := xlsxSheet{name: "Histogram", rows: [][]interface{}{{"Chunk size KB", "Count"}}}
+	keys := []int{}
+	for key := range r.Histogram {
+		keys = append(keys, int(key))
+	}
+	sort.Ints(keys)
+	for _, key := range keys {
+		histogram.rows = append(histogram.rows, []interface{}{int64(key), r.Histogram[int64(key)]})
+	}
+	sheets := []xlsxSheet{
+		summary,
+		histogram,
+		totalsSheet("Directories", "Directory", r.Dirs),
+		totalsSheet("Extensions", "Extension", r.Extensions),
+	}
+	z := zip.NewWriter(w)
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", xlsxContentTypes(len(sheets))},
+		{"_rels/.rels", xlsxRootRels},
+		{"xl/workbook.xml", xlsxWorkbook(sheets)},
+		{"xl/_rels/workbook.xml.rels", xlsxWorkbookRels(len(sheets))},
+	}
+	for i, sheet := range sheets {
+		parts = append(parts, struct {
+			name    string
+			content string
+		}{fmt.Sprintf("xl/worksheets/sheet%v.xml", i+1), xlsxWorksheet(sheet)})
+	}
+	for _, part := range parts {
+		f, err := z.Create(part.name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+// returns a sheet of chunks and bytes for each name, most chunks first
+func totalsSheet(name, heading string, totals map[string]DirTotal) xlsxSheet {
+	names := []string{}
+	for n := range totals {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]].Chunks == totals[names[j]].Chunks {
+			return names[i] < names[j]
+		}
+		return totals[names[i]].Chunks > totals[names[j]].Chunks
+	})
+	sheet := xlsxSheet{name: name, rows: [][]interface{}{{heading, "Chunks", "Bytes"}}}
+	for _, n := range names {
+		sheet.rows = append(sheet.rows, []interface{}{n, totals[n].Chunks, totals[n].Bytes})
+	}
+	return sheet
+}
+
+const xlsxRootRels = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>
+</Relationships>`
+
+func xlsxContentTypes(sheets int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">
+<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>
+<Default Extension="xml" ContentType="application/xml"/>
+<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>
+`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Override PartName="/xl/worksheets/sheet%v.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>`+"\n", i)
+	}
+	b.WriteString(`</Types>`)
+	return b.String()
+}
+
+func xlsxWorkbook(sheets []xlsxSheet) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets>
+`)
+	for i, sheet := range sheets {
+		fmt.Fprintf(&b, `<sheet name="%v" sheetId="%v" r:id="rId%v"/>`+"\n", xmlEscape(sheet.name), i+1, i+1)
+	}
+	b.WriteString(`</sheets>
+</workbook>`)
+	return b.String()
+}
+
+func xlsxWorkbookRels(sheets int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+`)
+	for i := 1; i <= sheets; i++ {
+		fmt.Fprintf(&b, `<Relationship Id="rId%v" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet%v.xml"/>`+"\n", i, i)
+	}
+	b.WriteString(`</Relationships>`)
+	return b.String()
+}
+
+func xlsxWorksheet(sheet xlsxSheet) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<sheetData>
+`)
+	for i, row := range sheet.rows {
+		fmt.Fprintf(&b, `<row r="%v">`, i+1)
+		for j, cell := range row {
+			ref := xlsxColumn(j) + strconv.Itoa(i+1)
+			switch v := cell.(type) {
+			case int64, float64:
+				fmt.Fprintf(&b, `<c r="%v"><v>%v</v></c>`, ref, v)
+			default:
+				fmt.Fprintf(&b, `<c r="%v" t="inlineStr"><is><t xml:space="preserve">%v</t></is></c>`, ref, xmlEscape(fmt.Sprint(v)))
+			}
+		}
+		b.WriteString("</row>\n")
+	}
+	b.WriteString(`</sheetData>
+</worksheet>`)
+	return b.String()
+}
+
+// returns the column letters for a zero based column index, A to Z then AA
+func xlsxColumn(i int) string {
+	name := ""
+	for i = i + 1; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// escapes text for use in xml content and attributes
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}